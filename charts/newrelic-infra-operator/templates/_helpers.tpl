@@ -157,6 +157,10 @@ Returns configmap data
 {{- $sidecarPullPolicy := include "newrelic-infra-operator.sidecar.imagePullPolicy" . -}}
 {{- $_ := set $config.infraAgentInjection.agentConfig.image "pullPolicy" $sidecarPullPolicy -}}
 {{- $_ := unset $config.infraAgentInjection.agentConfig.image "registry" -}}
+{{- if include "newrelic-infra-operator.leaderElection" . -}}
+{{- $_ := set $config "leaderElection" true -}}
+{{- $_ := set $config "leaderElectionNamespace" .Release.Namespace -}}
+{{- end -}}
 {{ toYaml $config }}
 {{- end }}
 
@@ -213,3 +217,12 @@ Returns the pull policy for admission webhooks patch job image, respecting globa
   IfNotPresent
 {{- end -}}
 {{- end -}}
+
+{{/*
+Returns true if leader election between operator replicas should be enabled.
+*/}}
+{{- define "newrelic-infra-operator.leaderElection" -}}
+{{- if or (gt (int .Values.replicas) 1) (.Values.config).leaderElection -}}
+true
+{{- end -}}
+{{- end -}}
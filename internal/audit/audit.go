@@ -0,0 +1,169 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package audit implements sinks for admission decision audit records.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+const (
+	// SinkNone disables emitting audit records.
+	SinkNone = ""
+
+	// SinkStdout writes audit records as JSON lines to the standard output.
+	SinkStdout = "stdout"
+
+	// SinkFile writes audit records as JSON lines to the configured file.
+	SinkFile = "file"
+
+	// SinkHTTP buffers audit records locally and periodically sends them as JSON lines to the configured URL.
+	SinkHTTP = "http"
+
+	// DefaultBufferSize is a default number of records HTTP sink keeps in memory when endpoint is not reachable.
+	DefaultBufferSize = 10000
+
+	// DefaultFlushInterval is a default interval in which HTTP sink sends buffered records.
+	DefaultFlushInterval = 10 * time.Second
+
+	// Decision values used in records.
+	DecisionInjected = "injected"
+	DecisionSkipped  = "skipped"
+	DecisionErrored  = "errored"
+	DecisionIgnored  = "error-ignored"
+
+	auditFileMode = 0o600
+)
+
+// Record represents decision made for single admission request.
+type Record struct {
+	Time         time.Time `json:"time"`
+	UID          string    `json:"uid"`
+	Namespace    string    `json:"namespace"`
+	GenerateName string    `json:"generateName,omitempty"`
+	Owner        string    `json:"owner,omitempty"`
+	Decision     string    `json:"decision"`
+	Reason       string    `json:"reason,omitempty"`
+	PolicyIndex  int       `json:"policyIndex"`
+	Rule         string    `json:"rule,omitempty"`
+	ConfigHash   string    `json:"configHash,omitempty"`
+	DryRun       bool      `json:"dryRun"`
+	Errors       []string  `json:"errors,omitempty"`
+	LatencyMs    float64   `json:"latencyMs"`
+}
+
+// Sink receives audit records. Implementations must not block the caller for extended period of time.
+type Sink interface {
+	Emit(record Record)
+	Close() error
+}
+
+// Config holds configuration of audit sink.
+type Config struct {
+	Sink          string          `json:"sink"`
+	Path          string          `json:"path"`
+	URL           string          `json:"url"`
+	BufferSize    int             `json:"bufferSize"`
+	FlushInterval metav1.Duration `json:"flushInterval"`
+}
+
+// New creates audit sink from the configuration. If no sink is configured, sink discarding all records is returned.
+//
+//nolint:ireturn
+func (config Config) New(logger logr.Logger) (Sink, error) {
+	if err := config.Validate(field.NewPath("audit")).ToAggregate(); err != nil {
+		return nil, err
+	}
+
+	switch config.Sink {
+	case SinkNone:
+		return discardSink{}, nil
+	case SinkStdout:
+		return &writerSink{w: os.Stdout, logger: logger}, nil
+	case SinkFile:
+		//nolint:gosec // Path comes from operator configuration.
+		f, err := os.OpenFile(config.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, auditFileMode)
+		if err != nil {
+			return nil, fmt.Errorf("opening audit file %q: %w", config.Path, err)
+		}
+
+		return &writerSink{w: f, closer: f, logger: logger}, nil
+	default:
+		return newHTTPSink(config, logger), nil
+	}
+}
+
+// Validate validates the configuration, returning all found errors with paths of invalid fields relative to
+// given path.
+func (config Config) Validate(fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	switch config.Sink {
+	case SinkNone, SinkStdout:
+	case SinkFile:
+		if config.Path == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("path"), "path must be set for file audit sink"))
+		}
+	case SinkHTTP:
+		if config.URL == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("url"), "url must be set for http audit sink"))
+		}
+	default:
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("sink"), config.Sink,
+			[]string{SinkStdout, SinkFile, SinkHTTP}))
+	}
+
+	return allErrs
+}
+
+type discardSink struct{}
+
+func (discardSink) Emit(Record) {}
+
+func (discardSink) Close() error { return nil }
+
+// writerSink writes records as JSON lines to given writer.
+type writerSink struct {
+	lock   sync.Mutex
+	w      io.Writer
+	closer io.Closer
+	logger logr.Logger
+}
+
+func (s *writerSink) Emit(record Record) {
+	b, err := json.Marshal(record)
+	if err != nil {
+		s.logger.Error(err, "Marshaling audit record")
+
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, err := s.w.Write(append(b, '\n')); err != nil {
+		s.logger.Error(err, "Writing audit record")
+	}
+}
+
+func (s *writerSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+
+	if err := s.closer.Close(); err != nil {
+		return fmt.Errorf("closing audit sink: %w", err)
+	}
+
+	return nil
+}
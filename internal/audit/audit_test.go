@@ -0,0 +1,186 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package audit_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr/testr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/newrelic/newrelic-infra-operator/internal/audit"
+)
+
+//nolint:funlen,gocognit,cyclop
+func Test_Audit_sink(t *testing.T) {
+	t.Parallel()
+
+	t.Run("file_sink_writes_records_as_JSON_lines", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "audit.log")
+
+		sink, err := audit.Config{Sink: audit.SinkFile, Path: path}.New(testr.New(t))
+		if err != nil {
+			t.Fatalf("creating sink: %v", err)
+		}
+
+		sink.Emit(audit.Record{UID: "first", Decision: audit.DecisionInjected})
+		sink.Emit(audit.Record{UID: "second", Decision: audit.DecisionSkipped})
+
+		if err := sink.Close(); err != nil {
+			t.Fatalf("closing sink: %v", err)
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("opening audit file: %v", err)
+		}
+
+		t.Cleanup(func() {
+			_ = f.Close()
+		})
+
+		records := decodeRecords(t, f)
+
+		if len(records) != 2 || records[0].UID != "first" || records[1].UID != "second" {
+			t.Fatalf("unexpected records: %v", records)
+		}
+	})
+
+	t.Run("http_sink_sends_buffered_records", func(t *testing.T) {
+		t.Parallel()
+
+		received := make(chan []audit.Record, 1)
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received <- decodeRecords(t, r.Body)
+		}))
+
+		t.Cleanup(server.Close)
+
+		sink, err := audit.Config{
+			Sink:          audit.SinkHTTP,
+			URL:           server.URL,
+			FlushInterval: metav1.Duration{Duration: 10 * time.Millisecond},
+		}.New(testr.New(t))
+		if err != nil {
+			t.Fatalf("creating sink: %v", err)
+		}
+
+		t.Cleanup(func() {
+			_ = sink.Close()
+		})
+
+		sink.Emit(audit.Record{UID: "first"})
+
+		select {
+		case records := <-received:
+			if len(records) != 1 || records[0].UID != "first" {
+				t.Fatalf("unexpected records: %v", records)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for records")
+		}
+	})
+
+	t.Run("http_sink_retries_records_when_endpoint_fails", func(t *testing.T) {
+		t.Parallel()
+
+		lock := sync.Mutex{}
+		failures := 1
+		received := make(chan []audit.Record, 1)
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			defer lock.Unlock()
+
+			if failures > 0 {
+				failures--
+
+				w.WriteHeader(http.StatusServiceUnavailable)
+
+				return
+			}
+
+			received <- decodeRecords(t, r.Body)
+		}))
+
+		t.Cleanup(server.Close)
+
+		sink, err := audit.Config{
+			Sink:          audit.SinkHTTP,
+			URL:           server.URL,
+			FlushInterval: metav1.Duration{Duration: 10 * time.Millisecond},
+		}.New(testr.New(t))
+		if err != nil {
+			t.Fatalf("creating sink: %v", err)
+		}
+
+		t.Cleanup(func() {
+			_ = sink.Close()
+		})
+
+		sink.Emit(audit.Record{UID: "first"})
+
+		select {
+		case records := <-received:
+			if len(records) != 1 || records[0].UID != "first" {
+				t.Fatalf("unexpected records: %v", records)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for records")
+		}
+	})
+
+	t.Run("fails_when", func(t *testing.T) {
+		t.Parallel()
+
+		cases := map[string]audit.Config{
+			"sink_is_unknown":              {Sink: "foo"},
+			"file_sink_has_no_path_set":    {Sink: audit.SinkFile},
+			"http_sink_has_no_url_set":     {Sink: audit.SinkHTTP},
+			"file_sink_path_is_not_usable": {Sink: audit.SinkFile, Path: t.TempDir()},
+		}
+
+		for testCaseName, config := range cases {
+			config := config
+
+			t.Run(testCaseName, func(t *testing.T) {
+				t.Parallel()
+
+				if _, err := config.New(testr.New(t)); err == nil {
+					t.Fatalf("expected error creating sink")
+				}
+			})
+		}
+	})
+}
+
+func decodeRecords(t *testing.T, r io.Reader) []audit.Record {
+	t.Helper()
+
+	records := []audit.Record{}
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		record := audit.Record{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Errorf("decoding record: %v", err)
+		}
+
+		records = append(records, record)
+	}
+
+	return records
+}
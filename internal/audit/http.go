@@ -0,0 +1,183 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+const (
+	httpSendTimeout = 10 * time.Second
+	contentType     = "application/x-ndjson"
+)
+
+// httpSink buffers records in memory and periodically sends them to configured endpoint. When endpoint
+// is not available, records are kept in the buffer and sent with the next flush. If buffer gets full,
+// the oldest records are dropped.
+type httpSink struct {
+	url           string
+	bufferSize    int
+	flushInterval time.Duration
+	client        *http.Client
+	logger        logr.Logger
+
+	lock    sync.Mutex
+	records []Record
+	dropped int
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+func newHTTPSink(config Config, logger logr.Logger) *httpSink {
+	s := &httpSink{
+		url:           config.URL,
+		bufferSize:    config.BufferSize,
+		flushInterval: config.FlushInterval.Duration,
+		client:        &http.Client{Timeout: httpSendTimeout},
+		logger:        logger,
+		stopCh:        make(chan struct{}),
+		doneCh:        make(chan struct{}),
+	}
+
+	if s.bufferSize <= 0 {
+		s.bufferSize = DefaultBufferSize
+	}
+
+	if s.flushInterval <= 0 {
+		s.flushInterval = DefaultFlushInterval
+	}
+
+	go s.run()
+
+	return s
+}
+
+func (s *httpSink) Emit(record Record) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.records) >= s.bufferSize {
+		s.records = s.records[1:]
+		s.dropped++
+	}
+
+	s.records = append(s.records, record)
+}
+
+// Close stops periodic flushing and makes a last attempt to send buffered records.
+func (s *httpSink) Close() error {
+	close(s.stopCh)
+	<-s.doneCh
+
+	return s.flush()
+}
+
+func (s *httpSink) run() {
+	defer close(s.doneCh)
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			if err := s.flush(); err != nil {
+				s.logger.Error(err, "Sending audit records, will retry", "buffered", s.buffered())
+			}
+		}
+	}
+}
+
+func (s *httpSink) buffered() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return len(s.records)
+}
+
+func (s *httpSink) flush() error {
+	s.lock.Lock()
+	records := s.records
+	dropped := s.dropped
+	s.records = nil
+	s.dropped = 0
+	s.lock.Unlock()
+
+	if dropped > 0 {
+		s.logger.Info("Audit buffer was full, dropped oldest records", "dropped", dropped)
+	}
+
+	if len(records) == 0 {
+		return nil
+	}
+
+	if err := s.send(records); err != nil {
+		s.requeue(records)
+
+		return err
+	}
+
+	return nil
+}
+
+// requeue puts records which failed to be sent back in front of the buffer, respecting buffer size.
+func (s *httpSink) requeue(records []Record) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.records = append(records, s.records...)
+
+	if overflow := len(s.records) - s.bufferSize; overflow > 0 {
+		s.records = s.records[overflow:]
+		s.dropped += overflow
+	}
+}
+
+func (s *httpSink) send(records []Record) error {
+	body := &bytes.Buffer{}
+	encoder := json.NewEncoder(body)
+
+	for _, r := range records {
+		if err := encoder.Encode(r); err != nil {
+			return fmt.Errorf("encoding audit record: %w", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), httpSendTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, body)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending audit records: %w", err)
+	}
+
+	if err := resp.Body.Close(); err != nil {
+		s.logger.Error(err, "Closing audit endpoint response body")
+	}
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		//nolint:err113
+		return fmt.Errorf("audit endpoint responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
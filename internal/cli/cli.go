@@ -6,9 +6,13 @@ package cli
 
 import (
 	"errors"
+	"flag"
 	"fmt"
 	"io/fs"
+	"maps"
 	"os"
+	"reflect"
+	"slices"
 
 	"sigs.k8s.io/yaml"
 
@@ -24,13 +28,111 @@ const (
 
 	// EnvClusterName is an environment variable from which cluster name will be read if not set in configuration file.
 	EnvClusterName = "CLUSTER_NAME"
+
+	// EnvConfigFilePath is an environment variable from which path to the configuration file will be read.
+	EnvConfigFilePath = EnvPrefix + "CONFIG"
 )
 
-// Options tries to read configuration from a given path and later fills missing configuration using
-// environment variables.
+var errUnexpectedArguments = errors.New("unexpected arguments")
+
+// flagOption binds a command-line flag to an option identified by its path in the configuration file.
+type flagOption struct {
+	name  string
+	path  string
+	usage string
+}
+
+//nolint:gochecknoglobals
+var flagOptions = []flagOption{
+	{name: "port", path: "port", usage: "Port on which webhook server listens."},
+	{name: "cert-dir", path: "certDir", usage: "Directory with webhook server TLS certificate and key."},
+	{name: "metrics-bind-address", path: "metricsBindAddress", usage: "Address on which metrics are served."},
+	{name: "health-probe-bind-address", path: "healthProbeBindAddress", usage: "Address on which health probes are served."},
+	{name: "log-level", path: "logLevel", usage: `Log level, one of "debug", "info", "error".`},
+	{name: "log-format", path: "logFormat", usage: `Log format, one of "json", "console".`},
+	{name: "ignore-mutation-errors", path: "ignoreMutationErrors", usage: "Admit Pods when sidecar injection fails."},
+}
+
+// Options tries to read configuration from a given path and later overrides it using environment variables,
+// as described in Load.
 //
 // If configuration file is not found, only environment variables will be read.
 func Options(path string) (*operator.Options, error) {
+	return Load([]string{"-config", path}, os.Getenv)
+}
+
+// Load builds operator configuration from given command-line arguments, environment variables
+// returned by getenv and configuration file, in that order of precedence. Options not set by any of them
+// are defaulted by the operator.
+//
+// Path to the configuration file is taken from "-config" flag, EnvConfigFilePath environment variable or
+// DefaultConfigFilePath. If configuration file is not found, it is ignored.
+func Load(args []string, getenv func(string) string) (*operator.Options, error) {
+	env := envOptions()
+	flags, configPath := newFlagSet(env)
+
+	if err := flags.Parse(args); err != nil {
+		return nil, fmt.Errorf("parsing flags: %w", err)
+	}
+
+	if flags.NArg() > 0 {
+		return nil, fmt.Errorf("%w: %v", errUnexpectedArguments, flags.Args())
+	}
+
+	path := DefaultConfigFilePath
+	if p := getenv(EnvConfigFilePath); p != "" {
+		path = p
+	}
+
+	if *configPath != "" {
+		path = *configPath
+	}
+
+	options, err := optionsFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Empty environment variables are treated as not set, as this is how unset variables are usually
+	// represented in templated manifests.
+	for _, path := range slices.Sorted(maps.Keys(env)) {
+		option := env[path]
+
+		if value := getenv(option.env); value != "" {
+			if err := option.set(options, value); err != nil {
+				return nil, fmt.Errorf("reading environment variable %q: %w", option.env, err)
+			}
+		}
+	}
+
+	var flagErr error
+
+	flags.Visit(func(f *flag.Flag) {
+		for _, fo := range flagOptions {
+			if fo.name != f.Name || flagErr != nil {
+				continue
+			}
+
+			if err := env[fo.path].set(options, f.Value.String()); err != nil {
+				flagErr = fmt.Errorf("reading flag %q: %w", f.Name, err)
+			}
+		}
+	})
+
+	if flagErr != nil {
+		return nil, flagErr
+	}
+
+	options.InfraAgentInjection.License = getenv(EnvLicenseKey)
+
+	if options.InfraAgentInjection.ClusterName == "" {
+		options.InfraAgentInjection.ClusterName = getenv(EnvClusterName)
+	}
+
+	return options, nil
+}
+
+func optionsFromFile(path string) (*operator.Options, error) {
 	options := &operator.Options{}
 
 	optionsBytes, err := os.ReadFile(path)
@@ -42,11 +144,27 @@ func Options(path string) (*operator.Options, error) {
 		return nil, fmt.Errorf("parsing configuration file content: %w", err)
 	}
 
-	options.InfraAgentInjection.License = os.Getenv(EnvLicenseKey)
+	return options, nil
+}
+
+func newFlagSet(env map[string]envOption) (*flag.FlagSet, *string) {
+	flags := flag.NewFlagSet("newrelic-infra-operator", flag.ContinueOnError)
 
-	if options.InfraAgentInjection.ClusterName == "" {
-		options.InfraAgentInjection.ClusterName = os.Getenv(EnvClusterName)
+	configPath := flags.String("config", "",
+		fmt.Sprintf("Path to the configuration file (default %q). Overrides %s environment variable.",
+			DefaultConfigFilePath, EnvConfigFilePath))
+
+	for _, fo := range flagOptions {
+		usage := fmt.Sprintf("%s Overrides %s environment variable.", fo.usage, env[fo.path].env)
+
+		if env[fo.path].kind == reflect.Bool {
+			flags.Bool(fo.name, false, usage)
+
+			continue
+		}
+
+		flags.String(fo.name, "", usage)
 	}
 
-	return options, nil
+	return flags, configPath
 }
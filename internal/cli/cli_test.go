@@ -4,12 +4,22 @@
 package cli_test
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/newrelic/newrelic-infra-operator/internal/cli"
+	"github.com/newrelic/newrelic-infra-operator/internal/mutator/pod/agent"
+	"github.com/newrelic/newrelic-infra-operator/internal/operator"
 )
 
 const (
@@ -184,3 +194,380 @@ func withTestConfigFile(t *testing.T, config string) string {
 
 	return configPath
 }
+
+//nolint:funlen
+func Test_Validate(t *testing.T) {
+	t.Parallel()
+
+	t.Run("reports_errors_and_warnings_as_JSON", func(t *testing.T) {
+		t.Parallel()
+
+		config := `
+infraAgentInjection:
+  agentConfig:
+    image:
+      repository: newrelic/infrastructure-k8s
+    configSelectors:
+    - labelSelector: {}
+    - labelSelector:
+        matchLabels:
+          app: foo
+  policies:
+  - action: skip
+`
+
+		diagnostics, err := cli.Validate(withTestConfigFile(t, config))
+		if err != nil {
+			t.Fatalf("validating configuration: %v", err)
+		}
+
+		if !cli.HasErrors(diagnostics) {
+			t.Fatalf("expected diagnostics to contain errors")
+		}
+
+		output := &bytes.Buffer{}
+
+		if err := cli.WriteDiagnostics(output, diagnostics, cli.OutputJSON); err != nil {
+			t.Fatalf("writing diagnostics: %v", err)
+		}
+
+		decoded := []cli.Diagnostic{}
+		if err := json.Unmarshal(output.Bytes(), &decoded); err != nil {
+			t.Fatalf("decoding diagnostics: %v", err)
+		}
+
+		expected := []cli.Diagnostic{
+			{
+				Severity: cli.SeverityError,
+				Field:    "infraAgentInjection.agentConfig.image.tag",
+				Message:  "Required value: image tag must be set",
+			},
+			{
+				Severity: cli.SeverityError,
+				Field:    "infraAgentInjection.resourcePrefix",
+				Message:  "Required value: resource prefix must be set",
+			},
+			{
+				Severity: cli.SeverityError,
+				Field:    "infraAgentInjection.policies[0].action",
+				Message:  `Unsupported value: "skip": supported values: "include", "exclude"`,
+			},
+			{
+				Severity: cli.SeverityWarning,
+				Field:    "infraAgentInjection.agentConfig.configSelectors[1].labelSelector",
+				Message: `Invalid value: "&LabelSelector{MatchLabels:map[string]string{app: foo,},MatchExpressions:[]LabelSelectorRequirement{},}": ` +
+					"config selector is unreachable, as all Pods it matches are matched by " +
+					"infraAgentInjection.agentConfig.configSelectors[0] first",
+			},
+		}
+
+		if diff := cmp.Diff(expected, decoded); diff != "" {
+			t.Fatalf("unexpected diagnostics: %s", diff)
+		}
+	})
+
+	t.Run("reports_error_when", func(t *testing.T) {
+		t.Parallel()
+
+		cases := map[string]struct {
+			config        string
+			expectedField string
+		}{
+			"audit_sink_is_not_supported": {
+				config:        "audit:\n  sink: foo\n",
+				expectedField: "audit.sink",
+			},
+			"audit_file_sink_has_no_path": {
+				config:        "audit:\n  sink: file\n",
+				expectedField: "audit.path",
+			},
+			"audit_http_sink_has_no_url": {
+				config:        "audit:\n  sink: http\n",
+				expectedField: "audit.url",
+			},
+			"tracing_sampling_ratio_is_out_of_range": {
+				config:        "tracing:\n  samplingRatio: 2\n",
+				expectedField: "tracing.samplingRatio",
+			},
+			"log_level_is_not_supported": {
+				config:        "logLevel: trace\n",
+				expectedField: "logLevel",
+			},
+			"log_format_is_not_supported": {
+				config:        "logFormat: text\n",
+				expectedField: "logFormat",
+			},
+			"log_sampling_thereafter_is_zero": {
+				config:        "logSampling:\n  initial: 100\n  thereafter: 0\n",
+				expectedField: "logSampling.thereafter",
+			},
+		}
+
+		for testCaseName, testData := range cases {
+			testData := testData
+
+			t.Run(testCaseName, func(t *testing.T) {
+				t.Parallel()
+
+				diagnostics, err := cli.Validate(withTestConfigFile(t, testData.config))
+				if err != nil {
+					t.Fatalf("validating configuration: %v", err)
+				}
+
+				for _, diagnostic := range diagnostics {
+					if diagnostic.Severity == cli.SeverityError && diagnostic.Field == testData.expectedField {
+						return
+					}
+				}
+
+				t.Fatalf("expected error for field %q, got: %v", testData.expectedField, diagnostics)
+			})
+		}
+	})
+
+	t.Run("reports_unknown_keys_as_error", func(t *testing.T) {
+		t.Parallel()
+
+		diagnostics, err := cli.Validate(withTestConfigFile(t, "nonExistingKey: foo\n"))
+		if err != nil {
+			t.Fatalf("validating configuration: %v", err)
+		}
+
+		if !cli.HasErrors(diagnostics) {
+			t.Fatalf("expected diagnostics to contain errors")
+		}
+	})
+
+	t.Run("returns_error_when_config_file_does_not_exist", func(t *testing.T) {
+		t.Parallel()
+
+		if _, err := cli.Validate(filepath.Join(t.TempDir(), "config.yaml")); err == nil {
+			t.Fatalf("expected error when validating non existing file")
+		}
+	})
+}
+
+func Test_Schema_file_is_up_to_date(t *testing.T) {
+	t.Parallel()
+
+	expected, err := os.ReadFile(filepath.Join("..", "..", cli.SchemaFile))
+	if err != nil {
+		t.Fatalf("reading schema file: %v", err)
+	}
+
+	generated := &bytes.Buffer{}
+
+	if err := cli.WriteSchema(generated); err != nil {
+		t.Fatalf("generating schema: %v", err)
+	}
+
+	if diff := cmp.Diff(string(expected), generated.String()); diff != "" {
+		t.Fatalf("schema file is out of date, run 'go generate' to update it: %s", diff)
+	}
+}
+
+//nolint:funlen
+func Test_Load(t *testing.T) {
+	t.Parallel()
+
+	config := `
+port: 1
+certDir: /from/file
+logLevel: error
+ignoreMutationErrors: true
+infraAgentInjection:
+  resourcePrefix: file-prefix
+`
+
+	cases := map[string]struct {
+		args     func(configPath string) []string
+		env      func(configPath string) map[string]string
+		expected func(*operator.Options)
+	}{
+		"reads_options_from_config_file": {
+			expected: func(o *operator.Options) {
+				o.Port = 1
+				o.CertDir = "/from/file"
+				o.LogLevel = "error"
+				o.IgnoreMutationErrors = true
+				o.InfraAgentInjection.ResourcePrefix = "file-prefix"
+			},
+		},
+		"overrides_config_file_with_environment_variables": {
+			env: func(string) map[string]string {
+				return map[string]string{
+					"NEWRELIC_INFRA_OPERATOR_PORT":                                  "2",
+					"NEWRELIC_INFRA_OPERATOR_LOG_FORMAT":                            "console",
+					"NEWRELIC_INFRA_OPERATOR_IGNORE_MUTATION_ERRORS":                "false",
+					"NEWRELIC_INFRA_OPERATOR_LEADER_ELECTION_ID":                    "test-lease",
+					"NEWRELIC_INFRA_OPERATOR_INFRA_AGENT_INJECTION_RESOURCE_PREFIX": "env-prefix",
+					"NEWRELIC_INFRA_OPERATOR_AUDIT_FLUSH_INTERVAL":                  "5s",
+					"NEWRELIC_INFRA_OPERATOR_INFRA_AGENT_INJECTION_POLICIES":        `[{"namespaceName":"foo"}]`,
+				}
+			},
+			expected: func(o *operator.Options) {
+				o.Port = 2
+				o.CertDir = "/from/file"
+				o.LogLevel = "error"
+				o.LogFormat = "console"
+				o.LeaderElectionID = "test-lease"
+				o.InfraAgentInjection.ResourcePrefix = "env-prefix"
+				o.InfraAgentInjection.Policies = []agent.InjectionPolicy{{NamespaceName: "foo"}}
+				o.Audit.FlushInterval = metav1.Duration{Duration: 5 * time.Second}
+			},
+		},
+		"overrides_environment_variables_with_flags": {
+			args: func(configPath string) []string {
+				return []string{
+					"-config", configPath,
+					"-port", "3",
+					"-cert-dir", "/from/flag",
+					"-metrics-bind-address", ":8080",
+					"-health-probe-bind-address", ":9090",
+					"-log-level", "debug",
+					"-log-format", "json",
+					"-ignore-mutation-errors=false",
+				}
+			},
+			env: func(string) map[string]string {
+				return map[string]string{
+					"NEWRELIC_INFRA_OPERATOR_PORT":       "2",
+					"NEWRELIC_INFRA_OPERATOR_LOG_FORMAT": "console",
+					"NEWRELIC_INFRA_OPERATOR_CONFIG":     "/non/existing/config.yaml",
+				}
+			},
+			expected: func(o *operator.Options) {
+				o.Port = 3
+				o.CertDir = "/from/flag"
+				o.MetricsBindAddress = ":8080"
+				o.HealthProbeBindAddress = ":9090"
+				o.LogLevel = "debug"
+				o.LogFormat = "json"
+				o.InfraAgentInjection.ResourcePrefix = "file-prefix"
+			},
+		},
+		"reads_config_file_path_from_environment_variable": {
+			args: func(string) []string { return nil },
+			env: func(configPath string) map[string]string {
+				return map[string]string{cli.EnvConfigFilePath: configPath}
+			},
+			expected: func(o *operator.Options) {
+				o.Port = 1
+				o.CertDir = "/from/file"
+				o.LogLevel = "error"
+				o.IgnoreMutationErrors = true
+				o.InfraAgentInjection.ResourcePrefix = "file-prefix"
+			},
+		},
+	}
+
+	for testCaseName, testData := range cases {
+		testData := testData
+
+		t.Run(testCaseName, func(t *testing.T) {
+			t.Parallel()
+
+			configPath := withTestConfigFile(t, config)
+
+			args := []string{"-config", configPath}
+			if testData.args != nil {
+				args = testData.args(configPath)
+			}
+
+			env := map[string]string{}
+			if testData.env != nil {
+				env = testData.env(configPath)
+			}
+
+			options, err := cli.Load(args, func(name string) string { return env[name] })
+			if err != nil {
+				t.Fatalf("loading options: %v", err)
+			}
+
+			expected := &operator.Options{}
+			testData.expected(expected)
+
+			if diff := cmp.Diff(expected, options, cmpopts.IgnoreUnexported(logr.Logger{}, agent.InjectionPolicy{})); diff != "" {
+				t.Fatalf("unexpected options: %s", diff)
+			}
+		})
+	}
+
+	t.Run("returns_error_when", func(t *testing.T) {
+		t.Parallel()
+
+		cases := map[string]struct {
+			args []string
+			env  map[string]string
+		}{
+			"environment_variable_has_invalid_value": {
+				env: map[string]string{"NEWRELIC_INFRA_OPERATOR_PORT": "foo"},
+			},
+			"flag_has_invalid_value": {
+				args: []string{"-port", "foo"},
+			},
+			"unknown_flag_is_given": {
+				args: []string{"-foo"},
+			},
+			"unexpected_argument_is_given": {
+				args: []string{"foo"},
+			},
+		}
+
+		for testCaseName, testData := range cases {
+			testData := testData
+
+			t.Run(testCaseName, func(t *testing.T) {
+				t.Parallel()
+
+				args := append([]string{"-config", withTestConfigFile(t, "")}, testData.args...)
+
+				if _, err := cli.Load(args, func(name string) string { return testData.env[name] }); err == nil {
+					t.Fatalf("expected error while loading options")
+				}
+			})
+		}
+	})
+}
+
+func Test_NewLogger(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		options     operator.Options
+		expectError bool
+	}{
+		"succeeds_with_default_options": {},
+		"succeeds_with_all_options_set": {
+			options: operator.Options{
+				LogLevel:    "debug",
+				LogFormat:   cli.LogFormatConsole,
+				LogSampling: operator.LogSampling{Initial: 100, Thereafter: 100},
+			},
+		},
+		"fails_with_unsupported_log_level": {
+			options:     operator.Options{LogLevel: "trace"},
+			expectError: true,
+		},
+		"fails_with_unsupported_log_format": {
+			options:     operator.Options{LogFormat: "xml"},
+			expectError: true,
+		},
+		"fails_with_log_sampling_without_thereafter": {
+			options:     operator.Options{LogSampling: operator.LogSampling{Initial: 100}},
+			expectError: true,
+		},
+	}
+
+	for testCaseName, testData := range cases {
+		testData := testData
+
+		t.Run(testCaseName, func(t *testing.T) {
+			t.Parallel()
+
+			if _, err := cli.NewLogger(testData.options); (err != nil) != testData.expectError {
+				t.Fatalf("expected error: %v, got: %v", testData.expectError, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cli
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"unicode"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/newrelic/newrelic-infra-operator/internal/operator"
+)
+
+// EnvPrefix is a prefix of environment variables overriding options from configuration file.
+//
+// Every option has an environment variable named by joining EnvPrefix with option path in configuration file,
+// converted to upper snake case, e.g. "infraAgentInjection.resourcePrefix" option can be set using
+// NEWRELIC_INFRA_OPERATOR_INFRA_AGENT_INJECTION_RESOURCE_PREFIX environment variable. Values of options which
+// are not strings are parsed as YAML, so lists and objects can be set using JSON.
+const EnvPrefix = "NEWRELIC_INFRA_OPERATOR_"
+
+// envOption maps environment variable to a field of operator.Options.
+type envOption struct {
+	env   string
+	kind  reflect.Kind
+	index []int
+}
+
+// envOptions returns environment variables for all options indexed by option path in configuration file.
+func envOptions() map[string]envOption {
+	options := map[string]envOption{}
+
+	addEnvOptions(options, reflect.TypeFor[operator.Options](), nil, "", EnvPrefix)
+
+	return options
+}
+
+func addEnvOptions(options map[string]envOption, t reflect.Type, index []int, pathPrefix, envPrefix string) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" || name == "" || !f.IsExported() {
+			continue
+		}
+
+		fieldIndex := append(append([]int{}, index...), i)
+		path := pathPrefix + name
+		env := envPrefix + upperSnakeCase(name)
+
+		if f.Type.Kind() == reflect.Struct && !reflect.PointerTo(f.Type).Implements(reflect.TypeFor[json.Unmarshaler]()) {
+			addEnvOptions(options, f.Type, fieldIndex, path+".", env+"_")
+
+			continue
+		}
+
+		options[path] = envOption{
+			env:   env,
+			kind:  f.Type.Kind(),
+			index: fieldIndex,
+		}
+	}
+}
+
+// set sets option to a given value.
+func (o envOption) set(options *operator.Options, value string) error {
+	field := reflect.ValueOf(options).Elem().FieldByIndex(o.index)
+
+	if o.kind == reflect.String {
+		field.SetString(value)
+
+		return nil
+	}
+
+	parsed := reflect.New(field.Type())
+
+	if err := yaml.UnmarshalStrict([]byte(value), parsed.Interface()); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	field.Set(parsed.Elem())
+
+	return nil
+}
+
+// upperSnakeCase converts camelCase name to UPPER_SNAKE_CASE, keeping acronyms together,
+// e.g. "leaderElectionID" is converted to "LEADER_ELECTION_ID".
+func upperSnakeCase(name string) string {
+	runes := []rune(name)
+	b := &strings.Builder{}
+
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			previousLower := !unicode.IsUpper(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+
+			if previousLower || nextLower {
+				b.WriteRune('_')
+			}
+		}
+
+		b.WriteRune(unicode.ToUpper(r))
+	}
+
+	return b.String()
+}
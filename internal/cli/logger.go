@@ -0,0 +1,110 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cli
+
+import (
+	"os"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/zapr"
+	uberzap "go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/newrelic/newrelic-infra-operator/internal/operator"
+)
+
+const (
+	// LogLevelDebug logs debug, info and error entries.
+	LogLevelDebug = "debug"
+
+	// LogLevelInfo logs info and error entries.
+	LogLevelInfo = "info"
+
+	// LogLevelError logs error entries only.
+	LogLevelError = "error"
+
+	// LogFormatJSON formats logs as JSON objects, one per line.
+	LogFormatJSON = "json"
+
+	// LogFormatConsole formats logs in human readable form.
+	LogFormatConsole = "console"
+)
+
+// NewLogger creates logger configured by given options. By default, logs at info level are formatted as JSON
+// and written to stderr without sampling.
+func NewLogger(options operator.Options) (logr.Logger, error) {
+	return newLogger(options, zapcore.Lock(os.Stderr))
+}
+
+func newLogger(options operator.Options, sink zapcore.WriteSyncer) (logr.Logger, error) {
+	if err := validateLogging(options).ToAggregate(); err != nil {
+		return logr.Logger{}, err
+	}
+
+	level := zapcore.InfoLevel
+
+	switch options.LogLevel {
+	case LogLevelDebug:
+		level = zapcore.DebugLevel
+	case LogLevelError:
+		level = zapcore.ErrorLevel
+	}
+
+	encoderConfig := uberzap.NewProductionEncoderConfig()
+	newEncoder := zapcore.NewJSONEncoder
+
+	if options.LogFormat == LogFormatConsole {
+		encoderConfig = uberzap.NewDevelopmentEncoderConfig()
+		newEncoder = zapcore.NewConsoleEncoder
+	}
+
+	encoderConfig.EncodeTime = zapcore.RFC3339TimeEncoder
+
+	// Core is built directly instead of using controller-runtime zap package, which always wraps it with
+	// sampler outside of development mode, so configured sampling could not be relaxed or disabled.
+	core := zapcore.NewCore(&zap.KubeAwareEncoder{Encoder: newEncoder(encoderConfig)}, sink, level)
+
+	if sampling := options.LogSampling; sampling.Initial > 0 {
+		core = zapcore.NewSamplerWithOptions(core, time.Second, sampling.Initial, sampling.Thereafter)
+	}
+
+	return zapr.NewLogger(uberzap.New(core, uberzap.AddStacktrace(zapcore.ErrorLevel), uberzap.ErrorOutput(sink))), nil
+}
+
+// validateLogging validates logging options, returning all found errors with paths of invalid fields.
+func validateLogging(options operator.Options) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	switch options.LogLevel {
+	case "", LogLevelDebug, LogLevelInfo, LogLevelError:
+	default:
+		allErrs = append(allErrs, field.NotSupported(field.NewPath("logLevel"), options.LogLevel,
+			[]string{LogLevelDebug, LogLevelInfo, LogLevelError}))
+	}
+
+	switch options.LogFormat {
+	case "", LogFormatJSON, LogFormatConsole:
+	default:
+		allErrs = append(allErrs, field.NotSupported(field.NewPath("logFormat"), options.LogFormat,
+			[]string{LogFormatJSON, LogFormatConsole}))
+	}
+
+	samplingPath := field.NewPath("logSampling")
+	sampling := options.LogSampling
+
+	if sampling.Initial < 0 {
+		allErrs = append(allErrs, field.Invalid(samplingPath.Child("initial"), sampling.Initial, "must not be negative"))
+	}
+
+	// With zero thereafter, sampler drops every entry after the initial ones.
+	if sampling.Initial > 0 && sampling.Thereafter <= 0 {
+		allErrs = append(allErrs, field.Invalid(samplingPath.Child("thereafter"), sampling.Thereafter,
+			"must be positive when sampling is enabled"))
+	}
+
+	return allErrs
+}
@@ -0,0 +1,63 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cli
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+
+	"github.com/newrelic/newrelic-infra-operator/internal/operator"
+)
+
+func Test_Logger_sampling(t *testing.T) {
+	t.Parallel()
+
+	const entries = 1000
+
+	cases := map[string]struct {
+		sampling operator.LogSampling
+		// expectSampled is true if some of identical entries are expected to be dropped.
+		expectSampled bool
+	}{
+		"logs_all_entries_when_sampling_is_not_configured": {},
+		"logs_all_entries_up_to_configured_initial_count": {
+			sampling: operator.LogSampling{Initial: entries, Thereafter: 100},
+		},
+		"drops_entries_exceeding_configured_initial_count": {
+			sampling:      operator.LogSampling{Initial: 100, Thereafter: 100},
+			expectSampled: true,
+		},
+	}
+
+	for testCaseName, testData := range cases {
+		testData := testData
+
+		t.Run(testCaseName, func(t *testing.T) {
+			t.Parallel()
+
+			output := &bytes.Buffer{}
+
+			logger, err := newLogger(operator.Options{LogSampling: testData.sampling}, zapcore.AddSync(output))
+			if err != nil {
+				t.Fatalf("creating logger: %v", err)
+			}
+
+			for range entries {
+				logger.Info("repeated entry")
+			}
+
+			logged := strings.Count(output.String(), "repeated entry")
+
+			switch {
+			case testData.expectSampled && logged >= entries:
+				t.Fatalf("expected entries to be sampled, got all %d entries", logged)
+			case !testData.expectSampled && logged != entries:
+				t.Fatalf("expected %d entries, got %d", entries, logged)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/newrelic/newrelic-infra-operator/internal/operator"
+	"github.com/newrelic/newrelic-infra-operator/internal/schema"
+)
+
+const (
+	// SchemaFile is a path relative to repository root, where generated configuration file schema is published.
+	SchemaFile = "operator.schema.json"
+
+	schemaID    = "https://raw.githubusercontent.com/newrelic/newrelic-infra-operator/main/" + SchemaFile
+	schemaTitle = "newrelic-infra-operator configuration"
+)
+
+// WriteSchema writes JSON Schema of the configuration file to w.
+func WriteSchema(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	if err := encoder.Encode(schema.For(operator.Options{}, schemaID, schemaTitle)); err != nil {
+		return fmt.Errorf("encoding schema: %w", err)
+	}
+
+	return nil
+}
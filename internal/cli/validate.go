@@ -0,0 +1,135 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/yaml"
+
+	"github.com/newrelic/newrelic-infra-operator/internal/operator"
+)
+
+const (
+	// SeverityError marks diagnostics which prevent operator from starting.
+	SeverityError = "error"
+
+	// SeverityWarning marks diagnostics which do not prevent operator from starting, but are likely a mistake.
+	SeverityWarning = "warning"
+
+	// OutputText prints diagnostics in human readable form.
+	OutputText = "text"
+
+	// OutputJSON prints diagnostics as JSON array.
+	OutputJSON = "json"
+)
+
+var errUnsupportedOutput = errors.New("unsupported output format")
+
+// Diagnostic describes a single issue found in configuration file.
+type Diagnostic struct {
+	Severity string `json:"severity"`
+	Field    string `json:"field,omitempty"`
+	Message  string `json:"message"`
+}
+
+// Validate reads configuration file from a given path and returns all issues found in it. Returned error
+// is only set when validation could not be performed, e.g. when file cannot be read.
+//
+// Unlike Options, Validate does not read environment variables, as they are usually not available where
+// configuration file is validated.
+func Validate(path string) ([]Diagnostic, error) {
+	optionsBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading configuration file %q: %w", path, err)
+	}
+
+	options := &operator.Options{}
+
+	if err := yaml.UnmarshalStrict(optionsBytes, options); err != nil {
+		return []Diagnostic{
+			{
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("parsing configuration file content: %v", err),
+			},
+		}, nil
+	}
+
+	injectionPath := field.NewPath("infraAgentInjection")
+
+	diagnostics := []Diagnostic{}
+	diagnostics = append(diagnostics, toDiagnostics(SeverityError, validateLogging(*options))...)
+	diagnostics = append(diagnostics, toDiagnostics(SeverityError, options.Audit.Validate(field.NewPath("audit")))...)
+	diagnostics = append(diagnostics, toDiagnostics(SeverityError, options.Tracing.Validate(field.NewPath("tracing")))...)
+	diagnostics = append(diagnostics, toDiagnostics(SeverityError, options.InfraAgentInjection.Validate(injectionPath))...)
+	diagnostics = append(diagnostics, toDiagnostics(SeverityWarning, options.InfraAgentInjection.Warnings(injectionPath))...)
+
+	return diagnostics, nil
+}
+
+// HasErrors returns true if given diagnostics contain at least one error.
+func HasErrors(diagnostics []Diagnostic) bool {
+	for _, diagnostic := range diagnostics {
+		if diagnostic.Severity == SeverityError {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WriteDiagnostics writes given diagnostics to w using given output format.
+func WriteDiagnostics(w io.Writer, diagnostics []Diagnostic, output string) error {
+	switch output {
+	case OutputJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+
+		if err := encoder.Encode(diagnostics); err != nil {
+			return fmt.Errorf("encoding diagnostics: %w", err)
+		}
+	case OutputText:
+		if len(diagnostics) == 0 {
+			if _, err := fmt.Fprintln(w, "Configuration is valid"); err != nil {
+				return fmt.Errorf("writing diagnostics: %w", err)
+			}
+
+			return nil
+		}
+
+		for _, diagnostic := range diagnostics {
+			prefix := diagnostic.Severity
+			if diagnostic.Field != "" {
+				prefix += ": " + diagnostic.Field
+			}
+
+			if _, err := fmt.Fprintf(w, "%s: %s\n", prefix, diagnostic.Message); err != nil {
+				return fmt.Errorf("writing diagnostics: %w", err)
+			}
+		}
+	default:
+		return fmt.Errorf("%w %q, expected one of %q, %q", errUnsupportedOutput, output, OutputText, OutputJSON)
+	}
+
+	return nil
+}
+
+func toDiagnostics(severity string, errs field.ErrorList) []Diagnostic {
+	diagnostics := make([]Diagnostic, 0, len(errs))
+
+	for _, err := range errs {
+		diagnostics = append(diagnostics, Diagnostic{
+			Severity: severity,
+			Field:    err.Field,
+			Message:  err.ErrorBody(),
+		})
+	}
+
+	return diagnostics
+}
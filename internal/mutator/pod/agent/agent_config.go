@@ -4,6 +4,8 @@
 package agent
 
 import (
+	"text/template"
+
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
@@ -15,7 +17,25 @@ type InfraAgentConfig struct {
 	ConfigSelectors    []ConfigSelector   `json:"configSelectors"`
 	Image              Image              `json:"image"`
 	PodSecurityContext PodSecurityContext `json:"podSecurityContext"`
-	CustomAttributes   CustomAttributes   `json:"customAttributes"`
+	// SecurityContext is merged into the security context of the sidecar, overriding fields set by
+	// SecurityContextPreset and PodSecurityContext.
+	SecurityContext *corev1.SecurityContext `json:"securityContext"`
+	// SecurityContextPreset, if set to SecurityContextPresetRestricted, configures sidecar security context
+	// to comply with "restricted" Pod Security Standard.
+	SecurityContextPreset string `json:"securityContextPreset"`
+	// PodSecurityLevel is a Pod Security Standard level, which the sidecar must comply with. Configuration
+	// producing sidecar violating it is rejected.
+	PodSecurityLevel string           `json:"podSecurityLevel"`
+	CustomAttributes CustomAttributes `json:"customAttributes"`
+	Integrations     []Integration    `json:"integrations"`
+	// Lifecycle configures native sidecar mode, preStop hook of the sidecar and its shutdown coordination with other
+	// containers.
+	Lifecycle *Lifecycle `json:"lifecycle"`
+	// Probes configures probes of the sidecar. Probes are disabled by default.
+	Probes *Probes `json:"probes"`
+	// Volumes configures volumes backing agent's writable directories and additional volumes mounted into
+	// the sidecar.
+	Volumes SidecarVolumes `json:"volumes"`
 }
 
 // Image config.
@@ -36,7 +56,32 @@ type ConfigSelector struct {
 	ResourceRequirements *corev1.ResourceRequirements `json:"resourceRequirements"`
 	ExtraEnvVars         map[string]string            `json:"extraEnvVars"`
 	LabelSelector        metav1.LabelSelector         `json:"labelSelector"`
+	// Integrations is a list of names of integrations from InfraAgentConfig which will be delivered to the
+	// sidecar injected into matching Pods.
+	Integrations []string `json:"integrations"`
+	// Probes, if set, replace probes configured in InfraAgentConfig for matching Pods.
+	Probes *Probes `json:"probes"`
+	// Sizing, if set, computes resource requirements of the sidecar from the matching Pod. It cannot be set
+	// together with ResourceRequirements.
+	Sizing *ResourceSizing `json:"sizing"`
+
+	selector     labels.Selector `json:"-"`
+	hash         string          `json:"-"`
+	integrations []Integration   `json:"-"`
+}
+
+// Integration holds the definition of on-host integration (e.g. nri-redis) configuration file, which will be
+// mounted into sidecar's integrations directory.
+//
+// Config is a Go template rendered for each Pod. Available variables are:
+//   - .Namespace: Pod's Namespace.
+//   - .Labels: Pod's labels, except the ones set by workload controllers which differ between revisions of
+//     the workload, like "pod-template-hash".
+//   - .Ports: map of named container ports to their numbers.
+//   - .PodIP: reference to the Pod's IP address, expanded by the agent on runtime.
+type Integration struct {
+	Name   string `json:"name"`
+	Config string `json:"config"`
 
-	selector labels.Selector `json:"-"`
-	hash     string          `json:"-"`
+	template *template.Template `json:"-"`
 }
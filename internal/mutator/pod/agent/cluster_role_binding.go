@@ -6,15 +6,127 @@ package agent
 import (
 	"context"
 	"fmt"
+	"hash/fnv"
+	"net/http"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+
+	"github.com/newrelic/newrelic-infra-operator/internal/tracing"
 )
 
 const (
 	defaultServiceAccount = "default"
+
+	// DefaultMaxClusterRoleBindingSubjects is a default maximum number of subjects operator adds to single
+	// sharded ClusterRoleBinding.
+	DefaultMaxClusterRoleBindingSubjects = 1000
 )
 
+// ClusterRoleBindingSharding configures distributing ServiceAccounts of injected Pods into multiple
+// ClusterRoleBindings, to avoid conflicts when updating single object.
+//
+// When Shards is set, Namespaces are assigned to one of the shards based on hash of their name. Each shard is
+// a ClusterRoleBinding named "<prefix>-infra-agent-<shard>", which must be pre-created, like "<prefix>-infra-agent"
+// ClusterRoleBinding, so operator is only allowed to update ClusterRoleBindings with known names. Each shard holds
+// at most MaxSubjects subjects. ServiceAccount subjects of pre-created "<prefix>-infra-agent" ClusterRoleBinding
+// are migrated into the shards when the operator starts.
+type ClusterRoleBindingSharding struct {
+	Shards      int `json:"shards"`
+	MaxSubjects int `json:"maxSubjects"`
+}
+
+func (config ClusterRoleBindingSharding) validate(fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if config.Shards < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("shards"), config.Shards, "must not be negative"))
+	}
+
+	if config.MaxSubjects < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("maxSubjects"), config.MaxSubjects, "must not be negative"))
+	}
+
+	return allErrs
+}
+
+func (config ClusterRoleBindingSharding) maxSubjects() int {
+	if config.MaxSubjects == 0 {
+		return DefaultMaxClusterRoleBindingSubjects
+	}
+
+	return config.MaxSubjects
+}
+
+// ReadyzChecks returns readiness check verifying that pre-created ClusterRoleBindings exist.
+func (i *injector) ReadyzChecks() map[string]healthz.Checker {
+	return map[string]healthz.Checker{
+		"cluster-role-binding": func(req *http.Request) error {
+			names := []string{i.clusterRoleBindingName}
+
+			for shard := range i.config.ClusterRoleBindingSharding.Shards {
+				names = append(names, ShardClusterRoleBindingName(i.clusterRoleBindingName, shard))
+			}
+
+			for _, name := range names {
+				crb := &rbacv1.ClusterRoleBinding{}
+
+				if err := i.client.Get(req.Context(), client.ObjectKey{Name: name}, crb); err != nil {
+					return fmt.Errorf("getting ClusterRoleBinding %q: %w", name, err)
+				}
+			}
+
+			return nil
+		},
+	}
+}
+
+// ShardClusterRoleBindingName returns name of the ClusterRoleBinding of given shard.
+func ShardClusterRoleBindingName(clusterRoleBindingName string, shard int) string {
+	return fmt.Sprintf("%s-%d", clusterRoleBindingName, shard)
+}
+
+// shardName returns name of the ClusterRoleBinding of a shard given Namespace belongs to.
+func (i *injector) shardName(namespace string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(namespace))
+
+	//nolint:gosec // Number of shards is validated to be non-negative.
+	shard := h.Sum32() % uint32(i.config.ClusterRoleBindingSharding.Shards)
+
+	return ShardClusterRoleBindingName(i.clusterRoleBindingName, int(shard))
+}
+
+// ensureSubject ensures that ServiceAccount is bound to the infra-agent ClusterRole.
+func (i *injector) ensureSubject(ctx context.Context, serviceAccountName string, namespace string) (err error) {
+	if serviceAccountName == "" {
+		serviceAccountName = defaultServiceAccount
+	}
+
+	ctx, span := i.tracer.Start(ctx, "ensureClusterRoleBindingSubject", trace.WithAttributes(
+		namespaceAttribute(namespace),
+		attribute.String("k8s.serviceaccount.name", serviceAccountName),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if i.config.ClusterRoleBindingSharding.Shards == 0 {
+			return i.ensureClusterRoleBindingSubject(ctx, serviceAccountName, namespace)
+		}
+
+		return i.ensureShardedClusterRoleBindingSubject(ctx, serviceAccountName, namespace)
+	}); err != nil {
+		return fmt.Errorf("ensuring ClusterRoleBinding subject: %w", err)
+	}
+
+	return nil
+}
+
 // ensureClusterRoleBindingSubject ensures that the clusterRolebinding exists and it is well configured, otherwise
 // patches the existing object.
 func (i *injector) ensureClusterRoleBindingSubject(
@@ -31,10 +143,6 @@ func (i *injector) ensureClusterRoleBindingSubject(
 		return fmt.Errorf("getting ClusterRoleBinding %q: %w", i.clusterRoleBindingName, err)
 	}
 
-	if serviceAccountName == "" {
-		serviceAccountName = defaultServiceAccount
-	}
-
 	if hasSubject(crb, serviceAccountName, serviceAccountNamespace) {
 		return nil
 	}
@@ -70,3 +178,73 @@ func hasSubject(crb *rbacv1.ClusterRoleBinding, serviceAccountName string, names
 
 	return false
 }
+
+// ensureShardedClusterRoleBindingSubject adds ServiceAccount to ClusterRoleBinding of Namespace shard, unless it
+// is already bound. Injection fails when the shard is full, as operator is not allowed to create ClusterRoleBindings.
+func (i *injector) ensureShardedClusterRoleBindingSubject(
+	ctx context.Context,
+	serviceAccountName string,
+	serviceAccountNamespace string,
+) error {
+	name := i.shardName(serviceAccountNamespace)
+
+	crb := &rbacv1.ClusterRoleBinding{}
+
+	if err := i.client.Get(ctx, client.ObjectKey{Name: name}, crb); err != nil {
+		return fmt.Errorf("getting ClusterRoleBinding %q: %w", name, err)
+	}
+
+	if hasSubject(crb, serviceAccountName, serviceAccountNamespace) {
+		return nil
+	}
+
+	if maxSubjects := i.config.ClusterRoleBindingSharding.maxSubjects(); len(crb.Subjects) >= maxSubjects {
+		return fmt.Errorf("ClusterRoleBinding %q already has maximum of %d subjects, number of shards must be increased",
+			name, maxSubjects)
+	}
+
+	return i.updateClusterRoleBinding(ctx, crb, serviceAccountName, serviceAccountNamespace)
+}
+
+// migrateClusterRoleBindingSubjects moves ServiceAccount subjects from pre-created ClusterRoleBinding into
+// the shards. Subjects are removed from pre-created ClusterRoleBinding only after they are added to the shards,
+// so agents do not lose permissions in the meantime.
+func (i *injector) migrateClusterRoleBindingSubjects(ctx context.Context) error {
+	if i.config.ClusterRoleBindingSharding.Shards == 0 {
+		return nil
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		crb := &rbacv1.ClusterRoleBinding{}
+
+		if err := i.noCacheClient.Get(ctx, client.ObjectKey{Name: i.clusterRoleBindingName}, crb); err != nil {
+			return fmt.Errorf("getting ClusterRoleBinding %q: %w", i.clusterRoleBindingName, err)
+		}
+
+		remainingSubjects := []rbacv1.Subject{}
+
+		for _, subject := range crb.Subjects {
+			if subject.Kind != rbacv1.ServiceAccountKind {
+				remainingSubjects = append(remainingSubjects, subject)
+
+				continue
+			}
+
+			if err := i.ensureSubject(ctx, subject.Name, subject.Namespace); err != nil {
+				return fmt.Errorf("migrating subject %s/%s: %w", subject.Namespace, subject.Name, err)
+			}
+		}
+
+		if len(remainingSubjects) == len(crb.Subjects) {
+			return nil
+		}
+
+		crb.Subjects = remainingSubjects
+
+		if err := i.noCacheClient.Update(ctx, crb, &client.UpdateOptions{}); err != nil {
+			return fmt.Errorf("updating ClusterRoleBinding %q: %w", i.clusterRoleBindingName, err)
+		}
+
+		return nil
+	})
+}
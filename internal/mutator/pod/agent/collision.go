@@ -0,0 +1,277 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// CollisionStrategyFail fails the injection when sidecar collides with the Pod. It is the default.
+	CollisionStrategyFail = "fail"
+
+	// CollisionStrategySkip admits the Pod without the sidecar when sidecar collides with the Pod.
+	CollisionStrategySkip = "skip"
+
+	// CollisionStrategySuffix renames colliding sidecar container and volumes by appending a numeric suffix to
+	// their names. Port and environment variable collisions cannot be resolved by renaming, so they fail the
+	// injection.
+	CollisionStrategySuffix = "suffix"
+)
+
+// collisions describes conflicts between the sidecar and the Pod it is injected into.
+type collisions struct {
+	// containerName is set when sidecar name is used by init or ephemeral container of the Pod.
+	containerName bool
+	volumes       []string
+	ports         []string
+	env           []string
+}
+
+func (c collisions) empty() bool {
+	return !c.containerName && len(c.volumes) == 0 && len(c.ports) == 0 && len(c.env) == 0
+}
+
+// renamable returns true if all collisions can be resolved by renaming the sidecar and its volumes.
+func (c collisions) renamable() bool {
+	return len(c.ports) == 0 && len(c.env) == 0
+}
+
+func (c collisions) String() string {
+	descriptions := []string{}
+
+	if c.containerName {
+		descriptions = append(descriptions, "duplicate container name: "+AgentSidecarName)
+	}
+
+	for kind, names := range map[string][]string{
+		"duplicate Pod volumes: ":                   c.volumes,
+		"duplicate container ports: ":               c.ports,
+		"duplicate sidecar environment variables: ": c.env,
+	} {
+		if len(names) > 0 {
+			descriptions = append(descriptions, kind+strings.Join(names, ","))
+		}
+	}
+
+	sort.Strings(descriptions)
+
+	return strings.Join(descriptions, "; ")
+}
+
+// resolveCollisions checks if given sidecar and volumes can be injected into the Pod, renaming them according
+// to the configured collision strategy. It returns index of the sidecar injected previously into the Pod in the
+// list returned by runningContainers or -1, if there is none.
+//
+// Volumes mounted by previously injected sidecar are considered to be injected by the operator as well, so they
+// are not reported as collisions.
+func (i *injector) resolveCollisions(
+	pod *corev1.Pod,
+	container *corev1.Container,
+	volumes []corev1.Volume,
+) (int, collisions) {
+	found := collisions{}
+	suffix := i.config.CollisionStrategy == CollisionStrategySuffix
+
+	native := isNativeSidecar(container)
+
+	// Sidecar injected previously is found among regular containers or, if it is native, among init containers
+	// kept running, so containers of other kinds with the same name collide with it.
+	otherContainers := map[string]struct{}{}
+
+	for _, c := range append(append([]corev1.Container{}, pod.Spec.InitContainers...), ephemeralContainers(pod)...) {
+		if !native || !isNativeSidecar(&c) {
+			otherContainers[c.Name] = struct{}{}
+		}
+	}
+
+	if native {
+		for _, c := range pod.Spec.Containers {
+			otherContainers[c.Name] = struct{}{}
+		}
+	}
+
+	if _, ok := otherContainers[container.Name]; ok {
+		found.containerName = true
+
+		if suffix {
+			container.Name = withSuffix(container.Name, otherContainers)
+		}
+	}
+
+	sidecarIndex := containerIndex(pod.Spec.Containers, container.Name)
+	if native {
+		sidecarIndex = containerIndex(runningContainers(pod), container.Name)
+	}
+
+	found.volumes = resolveVolumeCollisions(pod, sidecarIndex, container, volumes, suffix)
+	found.ports = portCollisions(pod, sidecarIndex, container)
+	found.env = getDuplicateEnvNames(append(append([]corev1.EnvVar{}, container.Env...),
+		corev1.EnvVar{Name: envCustomAttribute}))
+
+	return sidecarIndex, found
+}
+
+// resolveVolumeCollisions returns names of given volumes colliding with volumes of the Pod. If suffix is true,
+// colliding volumes and sidecar volume mounts are renamed.
+func resolveVolumeCollisions(
+	pod *corev1.Pod,
+	sidecarIndex int,
+	container *corev1.Container,
+	volumes []corev1.Volume,
+	suffix bool,
+) []string {
+	mounted := map[string]struct{}{}
+
+	if sidecarIndex >= 0 {
+		for _, m := range runningContainers(pod)[sidecarIndex].VolumeMounts {
+			mounted[m.Name] = struct{}{}
+		}
+	}
+
+	taken := map[string]struct{}{}
+
+	for _, v := range pod.Spec.Volumes {
+		if _, ok := mounted[v.Name]; !ok {
+			taken[v.Name] = struct{}{}
+		}
+	}
+
+	desired := map[string]struct{}{}
+
+	for _, v := range volumes {
+		desired[v.Name] = struct{}{}
+	}
+
+	colliding := []string{}
+
+	for idx, v := range volumes {
+		if _, ok := taken[v.Name]; !ok {
+			continue
+		}
+
+		colliding = append(colliding, v.Name)
+
+		if !suffix {
+			continue
+		}
+
+		newName := withSuffix(v.Name, taken, desired)
+		desired[newName] = struct{}{}
+
+		for m := range container.VolumeMounts {
+			if container.VolumeMounts[m].Name == v.Name {
+				container.VolumeMounts[m].Name = newName
+			}
+		}
+
+		volumes[idx].Name = newName
+	}
+
+	return colliding
+}
+
+// portCollisions returns sidecar ports, which are already declared by other containers running in the Pod.
+func portCollisions(pod *corev1.Pod, sidecarIndex int, container *corev1.Container) []string {
+	declared := map[string]struct{}{}
+
+	for idx, c := range runningContainers(pod) {
+		if idx == sidecarIndex {
+			continue
+		}
+
+		for _, p := range c.Ports {
+			declared[portKey(p)] = struct{}{}
+		}
+	}
+
+	colliding := []string{}
+
+	for _, p := range container.Ports {
+		if _, ok := declared[portKey(p)]; ok {
+			colliding = append(colliding, portKey(p))
+		}
+	}
+
+	return colliding
+}
+
+func portKey(port corev1.ContainerPort) string {
+	protocol := port.Protocol
+	if protocol == "" {
+		protocol = corev1.ProtocolTCP
+	}
+
+	return strconv.Itoa(int(port.ContainerPort)) + "/" + string(protocol)
+}
+
+func getDuplicateEnvNames(env []corev1.EnvVar) []string {
+	duplicates := []string{}
+	unique := map[string]struct{}{}
+
+	for _, e := range env {
+		if _, ok := unique[e.Name]; ok {
+			duplicates = append(duplicates, e.Name)
+		}
+
+		unique[e.Name] = struct{}{}
+	}
+
+	return duplicates
+}
+
+// withSuffix returns name with the lowest numeric suffix, which is not present in any of given sets.
+func withSuffix(name string, taken ...map[string]struct{}) string {
+	for n := 1; ; n++ {
+		candidate := fmt.Sprintf("%s-%d", name, n)
+
+		free := true
+
+		for _, names := range taken {
+			if _, ok := names[candidate]; ok {
+				free = false
+
+				break
+			}
+		}
+
+		if free {
+			return candidate
+		}
+	}
+}
+
+// runningContainers returns regular containers of the Pod followed by its init containers, which keep running
+// alongside them.
+func runningContainers(pod *corev1.Pod) []corev1.Container {
+	return append(append([]corev1.Container{}, pod.Spec.Containers...), sidecarInitContainers(pod)...)
+}
+
+// sidecarInitContainers returns init containers of the Pod, which keep running alongside regular containers.
+func sidecarInitContainers(pod *corev1.Pod) []corev1.Container {
+	containers := []corev1.Container{}
+
+	for _, c := range pod.Spec.InitContainers {
+		if isNativeSidecar(&c) {
+			containers = append(containers, c)
+		}
+	}
+
+	return containers
+}
+
+func ephemeralContainers(pod *corev1.Pod) []corev1.Container {
+	containers := make([]corev1.Container, 0, len(pod.Spec.EphemeralContainers))
+
+	for _, c := range pod.Spec.EphemeralContainers {
+		containers = append(containers, corev1.Container{Name: c.Name})
+	}
+
+	return containers
+}
@@ -0,0 +1,259 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/newrelic/newrelic-infra-operator/internal/tracing"
+)
+
+const (
+	quotaRequestsPrefix = "requests."
+	quotaLimitsPrefix   = "limits."
+)
+
+// resourceConstraints holds LimitRanges and ResourceQuotas of the Namespace, which restrict resources
+// the sidecar may use.
+type resourceConstraints struct {
+	limitRanges []corev1.LimitRange
+	quotas      []corev1.ResourceQuota
+}
+
+// resourceConstraints fetches LimitRanges and ResourceQuotas of given Namespace. They are fetched from the API,
+// so decisions are made using current quota usage.
+func (i *injector) resourceConstraints(ctx context.Context, namespace string) (_ *resourceConstraints, err error) {
+	ctx, span := i.tracer.Start(ctx, "resourceConstraints", trace.WithAttributes(namespaceAttribute(namespace)))
+	defer func() { tracing.End(span, err) }()
+
+	limitRanges := &corev1.LimitRangeList{}
+	if err := i.noCacheClient.List(ctx, limitRanges, client.InNamespace(namespace)); err != nil {
+		return nil, fmt.Errorf("listing LimitRanges: %w", err)
+	}
+
+	quotas := &corev1.ResourceQuotaList{}
+	if err := i.noCacheClient.List(ctx, quotas, client.InNamespace(namespace)); err != nil {
+		return nil, fmt.Errorf("listing ResourceQuotas: %w", err)
+	}
+
+	return &resourceConstraints{
+		limitRanges: limitRanges.Items,
+		quotas:      quotas.Items,
+	}, nil
+}
+
+// constrain returns given sidecar resources adjusted to comply with container limits of LimitRanges and to fit
+// into ResourceQuotas remaining after admitting other containers of the Pod, which use given resources. Names of
+// objects which caused adjustments are returned as well.
+//
+// Only quotas without scopes are considered. Quotas which are already exhausted by other containers are left
+// for the quota admission to reject.
+func (c *resourceConstraints) constrain(
+	resources corev1.ResourceRequirements,
+	podResources corev1.ResourceRequirements,
+) (corev1.ResourceRequirements, []string) {
+	constrained := *resources.DeepCopy()
+	constrainedBy := []string{}
+
+	for _, limitRange := range c.limitRanges {
+		for _, item := range limitRange.Spec.Limits {
+			if item.Type == corev1.LimitTypeContainer && applyLimitRangeItem(&constrained, item) {
+				constrainedBy = append(constrainedBy, "LimitRange/"+limitRange.Name)
+			}
+		}
+	}
+
+	for _, quota := range c.quotas {
+		if len(quota.Spec.Scopes) > 0 || quota.Spec.ScopeSelector != nil {
+			continue
+		}
+
+		if applyQuota(&constrained, quota, podResources) {
+			constrainedBy = append(constrainedBy, "ResourceQuota/"+quota.Name)
+		}
+	}
+
+	sort.Strings(constrainedBy)
+
+	return constrained, constrainedBy
+}
+
+// applyLimitRangeItem adjusts given resources to given LimitRange item, including default requests and limits
+// applied by LimitRange admission to containers without them. It returns true if resources have been modified.
+func applyLimitRangeItem(resources *corev1.ResourceRequirements, item corev1.LimitRangeItem) bool {
+	modified := false
+
+	// Containers without request or limit get the default one. Containers without limit are rejected if maximum
+	// is set, so it is used as a default limit as well.
+	for _, defaults := range []struct {
+		list     *corev1.ResourceList
+		defaults corev1.ResourceList
+	}{
+		{&resources.Requests, item.DefaultRequest},
+		{&resources.Limits, item.Default},
+		{&resources.Limits, item.Max},
+	} {
+		for name, quantity := range defaults.defaults {
+			if _, ok := (*defaults.list)[name]; !ok {
+				setQuantity(defaults.list, name, quantity)
+
+				modified = true
+			}
+		}
+	}
+
+	for _, list := range []corev1.ResourceList{resources.Requests, resources.Limits} {
+		for name, quantity := range list {
+			if min, ok := item.Min[name]; ok && quantity.Cmp(min) < 0 {
+				list[name] = min
+				modified = true
+			}
+
+			if max, ok := item.Max[name]; ok && quantity.Cmp(max) > 0 {
+				list[name] = max
+				modified = true
+			}
+		}
+	}
+
+	for name, ratio := range item.MaxLimitRequestRatio {
+		request, hasRequest := resources.Requests[name]
+		limit, hasLimit := resources.Limits[name]
+
+		if !hasRequest || !hasLimit {
+			continue
+		}
+
+		if maxLimit := scaled(name, request, ratio.MilliValue(), 1000); limit.Cmp(maxLimit) > 0 {
+			resources.Limits[name] = maxLimit
+			modified = true
+		}
+	}
+
+	return capRequests(resources) || modified
+}
+
+// applyQuota reduces given resources to fit into the quota remaining after admitting given Pod resources.
+// It returns true if resources have been modified.
+func applyQuota(
+	resources *corev1.ResourceRequirements,
+	quota corev1.ResourceQuota,
+	podResources corev1.ResourceRequirements,
+) bool {
+	modified := false
+
+	for quotaName, hard := range quota.Spec.Hard {
+		name, limits := quotaResource(quotaName)
+		if name == "" {
+			continue
+		}
+
+		list, podList := resources.Requests, podResources.Requests
+		if limits {
+			list, podList = resources.Limits, podResources.Limits
+		}
+
+		quantity, ok := list[name]
+		if !ok {
+			continue
+		}
+
+		remaining := hard.DeepCopy()
+		remaining.Sub(quota.Status.Used[quotaName])
+		remaining.Sub(podList[name])
+
+		if remaining.Sign() > 0 && quantity.Cmp(remaining) > 0 {
+			list[name] = remaining
+			modified = true
+		}
+	}
+
+	return capRequests(resources) || modified
+}
+
+// quotaResource returns name of the compute resource tracked by given quota resource and whether quota tracks
+// limits rather than requests. Empty name is returned for quota resources not tracking compute resources, like
+// object counts or storage requested by PersistentVolumeClaims.
+func quotaResource(quotaName corev1.ResourceName) (corev1.ResourceName, bool) {
+	switch name := string(quotaName); {
+	case quotaName == corev1.ResourceRequestsStorage:
+		return "", false
+	case name == string(corev1.ResourceCPU), name == string(corev1.ResourceMemory),
+		name == string(corev1.ResourceEphemeralStorage):
+		return quotaName, false
+	case strings.HasPrefix(name, quotaRequestsPrefix):
+		return corev1.ResourceName(strings.TrimPrefix(name, quotaRequestsPrefix)), false
+	case strings.HasPrefix(name, quotaLimitsPrefix):
+		return corev1.ResourceName(strings.TrimPrefix(name, quotaLimitsPrefix)), true
+	default:
+		return "", false
+	}
+}
+
+// capRequests lowers requests exceeding limits. It returns true if requests have been modified.
+func capRequests(resources *corev1.ResourceRequirements) bool {
+	modified := false
+
+	for name, request := range resources.Requests {
+		if limit, ok := resources.Limits[name]; ok && request.Cmp(limit) > 0 {
+			resources.Requests[name] = limit
+			modified = true
+		}
+	}
+
+	return modified
+}
+
+// podResources returns total resources of containers running alongside the sidecar with given index.
+func podResources(pod *corev1.Pod, sidecarIndex int) corev1.ResourceRequirements {
+	total := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{},
+		Limits:   corev1.ResourceList{},
+	}
+
+	for idx, c := range runningContainers(pod) {
+		if idx == sidecarIndex {
+			continue
+		}
+
+		for _, list := range []struct{ total, container corev1.ResourceList }{
+			{total.Requests, c.Resources.Requests},
+			{total.Limits, c.Resources.Limits},
+		} {
+			for name, quantity := range list.container {
+				sum := list.total[name]
+				sum.Add(quantity)
+				list.total[name] = sum
+			}
+		}
+	}
+
+	return total
+}
+
+// scaled returns given quantity of given resource multiplied by numerator and divided by denominator. CPU is
+// scaled with millicore precision, other resources with unit precision.
+func scaled(name corev1.ResourceName, quantity resource.Quantity, numerator, denominator int64) resource.Quantity {
+	if name == corev1.ResourceCPU {
+		return *resource.NewMilliQuantity(quantity.MilliValue()*numerator/denominator, resource.DecimalSI)
+	}
+
+	return *resource.NewQuantity(quantity.Value()*numerator/denominator, quantity.Format)
+}
+
+func setQuantity(list *corev1.ResourceList, name corev1.ResourceName, quantity resource.Quantity) {
+	if *list == nil {
+		*list = corev1.ResourceList{}
+	}
+
+	(*list)[name] = quantity
+}
@@ -11,18 +11,23 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 
-	"k8s.io/utils/ptr"
-
 	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
-	"k8s.io/client-go/util/retry"
+	"k8s.io/client-go/tools/events"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/yaml"
 
+	"github.com/newrelic/newrelic-infra-operator/internal/tracing"
 	"github.com/newrelic/newrelic-infra-operator/internal/webhook"
 )
 
@@ -33,6 +38,18 @@ const (
 	// InjectedLabel is the name of the label injected in pod.
 	InjectedLabel = "infra-operator.newrelic.com/agent-injected"
 
+	// PolicyAnnotation is the name of the annotation holding the rule, which decided about injection into the Pod.
+	PolicyAnnotation = "infra-operator.newrelic.com/injection-policy"
+
+	// ExcludeNamespacesRule is the value of PolicyAnnotation when Pod was excluded via global namespace exclude list.
+	ExcludeNamespacesRule = "excludeNamespaces"
+
+	// PolicyActionInclude is the injection policy action, which injects agent into matching Pods. It is the default.
+	PolicyActionInclude = "include"
+
+	// PolicyActionExclude is the injection policy action, which prevents injecting agent into matching Pods.
+	PolicyActionExclude = "exclude"
+
 	// ClusterRoleBindingSuffix is the expected suffix on pre-created ClusterRoleBinding. It will be combined
 	// with configured resource prefix.
 	ClusterRoleBindingSuffix = "-infra-agent"
@@ -75,6 +92,14 @@ type injector struct {
 	// We do not have permissions to list and watch secrets, so we must use uncached
 	// client for them.
 	noCacheClient client.Client
+
+	namespaceCache NamespaceCache
+
+	// provisioner is set when sidecar dependencies are provisioned asynchronously.
+	provisioner *provisioner
+
+	logger logr.Logger
+	tracer trace.Tracer
 }
 
 // InjectorConfig of the Injector used to pass the required data to build it.
@@ -84,16 +109,70 @@ type InjectorConfig struct {
 	License        string            `json:"-"`
 	ClusterName    string            `json:"clusterName"`
 	Policies       []InjectionPolicy `json:"policies"`
+	// ExcludeNamespaces is a list of Namespaces, where agent is never injected. It is evaluated before policies.
+	ExcludeNamespaces []string `json:"excludeNamespaces"`
+	// Profiles holds named sidecar configurations, which can be referenced by injection policies.
+	Profiles []AgentProfile `json:"profiles"`
+	// NamespaceCache, if set, is used to look up Namespaces for policy matching before falling back to API.
+	NamespaceCache NamespaceCache `json:"-"`
+	// TracerProvider, if set, is used to trace Namespace lookups and provisioning of sidecar dependencies.
+	TracerProvider trace.TracerProvider `json:"-"`
+	// AsyncDependencies configures provisioning of sidecar dependencies outside of admission requests.
+	AsyncDependencies AsyncDependenciesConfig `json:"asyncDependencies"`
+	// ClusterRoleBindingSharding configures binding ServiceAccounts of injected Pods using multiple
+	// ClusterRoleBindings.
+	ClusterRoleBindingSharding ClusterRoleBindingSharding `json:"clusterRoleBindingSharding"`
+	// CollisionStrategy defines what happens when sidecar container, its volumes, ports or environment variables
+	// collide with the Pod. One of CollisionStrategyFail (default), CollisionStrategySkip or
+	// CollisionStrategySuffix.
+	CollisionStrategy string `json:"collisionStrategy"`
+	// PodSecurityMode defines what happens when sidecar violates Pod Security Standard enforced in the Pod's
+	// Namespace using "pod-security.kubernetes.io/enforce" label. One of PodSecurityModeAdapt,
+	// PodSecurityModeSkip or PodSecurityModeFail. If empty, Namespace labels are not evaluated.
+	PodSecurityMode string `json:"podSecurityMode"`
+	// ResourceQuotaPolicy defines what happens when sidecar does not fit into LimitRanges and remaining
+	// ResourceQuotas of the Pod's Namespace. One of ResourceQuotaPolicyShrink, ResourceQuotaPolicySkip or
+	// ResourceQuotaPolicyProceed. If empty, Namespace constraints are not checked.
+	ResourceQuotaPolicy string `json:"resourceQuotaPolicy"`
+	// EventRecorder, if set, is used to emit events when injection is skipped.
+	EventRecorder events.EventRecorder `json:"-"`
 }
 
 // InjectionPolicy represents injection policy, which defines if given Pod should have agent injected or not.
+//
+// Policies are evaluated in order of descending priority, policies with equal priority are evaluated in the
+// configured order. The first matching policy decides whether agent gets injected, according to its action.
 type InjectionPolicy struct {
+	Name              string                `json:"name"`
+	Action            string                `json:"action"`
+	Priority          int                   `json:"priority"`
 	NamespaceName     string                `json:"namespaceName"`
 	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector"`
 	PodSelector       *metav1.LabelSelector `json:"podSelector"`
+	// Profile is a name of agent profile used for Pods matching this policy instead of global agent configuration.
+	Profile string `json:"profile"`
 
 	namespaceSelector labels.Selector `json:"-"`
 	podSelector       labels.Selector `json:"-"`
+	index             int             `json:"-"`
+	profile           *sidecar        `json:"-"`
+}
+
+func (policy InjectionPolicy) actionOrDefault() string {
+	if policy.Action == "" {
+		return PolicyActionInclude
+	}
+
+	return policy.Action
+}
+
+// rule returns identifier of the policy, used for recording which policy decided about the injection.
+func (policy InjectionPolicy) rule() string {
+	if policy.Name != "" {
+		return policy.Name
+	}
+
+	return fmt.Sprintf("policies[%d]", policy.index)
 }
 
 // CustomAttributes represents collection of custom attributes.
@@ -110,6 +189,14 @@ type CustomAttribute struct {
 	FromLabel    string `json:"fromLabel"`
 }
 
+// clusterNameCustomAttribute returns custom attribute which is always reported by injected agents.
+func clusterNameCustomAttribute(clusterName string) CustomAttribute {
+	return CustomAttribute{
+		Name:         clusterNameAttribute,
+		DefaultValue: clusterName,
+	}
+}
+
 func (cas CustomAttributes) toString(podLabels map[string]string) (string, error) {
 	output := map[string]string{}
 
@@ -142,6 +229,17 @@ func (cas CustomAttributes) toString(podLabels map[string]string) (string, error
 // like right permissions and access to the New Relic license key.
 type Injector interface {
 	Mutate(ctx context.Context, pod *corev1.Pod, requestOptions webhook.RequestOptions) error
+
+	// Start runs background processing of the injector, which must run on every operator instance, until given
+	// context is cancelled.
+	Start(ctx context.Context) error
+
+	// LeaderRunnable returns runnable running background tasks of the injector, which must be run by single
+	// operator instance at a time.
+	LeaderRunnable() manager.Runnable
+
+	// ReadyzChecks returns named readiness checks verifying that the injector is able to inject Pods.
+	ReadyzChecks() map[string]healthz.Checker
 }
 
 // New function is the constructor for the injector struct.
@@ -153,15 +251,13 @@ func (config InjectorConfig) New(client, noCacheClient client.Client, logger log
 		return nil, fmt.Errorf("logger is not initialized")
 	}
 
-	config.AgentConfig.CustomAttributes = append(config.AgentConfig.CustomAttributes, CustomAttribute{
-		Name:         clusterNameAttribute,
-		DefaultValue: config.ClusterName,
-	})
-
 	if err := config.validate(); err != nil {
 		return nil, fmt.Errorf("validating configuration: %w", err)
 	}
 
+	config.AgentConfig.CustomAttributes = append(config.AgentConfig.CustomAttributes,
+		clusterNameCustomAttribute(config.ClusterName))
+
 	licenseSecretName := fmt.Sprintf("%s%s", config.ResourcePrefix, LicenseSecretSuffix)
 
 	containerToInject := config.container(licenseSecretName)
@@ -172,6 +268,8 @@ func (config InjectorConfig) New(client, noCacheClient client.Client, logger log
 		ClusterName:        config.ClusterName,
 		Image:              config.AgentConfig.Image,
 		PodSecurityContext: config.AgentConfig.PodSecurityContext,
+		Volumes:            config.AgentConfig.Volumes.hashed(),
+		Lifecycle:          config.AgentConfig.Lifecycle,
 		Container:          containerToInject,
 	}
 
@@ -186,24 +284,73 @@ func (config InjectorConfig) New(client, noCacheClient client.Client, logger log
 		return nil, fmt.Errorf("building policies: %w", err)
 	}
 
+	if err := config.buildIntegrations(); err != nil {
+		return nil, fmt.Errorf("building integrations: %w", err)
+	}
+
 	if err := config.buildConfigSelectors(containerToInject, logger); err != nil {
 		return nil, fmt.Errorf("building config selectors: %w", err)
 	}
 
-	return &injector{
+	if err := config.buildProfiles(licenseSecretName, logger); err != nil {
+		return nil, fmt.Errorf("building profiles: %w", err)
+	}
+
+	i := &injector{
 		clusterRoleBindingName: fmt.Sprintf("%s%s", config.ResourcePrefix, ClusterRoleBindingSuffix),
 		licenseSecretName:      licenseSecretName,
 		license:                []byte(config.License),
 		client:                 client,
 		noCacheClient:          noCacheClient,
+		namespaceCache:         config.NamespaceCache,
 		container:              containerToInject,
 		config:                 &config,
 		configHash:             hash,
-	}, nil
+		logger:                 logger,
+		tracer:                 tracing.Tracer(config.TracerProvider),
+	}
+
+	i.provisioner = config.AsyncDependencies.newProvisioner(i.provisionDependencies, logger.WithName("provisioner"))
+
+	return i, nil
+}
+
+// Start runs background provisioning of sidecar dependencies, if enabled, until given context is cancelled.
+func (i *injector) Start(ctx context.Context) error {
+	if i.provisioner == nil {
+		return nil
+	}
+
+	return i.provisioner.Start(ctx)
+}
+
+// LeaderRunnable returns runnable migrating subjects of pre-created ClusterRoleBinding into the shards, if sharding
+// is enabled.
+//
+//nolint:ireturn
+func (i *injector) LeaderRunnable() manager.Runnable {
+	return manager.RunnableFunc(func(ctx context.Context) error {
+		if err := i.migrateClusterRoleBindingSubjects(ctx); err != nil {
+			return fmt.Errorf("migrating ClusterRoleBinding subjects: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. Each operator instance provisions dependencies
+// of Pods it admits, so it must run regardless of leadership.
+func (i *injector) NeedLeaderElection() bool {
+	return false
 }
 
 func (config *InjectorConfig) buildPolicies() error {
+	// Copy policies, so sorting does not affect the caller.
+	config.Policies = append([]InjectionPolicy{}, config.Policies...)
+
 	for i, policy := range config.Policies {
+		config.Policies[i].index = i
+
 		if policy.NamespaceSelector != nil {
 			selector, err := metav1.LabelSelectorAsSelector(policy.NamespaceSelector)
 			if err != nil {
@@ -223,226 +370,381 @@ func (config *InjectorConfig) buildPolicies() error {
 		}
 	}
 
+	sort.SliceStable(config.Policies, func(i, j int) bool {
+		return config.Policies[i].Priority > config.Policies[j].Priority
+	})
+
 	return nil
 }
 
-//nolint:cyclop,gocyclo
-func (config InjectorConfig) validate() error {
-	if config.License == "" {
-		return fmt.Errorf("%w: %s", errEmpty, "license key")
+func (config InjectorConfig) container(licenseSecretName string) corev1.Container {
+	c := corev1.Container{
+		Image:           fmt.Sprintf("%s:%s", config.AgentConfig.Image.Repository, config.AgentConfig.Image.Tag),
+		Name:            AgentSidecarName,
+		ImagePullPolicy: config.AgentConfig.Image.PullPolicy,
+		Env:             standardEnvVar(licenseSecretName, config.ClusterName),
+		VolumeMounts:    config.AgentConfig.Volumes.volumeMounts(),
+		SecurityContext: config.AgentConfig.securityContext(),
 	}
 
-	if config.ClusterName == "" {
-		return fmt.Errorf("%w: %s", errEmpty, "cluster name")
-	}
+	config.AgentConfig.Lifecycle.apply(&c)
+	config.AgentConfig.Probes.apply(&c)
+
+	return c
+}
 
-	if config.AgentConfig.Image.Tag == "" {
-		return fmt.Errorf("%w: %s", errEmpty, "config.infraAgentInjection.agentConfig.Image.Tag")
+// Mutate mutates given Pod object by injecting infrastructure-agent container into it with all dependencies.
+func (i *injector) Mutate(ctx context.Context, pod *corev1.Pod, requestOptions webhook.RequestOptions) error {
+	logger := requestLogger(ctx, i.logger)
+
+	decision, policy, ns, err := i.shouldInjectContainer(ctx, pod, requestOptions.Namespace)
+
+	requestOptions.Record(decision)
+
+	if err != nil {
+		return fmt.Errorf("checking if agent container should be injected: %w", err)
 	}
 
-	if config.AgentConfig.Image.Repository == "" {
-		return fmt.Errorf("%w: %s", errEmpty, "config.infraAgentInjection.agentConfig.Image.Repository")
+	logger.V(1).Info("Injection decision made", "inject", decision.Inject, "reason", decision.Reason, "rule", decision.Rule)
+
+	if decision.Rule != "" && pod.Annotations[PolicyAnnotation] != decision.Rule {
+		requestOptions.AddPatch(webhook.AddAnnotation(pod.Annotations, PolicyAnnotation, decision.Rule))
+
+		if pod.Annotations == nil {
+			pod.Annotations = map[string]string{}
+		}
+
+		pod.Annotations[PolicyAnnotation] = decision.Rule
 	}
 
-	if config.ResourcePrefix == "" {
-		return fmt.Errorf("%w: %s", errEmpty, "config.infraAgentInjection.ResourcePrefix")
+	if !decision.Inject {
+		return nil
 	}
 
-	customAttributeNames := map[string]struct{}{}
+	s := i.sidecarFor(pod, policy)
+	containerToInject, hash := s.container, s.hash
 
-	for i, ca := range config.AgentConfig.CustomAttributes {
-		if ca.Name == "" {
-			//nolint:err113
-			return fmt.Errorf("custom attribute %d has empty name", i)
-		}
+	decision.ConfigHash = hash
+	requestOptions.Record(decision)
 
-		if ca.DefaultValue == "" && ca.FromLabel == "" {
-			//nolint:err113
-			return fmt.Errorf("custom attribute %q has no value defined", ca.Name)
-		}
+	volumesToInject := i.config.AgentConfig.Volumes.volumes()
 
-		if _, ok := customAttributeNames[ca.Name]; ok {
-			//nolint:err113
-			return fmt.Errorf("duplicate custom attribute %q defined", ca.Name)
-		}
+	integrationsConfigMap, err := i.integrationsConfigMap(pod, requestOptions.Namespace, s.integrations)
+	if err != nil {
+		return fmt.Errorf("rendering integrations: %w", err)
+	}
 
-		customAttributeNames[ca.Name] = struct{}{}
+	if integrationsConfigMap != nil {
+		volumesToInject = append(volumesToInject, withIntegrations(&containerToInject, integrationsConfigMap))
 	}
 
-	if len(config.Policies) == 0 {
-		//nolint:err113
-		return fmt.Errorf("at least one injection policy must be configured")
+	skipReason, err := i.checkPodSecurity(pod, ns, &containerToInject, volumesToInject, requestOptions)
+	if err != nil {
+		return fmt.Errorf("checking Pod Security Standard compliance: %w", err)
 	}
 
-	return nil
-}
+	if skipReason != "" {
+		decision.Inject = false
+		decision.Reason = skipReason
+		requestOptions.Record(decision)
 
-func (config InjectorConfig) container(licenseSecretName string) corev1.Container {
-	c := corev1.Container{
-		Image:           fmt.Sprintf("%s:%s", config.AgentConfig.Image.Repository, config.AgentConfig.Image.Tag),
-		Name:            AgentSidecarName,
-		ImagePullPolicy: config.AgentConfig.Image.PullPolicy,
-		Env:             standardEnvVar(licenseSecretName, config.ClusterName),
-		VolumeMounts:    standardVolumes(),
-		SecurityContext: &corev1.SecurityContext{
-			ReadOnlyRootFilesystem:   ptr.To[bool](true),
-			AllowPrivilegeEscalation: ptr.To[bool](false),
-		},
+		logger.V(1).Info("Skipping injection", "reason", decision.Reason)
+
+		return nil
 	}
 
-	if config.AgentConfig.PodSecurityContext.RunAsUser != 0 {
-		c.SecurityContext.RunAsUser = &config.AgentConfig.PodSecurityContext.RunAsUser
+	// Webhook may be invoked again for the same Pod when other webhooks modify it, so sidecar and volumes
+	// injected previously are reconciled to the desired state instead of being injected again.
+	sidecarIndex, collisions := i.resolveCollisions(pod, &containerToInject, volumesToInject)
+
+	if !collisions.empty() {
+		strategy := i.config.CollisionStrategy
+
+		switch {
+		case strategy == CollisionStrategySkip:
+			decision.Inject = false
+			decision.Reason = "sidecar collides with Pod: " + collisions.String()
+			requestOptions.Record(decision)
+
+			logger.V(1).Info("Skipping injection", "reason", decision.Reason)
+
+			return nil
+		case strategy == CollisionStrategySuffix && collisions.renamable():
+			logger.V(1).Info("Renamed colliding sidecar resources", "collisions", collisions.String())
+		default:
+			//nolint:err113
+			return fmt.Errorf("injecting agent would produce %s", collisions)
+		}
 	}
 
-	if config.AgentConfig.PodSecurityContext.RunAsGroup != 0 {
-		c.SecurityContext.RunAsGroup = &config.AgentConfig.PodSecurityContext.RunAsGroup
+	var constraints *resourceConstraints
+
+	if s.sizing != nil || i.config.ResourceQuotaPolicy != "" {
+		if constraints, err = i.resourceConstraints(ctx, requestOptions.Namespace); err != nil {
+			return fmt.Errorf("getting resource constraints: %w", err)
+		}
 	}
 
-	return c
-}
+	sizeSidecar(pod, sidecarIndex, s.sizing, constraints, &containerToInject, requestOptions)
 
-// Mutate mutates given Pod object by injecting infrastructure-agent container into it with all dependencies.
-func (i *injector) Mutate(ctx context.Context, pod *corev1.Pod, requestOptions webhook.RequestOptions) error {
-	containerToInject := i.container
+	if skipReason := i.checkResourceQuota(pod, ns, sidecarIndex, constraints, &containerToInject,
+		requestOptions); skipReason != "" {
+		decision.Inject = false
+		decision.Reason = skipReason
+		requestOptions.Record(decision)
 
-	injectContainer, err := i.shouldInjectContainer(ctx, pod, requestOptions.Namespace)
-	if err != nil {
-		return fmt.Errorf("checking if agent container should be injected: %w", err)
-	}
+		logger.V(1).Info("Skipping injection", "reason", decision.Reason)
 
-	if !injectContainer {
 		return nil
 	}
 
-	if err := i.canInjectContainer(pod, containerToInject); err != nil {
-		return fmt.Errorf("checking if agent container can be injected: %w", err)
-	}
+	i.config.AgentConfig.Lifecycle.coordinateShutdown(pod, sidecarIndex, &containerToInject, requestOptions)
 
-	if err := i.ensureSidecarDependencies(ctx, pod, requestOptions); err != nil {
+	if err := i.ensureSidecarDependencies(ctx, pod, integrationsConfigMap, requestOptions); err != nil {
 		return fmt.Errorf("ensuring sidecar dependencies: %w", err)
 	}
 
-	if pod.Labels == nil {
-		pod.Labels = map[string]string{}
-	}
+	if pod.Labels[InjectedLabel] != hash {
+		requestOptions.AddPatch(webhook.AddLabel(pod.Labels, InjectedLabel, hash))
 
-	pod.Labels[InjectedLabel] = i.applyAgentConfig(pod.Labels, &containerToInject)
+		if pod.Labels == nil {
+			pod.Labels = map[string]string{}
+		}
 
-	customAttributes, err := i.config.AgentConfig.CustomAttributes.toString(pod.Labels)
+		pod.Labels[InjectedLabel] = hash
+	}
+
+	customAttributes, err := s.customAttributes.toString(pod.Labels)
 	if err != nil {
 		return fmt.Errorf("creating custom attributes: %w", err)
 	}
 
-	containerToInject.Env = append(containerToInject.Env,
-		corev1.EnvVar{
+	customAttributesEnv := []corev1.EnvVar{
+		{
 			Name:  envCustomAttribute,
 			Value: customAttributes,
 		},
-	)
+	}
+
+	reconcileSidecar(pod, sidecarIndex, containerToInject, customAttributesEnv, requestOptions)
+	reconcileVolumes(pod, volumesToInject, requestOptions)
 
-	pod.Spec.Containers = append(pod.Spec.Containers, containerToInject)
-	pod.Spec.Volumes = append(pod.Spec.Volumes, toEmptyDirVolumes(containerToInject.VolumeMounts)...)
+	logger.V(1).Info("Sidecar injected", "configHash", hash, "dryRun", requestOptions.DryRun)
 
 	return nil
 }
 
-func (i *injector) shouldInjectContainer(ctx context.Context, pod *corev1.Pod, namespace string) (bool, error) {
-	if _, hasInjectedLabel := pod.Labels[InjectedLabel]; hasInjectedLabel {
-		return false, nil
+func namespaceAttribute(namespace string) attribute.KeyValue {
+	return attribute.String("k8s.namespace.name", namespace)
+}
+
+// requestLogger returns logger carrying details of the admission request stored in given context
+// or fallback logger, if context has no logger.
+func requestLogger(ctx context.Context, fallback logr.Logger) logr.Logger {
+	if logger, err := logr.FromContext(ctx); err == nil {
+		return logger
+	}
+
+	return fallback
+}
+
+// shouldInjectContainer decides if agent should be injected into given Pod. If decision was made by injection
+// policy, the policy and the Namespace used for matching it are returned as well.
+//
+//nolint:cyclop
+func (i *injector) shouldInjectContainer(
+	ctx context.Context,
+	pod *corev1.Pod,
+	namespace string,
+) (webhook.Decision, *InjectionPolicy, *corev1.Namespace, error) {
+	decision := webhook.Decision{
+		PolicyIndex: -1,
 	}
 
 	if _, hasDisableInjectionLabel := pod.Labels[DisableInjectionLabel]; hasDisableInjectionLabel {
-		return false, nil
+		decision.Reason = "injection disabled by label"
+
+		return decision, nil, nil, nil
 	}
 
 	// In case the pods has been created by a Job we do not inject the Pod.
 	for _, o := range pod.GetOwnerReferences() {
 		// Notice that also CronJobs are excluded since they creates Jobs that then create and own Pods.
 		if o.Kind == "Job" && (o.APIVersion == "batch/v1" || o.APIVersion == "batch/v1beta1") {
-			return false, nil
+			decision.Reason = "owned by Job"
+
+			return decision, nil, nil, nil
+		}
+	}
+
+	for _, excludedNamespace := range i.config.ExcludeNamespaces {
+		if namespace == excludedNamespace {
+			decision.Rule = ExcludeNamespacesRule
+			decision.Reason = "namespace excluded"
+
+			return decision, nil, nil, nil
 		}
 	}
 
 	ns, err := i.policyNamespace(ctx, namespace)
 	if err != nil {
-		return false, fmt.Errorf("getting Namespace %q for policy matching: %w", namespace, err)
+		return decision, nil, nil, fmt.Errorf("getting Namespace %q for policy matching: %w", namespace, err)
 	}
 
-	return matchPolicies(pod, ns, i.config.Policies), nil
+	policy := matchPolicies(pod, ns, i.config.Policies)
+	if policy == nil {
+		decision.Reason = "no policy matched"
+
+		return decision, nil, nil, nil
+	}
+
+	decision.PolicyIndex = policy.index
+	decision.Rule = policy.rule()
+	decision.Inject = policy.Action != PolicyActionExclude
+	decision.Reason = fmt.Sprintf("matched %s policy %q", policy.actionOrDefault(), decision.Rule)
+
+	return decision, policy, ns, nil
 }
 
-func (i *injector) canInjectContainer(pod *corev1.Pod, containerToInject corev1.Container) error {
-	volumes := pod.Spec.Volumes
-	duplicateVolumeNames := getDuplicateVolumeNames(append(volumes, toEmptyDirVolumes(containerToInject.VolumeMounts)...))
+// containerIndex returns index of container with given name or -1, if there is no such container.
+func containerIndex(containers []corev1.Container, name string) int {
+	for i, c := range containers {
+		if c.Name == name {
+			return i
+		}
+	}
 
-	// Checking if there is any overlapping with the volumes we want to mount and the volumes already present.
-	if len(duplicateVolumeNames) > 0 {
-		//nolint:err113
-		return fmt.Errorf("injecting agent would produce duplicate Pod volumes: %s",
-			strings.Join(duplicateVolumeNames, ","))
+	return -1
+}
+
+// volumeIndex returns index of volume with given name or -1, if there is no such volume.
+func volumeIndex(volumes []corev1.Volume, name string) int {
+	for i, v := range volumes {
+		if v.Name == name {
+			return i
+		}
 	}
 
-	return nil
+	return -1
 }
 
-func getDuplicateVolumeNames(volumes []corev1.Volume) []string {
-	duplicates := []string{}
-	unique := map[string]struct{}{}
+// reconcileSidecar adds sidecar container to the Pod or replaces the existing one with given index, if it differs
+// from the desired state.
+func reconcileSidecar(
+	pod *corev1.Pod,
+	sidecarIndex int,
+	container corev1.Container,
+	customAttributesEnv []corev1.EnvVar,
+	requestOptions webhook.RequestOptions,
+) {
+	if isNativeSidecar(&container) {
+		reconcileNativeSidecar(pod, container, customAttributesEnv, requestOptions)
 
-	for _, v := range volumes {
-		if _, ok := unique[v.Name]; ok {
-			duplicates = append(duplicates, v.Name)
-		}
+		return
+	}
+
+	if sidecarIndex < 0 {
+		// Custom attributes depend on Pod labels, so they are added separately from the container,
+		// which is shared by all Pods with the same configuration.
+		requestOptions.AddPatch(webhook.AddContainer(container))
+		requestOptions.AddPatch(webhook.AddEnv(len(pod.Spec.Containers), container.Env, customAttributesEnv)...)
+
+		container.Env = append(container.Env, customAttributesEnv...)
+		pod.Spec.Containers = append(pod.Spec.Containers, container)
 
-		unique[v.Name] = struct{}{}
+		return
 	}
 
-	return duplicates
-}
+	container.Env = append(container.Env, customAttributesEnv...)
 
-// policyNamespace returns Namespace object suitable for policy matching. If there is at least one policy
-// using namespaceSelector, full Namespace object is fetched, otherwise just stub object with filled name
-// is returned.
-func (i *injector) policyNamespace(ctx context.Context, namespace string) (*corev1.Namespace, error) {
-	for _, policy := range i.config.Policies {
-		if policy.namespaceSelector != nil {
-			return i.getNamespace(ctx, namespace)
-		}
+	if equality.Semantic.DeepEqual(pod.Spec.Containers[sidecarIndex], container) {
+		return
 	}
 
-	return &corev1.Namespace{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: namespace,
-		},
-	}, nil
+	requestOptions.AddPatch(webhook.ReplaceContainer(sidecarIndex, container))
+	pod.Spec.Containers[sidecarIndex] = container
 }
 
-// getNamespace fetches namespace object by name.
-func (i *injector) getNamespace(ctx context.Context, namespace string) (*corev1.Namespace, error) {
-	ns := &corev1.Namespace{}
+// reconcileNativeSidecar adds sidecar init container to the Pod or replaces the existing one, if it differs from
+// the desired state. Native sidecar is appended after existing init containers, so it does not delay them.
+func reconcileNativeSidecar(
+	pod *corev1.Pod,
+	container corev1.Container,
+	customAttributesEnv []corev1.EnvVar,
+	requestOptions webhook.RequestOptions,
+) {
+	idx := containerIndex(pod.Spec.InitContainers, container.Name)
+	if idx < 0 {
+		requestOptions.AddPatch(webhook.AddInitContainer(pod.Spec.InitContainers, container))
+		requestOptions.AddPatch(webhook.AddInitContainerEnv(len(pod.Spec.InitContainers), container.Env,
+			customAttributesEnv)...)
 
-	key := client.ObjectKey{
-		Name: namespace,
+		container.Env = append(container.Env, customAttributesEnv...)
+		pod.Spec.InitContainers = append(pod.Spec.InitContainers, container)
+
+		return
 	}
 
-	if err := i.client.Get(ctx, key, ns); err != nil {
-		return nil, fmt.Errorf("getting Namespace %q: %w", namespace, err)
+	container.Env = append(container.Env, customAttributesEnv...)
+
+	if equality.Semantic.DeepEqual(pod.Spec.InitContainers[idx], container) {
+		return
 	}
 
-	return ns, nil
+	requestOptions.AddPatch(webhook.ReplaceInitContainer(idx, container))
+	pod.Spec.InitContainers[idx] = container
 }
 
-// matchPolicies checks if given Pod matches any of given policies.
-func matchPolicies(pod *corev1.Pod, ns *corev1.Namespace, policies []InjectionPolicy) bool {
-	for _, policy := range policies {
-		policy := policy
+// reconcileVolumes adds missing volumes to the Pod and replaces existing volumes with the same names,
+// if they differ from the desired state.
+func reconcileVolumes(pod *corev1.Pod, volumes []corev1.Volume, requestOptions webhook.RequestOptions) {
+	missing := []corev1.Volume{}
 
-		if matchPolicy(pod, ns, &policy) {
-			return true
+	for _, volume := range volumes {
+		idx := volumeIndex(pod.Spec.Volumes, volume.Name)
+		if idx < 0 {
+			missing = append(missing, volume)
+
+			continue
+		}
+
+		if !equality.Semantic.DeepEqual(pod.Spec.Volumes[idx], volume) {
+			requestOptions.AddPatch(webhook.ReplaceVolume(idx, volume))
+			pod.Spec.Volumes[idx] = volume
 		}
 	}
 
-	return false
+	requestOptions.AddPatch(webhook.AddVolumes(pod.Spec.Volumes, missing)...)
+	pod.Spec.Volumes = append(pod.Spec.Volumes, missing...)
+}
+
+// policyNamespace returns Namespace object suitable for policy matching. If there is at least one policy
+// using namespaceSelector or Pod Security Admission labels must be evaluated, full Namespace object is fetched,
+// otherwise just stub object with filled name is returned.
+func (i *injector) policyNamespace(ctx context.Context, namespace string) (_ *corev1.Namespace, err error) {
+	ctx, span := i.tracer.Start(ctx, "policyNamespace", trace.WithAttributes(namespaceAttribute(namespace)))
+	defer func() { tracing.End(span, err) }()
+
+	if i.config.LooksUpNamespaces() {
+		return i.getNamespace(ctx, namespace)
+	}
+
+	return &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: namespace,
+		},
+	}, nil
+}
+
+// matchPolicies returns the first policy matching given Pod or nil if Pod matches none of them.
+func matchPolicies(pod *corev1.Pod, ns *corev1.Namespace, policies []InjectionPolicy) *InjectionPolicy {
+	for i := range policies {
+		if matchPolicy(pod, ns, &policies[i]) {
+			return &policies[i]
+		}
+	}
+
+	return nil
 }
 
 // matchPolicy checks if given Pod is matching given policy.
@@ -462,40 +764,118 @@ func matchPolicy(pod *corev1.Pod, ns *corev1.Namespace, policy *InjectionPolicy)
 	return true
 }
 
-func (i *injector) ensureSidecarDependencies(ctx context.Context, pod *corev1.Pod, options webhook.RequestOptions) error {
+func (i *injector) ensureSidecarDependencies(
+	ctx context.Context,
+	pod *corev1.Pod,
+	integrationsConfigMap *corev1.ConfigMap,
+	options webhook.RequestOptions,
+) error {
 	if options.DryRun {
 		return nil
 	}
 
-	if err := i.ensureLicenseSecretExistence(ctx, options.Namespace); err != nil {
+	deps := dependencies{
+		namespace:      options.Namespace,
+		serviceAccount: pod.Spec.ServiceAccountName,
+	}
+
+	if integrationsConfigMap != nil {
+		deps.configMap = integrationsConfigMap.Name
+	}
+
+	if i.provisioner != nil {
+		i.provisioner.enqueue(ctx, deps, integrationsConfigMap)
+
+		return nil
+	}
+
+	return i.provisionDependencies(ctx, deps, integrationsConfigMap)
+}
+
+// provisionDependencies ensures that license Secret, ClusterRoleBinding subject and integrations ConfigMap,
+// if given, required by the sidecar exist.
+func (i *injector) provisionDependencies(
+	ctx context.Context,
+	deps dependencies,
+	integrationsConfigMap *corev1.ConfigMap,
+) error {
+	if err := i.ensureLicenseSecretExistence(ctx, deps.namespace); err != nil {
 		return fmt.Errorf("ensuring Secret presence: %w", err)
 	}
 
-	if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-		return i.ensureClusterRoleBindingSubject(ctx, pod.Spec.ServiceAccountName, options.Namespace)
-	}); err != nil {
-		return fmt.Errorf("ensuring ClusterRoleBinding subject: %w", err)
+	if err := i.ensureSubject(ctx, deps.serviceAccount, deps.namespace); err != nil {
+		return err
+	}
+
+	if integrationsConfigMap != nil {
+		if err := i.ensureIntegrationsConfigMap(ctx, integrationsConfigMap); err != nil {
+			return fmt.Errorf("ensuring integrations ConfigMap presence: %w", err)
+		}
 	}
 
 	return nil
 }
 
-func (i *injector) applyAgentConfig(podLabels map[string]string, container *corev1.Container) string {
-	for _, r := range i.config.AgentConfig.ConfigSelectors {
-		if r.selector.Matches(labels.Set(podLabels)) {
-			if r.ResourceRequirements != nil {
-				container.Resources = *r.ResourceRequirements
-			}
+// sidecarFor returns sidecar to be injected into given Pod. If given policy has a profile, the profile sidecar
+// is used, otherwise the global configuration with matching config selector applied.
+func (i *injector) sidecarFor(pod *corev1.Pod, policy *InjectionPolicy) sidecar {
+	if policy != nil && policy.profile != nil {
+		s := *policy.profile
+		s.container = *s.container.DeepCopy()
 
-			for k, v := range r.ExtraEnvVars {
-				container.Env = append(container.Env, corev1.EnvVar{Name: k, Value: v})
-			}
+		return s
+	}
+
+	s := sidecar{
+		container:        *i.container.DeepCopy(),
+		customAttributes: i.config.AgentConfig.CustomAttributes,
+	}
+
+	selector := i.matchConfigSelector(pod.Labels)
+	s.hash = i.applyAgentConfig(selector, &s.container)
+
+	if selector != nil {
+		s.integrations = selector.integrations
+		s.sizing = selector.Sizing
+	}
 
-			return r.hash
+	return s
+}
+
+// matchConfigSelector returns first config selector matching given Pod labels or nil, if none of them matches.
+func (i *injector) matchConfigSelector(podLabels map[string]string) *ConfigSelector {
+	for idx, r := range i.config.AgentConfig.ConfigSelectors {
+		if r.selector.Matches(labels.Set(podLabels)) {
+			return &i.config.AgentConfig.ConfigSelectors[idx]
 		}
 	}
 
-	return i.configHash
+	return nil
+}
+
+func (i *injector) applyAgentConfig(selector *ConfigSelector, container *corev1.Container) string {
+	if selector == nil {
+		return i.configHash
+	}
+
+	if selector.ResourceRequirements != nil {
+		container.Resources = *selector.ResourceRequirements
+	}
+
+	envNames := make([]string, 0, len(selector.ExtraEnvVars))
+	for k := range selector.ExtraEnvVars {
+		envNames = append(envNames, k)
+	}
+
+	sort.Strings(envNames)
+
+	for _, k := range envNames {
+		container.Env = append(container.Env, corev1.EnvVar{Name: k, Value: selector.ExtraEnvVars[k]})
+	}
+
+	selector.Probes.apply(container)
+
+	return selector.hash
 }
 
 type configHash struct {
@@ -506,6 +886,11 @@ type configHash struct {
 	PodSecurityContext   PodSecurityContext
 	ResourceRequirements *corev1.ResourceRequirements
 	ExtraEnvVars         map[string]string
+	Integrations         []Integration
+	Volumes              *SidecarVolumes `json:",omitempty"`
+	Probes               *Probes         `json:",omitempty"`
+	Lifecycle            *Lifecycle      `json:",omitempty"`
+	Sizing               *ResourceSizing `json:",omitempty"`
 	Container            corev1.Container
 }
 
@@ -519,6 +904,7 @@ func (config *InjectorConfig) buildConfigSelectors(container corev1.Container, l
 		}
 
 		config.AgentConfig.ConfigSelectors[i].selector = selector
+		config.AgentConfig.ConfigSelectors[i].integrations = config.selectedIntegrations(r.Integrations)
 
 		configHash := &configHash{
 			CustomAttributes:     config.AgentConfig.CustomAttributes,
@@ -528,6 +914,11 @@ func (config *InjectorConfig) buildConfigSelectors(container corev1.Container, l
 			PodSecurityContext:   config.AgentConfig.PodSecurityContext,
 			ResourceRequirements: r.ResourceRequirements,
 			ExtraEnvVars:         r.ExtraEnvVars,
+			Integrations:         config.AgentConfig.ConfigSelectors[i].integrations,
+			Volumes:              config.AgentConfig.Volumes.hashed(),
+			Probes:               r.Probes,
+			Lifecycle:            config.AgentConfig.Lifecycle,
+			Sizing:               r.Sizing,
 			Container:            container,
 		}
 
@@ -545,41 +936,6 @@ func (config *InjectorConfig) buildConfigSelectors(container corev1.Container, l
 	return nil
 }
 
-func standardVolumes() []corev1.VolumeMount {
-	return []corev1.VolumeMount{
-		{
-			Name:      "tmpfs-data-injected",
-			MountPath: "/var/db/newrelic-infra/data",
-		},
-		{
-			Name:      "tmpfs-user-data-injected",
-			MountPath: "/var/db/newrelic-infra/user_data",
-		},
-		{
-			Name:      "tmpfs-tmp-injected",
-			MountPath: "/tmp",
-		},
-		{
-			Name:      "tmpfs-cache-injected",
-			MountPath: "/var/cache/nr-kubernetes",
-		},
-	}
-}
-
-func toEmptyDirVolumes(volumeMounts []corev1.VolumeMount) []corev1.Volume {
-	volumes := []corev1.Volume{}
-	for _, v := range volumeMounts {
-		volumes = append(volumes, corev1.Volume{
-			Name: v.Name,
-			VolumeSource: corev1.VolumeSource{
-				EmptyDir: &corev1.EmptyDirVolumeSource{},
-			},
-		})
-	}
-
-	return volumes
-}
-
 func standardEnvVar(secretName string, clusterName string) []corev1.EnvVar {
 	return []corev1.EnvVar{
 		{
@@ -634,9 +990,15 @@ func getAgentPassthroughEnvironment() string {
 	return strings.Join(flags, ",")
 }
 
-//nolint:gosec
 func (c configHash) calculate() (string, error) {
-	b, err := yaml.Marshal(c)
+	return hashOf(c)
+}
+
+// hashOf returns hex encoded SHA1 sum of YAML representation of given value.
+//
+//nolint:gosec
+func hashOf(v any) (string, error) {
+	b, err := yaml.Marshal(v)
 	if err != nil {
 		return "", fmt.Errorf("marshaling input: %w", err)
 	}
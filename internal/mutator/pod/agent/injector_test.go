@@ -4,20 +4,33 @@
 package agent_test
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
 	"strings"
 	"testing"
+	"time"
 
 	"k8s.io/utils/ptr"
 
 	"github.com/go-logr/logr"
 	"github.com/go-logr/logr/testr"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/events"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 
@@ -42,6 +55,15 @@ const (
 	customAttributeWithEmptyValueName         = "withEmptyValue"
 	customAttributeWithEmptyValueLabel        = "with-empty-value"
 	customAttributeWithEmptyValueDefaultValue = "anotherDefaultValue"
+
+	testIntegrationConfig = `integrations:
+- name: nri-redis
+  env:
+    HOSTNAME: {{ .PodIP }}
+    PORT: {{ index .Ports "redis" }}
+    LABELS: '{"app":"{{ index .Labels "app" }}"}'
+    NAMESPACE: {{ .Namespace }}
+`
 )
 
 //nolint:funlen
@@ -103,6 +125,54 @@ func Test_Creating_injector(t *testing.T) {
 					},
 				}
 			},
+			"injection_policy_references_undefined_profile": func(c *agent.InjectorConfig) {
+				c.Policies = []agent.InjectionPolicy{
+					{
+						Profile: "fargate",
+					},
+				}
+			},
+			"exclude_injection_policy_references_profile": func(c *agent.InjectorConfig) {
+				c.Profiles = []agent.AgentProfile{{Name: "fargate"}}
+				c.Policies = []agent.InjectionPolicy{
+					{
+						Action:  agent.PolicyActionExclude,
+						Profile: "fargate",
+					},
+				}
+			},
+			"duplicate_profile_is_defined": func(c *agent.InjectorConfig) {
+				c.Profiles = []agent.AgentProfile{{Name: "fargate"}, {Name: "fargate"}}
+			},
+			"profile_defines_clusterName_custom_attribute": func(c *agent.InjectorConfig) {
+				c.Profiles = []agent.AgentProfile{
+					{
+						Name: "fargate",
+						CustomAttributes: []agent.CustomAttribute{
+							{
+								Name:         "clusterName",
+								DefaultValue: "foo",
+							},
+						},
+					},
+				}
+			},
+			"number_of_ClusterRoleBinding_shards_is_negative": func(c *agent.InjectorConfig) {
+				c.ClusterRoleBindingSharding.Shards = -1
+			},
+			"async_dependencies_wait_is_negative": func(c *agent.InjectorConfig) {
+				c.AsyncDependencies.Wait = metav1.Duration{Duration: -time.Second}
+			},
+			"async_dependencies_resync_period_is_negative": func(c *agent.InjectorConfig) {
+				c.AsyncDependencies.ResyncPeriod = metav1.Duration{Duration: -time.Second}
+			},
+			"injection_policy_has_unsupported_action": func(c *agent.InjectorConfig) {
+				c.Policies = []agent.InjectionPolicy{
+					{
+						Action: "foo",
+					},
+				}
+			},
 			"invalid_pod_selector_is_configured_for_injection_policy": func(c *agent.InjectorConfig) {
 				c.Policies = []agent.InjectionPolicy{
 					{
@@ -114,6 +184,40 @@ func Test_Creating_injector(t *testing.T) {
 					},
 				}
 			},
+			"config_selector_references_undefined_integration": func(c *agent.InjectorConfig) {
+				c.AgentConfig.ConfigSelectors = []agent.ConfigSelector{
+					{
+						Integrations: []string{"nri-redis"},
+					},
+				}
+			},
+			"integration_has_no_name_set": func(c *agent.InjectorConfig) {
+				c.AgentConfig.Integrations = []agent.Integration{
+					{
+						Config: "integrations: []",
+					},
+				}
+			},
+			"integration_config_is_not_a_valid_template": func(c *agent.InjectorConfig) {
+				c.AgentConfig.Integrations = []agent.Integration{
+					{
+						Name:   "nri-redis",
+						Config: "{{ .PodIP",
+					},
+				}
+			},
+			"duplicate_integration_is_defined": func(c *agent.InjectorConfig) {
+				c.AgentConfig.Integrations = []agent.Integration{
+					{
+						Name:   "nri-redis",
+						Config: "integrations: []",
+					},
+					{
+						Name:   "nri-redis",
+						Config: "integrations: []",
+					},
+				}
+			},
 			"invalid_pod_selector_is_configured_for_agent_config": func(c *agent.InjectorConfig) {
 				c.AgentConfig.ConfigSelectors = []agent.ConfigSelector{
 					{
@@ -430,232 +534,2128 @@ func Test_Mutate(t *testing.T) {
 			}
 		})
 
-		t.Run("and_when_Pod_matches_the_first_matching_config_rule_it_gets", func(t *testing.T) {
+		t.Run("and_when_security_context_is_configured_it_gets_set_on_injected_container", func(t *testing.T) {
 			t.Parallel()
 
-			p := getEmptyPod()
-			p.Labels["matching-key"] = "matching-value"
-
-			c := fake.NewClientBuilder().WithObjects(getCRB(testResourcePrefix)).Build()
-			config := getConfig()
-			config.AgentConfig.ConfigSelectors = []agent.ConfigSelector{
-				{
-					ResourceRequirements: &corev1.ResourceRequirements{
-						Limits: corev1.ResourceList{
-							corev1.ResourceMemory: *resource.NewScaledQuantity(100, resource.Mega),
-						},
+			cases := map[string]struct {
+				configF  func(*agent.InfraAgentConfig)
+				expected *corev1.SecurityContext
+			}{
+				"restricted_preset": {
+					configF: func(c *agent.InfraAgentConfig) {
+						c.SecurityContextPreset = agent.SecurityContextPresetRestricted
+						c.PodSecurityContext.RunAsUser = 1000
 					},
-					LabelSelector: metav1.LabelSelector{
-						MatchLabels: map[string]string{
-							"not-matching-key": "not-matching-value",
-						},
+					expected: &corev1.SecurityContext{
+						ReadOnlyRootFilesystem:   ptr.To(true),
+						AllowPrivilegeEscalation: ptr.To(false),
+						RunAsNonRoot:             ptr.To(true),
+						RunAsUser:                ptr.To[int64](1000),
+						Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+						SeccompProfile:           &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
 					},
 				},
-				{
-					ExtraEnvVars: map[string]string{
-						"EXTRA_ENV": "EXTRA_VALUE",
-					},
-					ResourceRequirements: &corev1.ResourceRequirements{
-						Limits: corev1.ResourceList{
-							corev1.ResourceMemory: *resource.NewScaledQuantity(300, resource.Mega),
-						},
+				"security_context_overriding_preset_and_defaults": {
+					configF: func(c *agent.InfraAgentConfig) {
+						c.SecurityContextPreset = agent.SecurityContextPresetRestricted
+						c.PodSecurityContext.RunAsUser = 1000
+						c.SecurityContext = &corev1.SecurityContext{
+							ReadOnlyRootFilesystem: ptr.To(false),
+							RunAsUser:              ptr.To[int64](2000),
+							SeccompProfile: &corev1.SeccompProfile{
+								Type:             corev1.SeccompProfileTypeLocalhost,
+								LocalhostProfile: ptr.To("profiles/agent.json"),
+							},
+							AppArmorProfile: &corev1.AppArmorProfile{Type: corev1.AppArmorProfileTypeRuntimeDefault},
+						}
 					},
-					LabelSelector: metav1.LabelSelector{
-						MatchLabels: map[string]string{
-							"matching-key": "matching-value",
+					expected: &corev1.SecurityContext{
+						ReadOnlyRootFilesystem:   ptr.To(false),
+						AllowPrivilegeEscalation: ptr.To(false),
+						RunAsNonRoot:             ptr.To(true),
+						RunAsUser:                ptr.To[int64](2000),
+						Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+						SeccompProfile: &corev1.SeccompProfile{
+							Type:             corev1.SeccompProfileTypeLocalhost,
+							LocalhostProfile: ptr.To("profiles/agent.json"),
 						},
+						AppArmorProfile: &corev1.AppArmorProfile{Type: corev1.AppArmorProfileTypeRuntimeDefault},
 					},
 				},
 			}
 
-			i, err := config.New(c, c, testr.New(t))
-			if err != nil {
-				t.Fatalf("creating injector: %v", err)
-			}
+			for testCaseName, testData := range cases {
+				testData := testData
 
-			if err := i.Mutate(ctx, p, req); err != nil {
-				t.Fatalf("mutating Pod: %v", err)
-			}
+				t.Run(testCaseName, func(t *testing.T) {
+					t.Parallel()
 
-			infraContainer := infraContainer(t, p)
+					p := getEmptyPod()
 
-			t.Run("env_var_configured_from_it", func(t *testing.T) {
-				t.Parallel()
+					c := fake.NewClientBuilder().WithObjects(getCRB(testResourcePrefix)).Build()
+					config := getConfig()
+					testData.configF(&config.AgentConfig)
 
-				found := false
-				for _, env := range infraContainer.Env {
-					if env.Name == "EXTRA_ENV" && env.Value == "EXTRA_VALUE" {
-						found = true
+					i, err := config.New(c, c, testr.New(t))
+					if err != nil {
+						t.Fatalf("creating injector: %v", err)
 					}
-				}
-				if !found {
-					t.Fatalf("missing expecting env var")
-				}
-			})
 
-			t.Run("resources_configured_from_it", func(t *testing.T) {
-				t.Parallel()
+					if err := i.Mutate(ctx, p, req); err != nil {
+						t.Fatalf("mutating Pod: %v", err)
+					}
 
-				q := infraContainer.Resources.Limits[corev1.ResourceMemory]
-				if q != *resource.NewScaledQuantity(300, resource.Mega) {
-					t.Fatalf("not-default CPU limit was expected: %s.", q.String())
-				}
-			})
+					if diff := cmp.Diff(testData.expected, infraContainer(t, p).SecurityContext); diff != "" {
+						t.Fatalf("unexpected security context: %s", diff)
+					}
+				})
+			}
 		})
 
-		t.Run("and_when_Pod_does_not_match_any_config_rule_it_gets", func(t *testing.T) {
+		t.Run("and_when_config_selector_sizes_sidecar", func(t *testing.T) {
 			t.Parallel()
 
-			p := getEmptyPod()
-
-			c := fake.NewClientBuilder().WithObjects(getCRB(testResourcePrefix)).Build()
-			config := getConfig()
-			config.AgentConfig.ConfigSelectors = []agent.ConfigSelector{
-				{
-					ResourceRequirements: &corev1.ResourceRequirements{
-						Limits: corev1.ResourceList{
-							corev1.ResourceMemory: *resource.NewScaledQuantity(100, resource.Mega),
-						},
-					},
-					LabelSelector: metav1.LabelSelector{
-						MatchLabels: map[string]string{
-							"not-matching-key": "not-matching-value",
+			podWithRequests := func(containers int) *corev1.Pod {
+				p := getEmptyPod()
+				p.Labels["sizing"] = "enabled"
+				p.Spec.Containers = nil
+
+				for idx := 0; idx < containers; idx++ {
+					p.Spec.Containers = append(p.Spec.Containers, corev1.Container{
+						Name:  fmt.Sprintf("app-%d", idx),
+						Image: "nginx",
+						Resources: corev1.ResourceRequirements{
+							Requests: corev1.ResourceList{
+								corev1.ResourceCPU:    resource.MustParse("500m"),
+								corev1.ResourceMemory: resource.MustParse("512Mi"),
+							},
 						},
-					},
-				},
-			}
+					})
+				}
 
-			i, err := config.New(c, c, testr.New(t))
-			if err != nil {
-				t.Fatalf("creating injector: %v", err)
+				return p
 			}
 
-			if err := i.Mutate(ctx, p, req); err != nil {
-				t.Fatalf("mutating Pod: %v", err)
-			}
+			injectorWith := func(t *testing.T, sizing *agent.ResourceSizing, objects ...client.Object) agent.Injector {
+				t.Helper()
 
-			infraContainer := infraContainer(t, p)
+				config := getConfig()
+				config.AgentConfig.ConfigSelectors = []agent.ConfigSelector{
+					{
+						LabelSelector: metav1.LabelSelector{MatchLabels: map[string]string{"sizing": "enabled"}},
+						Sizing:        sizing,
+					},
+				}
 
-			t.Run("sidecar_resources_empty", func(t *testing.T) {
-				t.Parallel()
+				c := fake.NewClientBuilder().WithObjects(append(objects, getCRB(testResourcePrefix))...).Build()
 
-				if diff := cmp.Diff(infraContainer.Resources, corev1.ResourceRequirements{}); diff != "" {
-					t.Fatalf("unexpected resources diff:\n%s", diff)
+				i, err := config.New(c, c, testr.New(t))
+				if err != nil {
+					t.Fatalf("creating injector: %v", err)
 				}
-			})
-		})
-	})
 
-	t.Run("when_succeeds_with_dry_run_option", func(t *testing.T) {
-		t.Parallel()
+				return i
+			}
 
-		req := webhook.RequestOptions{
-			Namespace: testNamespace,
-			DryRun:    true,
-		}
+			equalQuantities := cmp.Comparer(func(a, b resource.Quantity) bool { return a.Cmp(b) == 0 })
 
-		p := getEmptyPod()
-		c := fake.NewClientBuilder().WithObjects(getCRB(testResourcePrefix)).Build()
-		config := getConfig()
+			fraction := &agent.ResourceSizing{
+				Fraction: &agent.FractionSizing{
+					Percent: 10,
+					Min: corev1.ResourceList{
+						corev1.ResourceCPU:    resource.MustParse("50m"),
+						corev1.ResourceMemory: resource.MustParse("64Mi"),
+					},
+					Max: corev1.ResourceList{
+						corev1.ResourceMemory: resource.MustParse("100Mi"),
+					},
+					LimitPercent: 200,
+				},
+			}
 
-		i, err := config.New(c, c, testr.New(t))
-		if err != nil {
-			t.Fatalf("creating injector: %v", err)
-		}
+			t.Run("computes_resources_as_fraction_of_Pod_requests_within_bounds", func(t *testing.T) {
+				t.Parallel()
 
-		if err := i.Mutate(ctx, p, req); err != nil {
-			t.Fatalf("mutating Pod: %v", err)
-		}
+				p := podWithRequests(2)
+				if err := injectorWith(t, fraction).Mutate(ctx, p, req); err != nil {
+					t.Fatalf("mutating Pod: %v", err)
+				}
 
-		t.Run("adds_infrastructure_agent_sidecar_container_to_given_pod", func(t *testing.T) {
-			t.Parallel()
+				expected := corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{
+						corev1.ResourceCPU:    resource.MustParse("100m"),
+						corev1.ResourceMemory: resource.MustParse("100Mi"),
+					},
+					Limits: corev1.ResourceList{
+						corev1.ResourceCPU:    resource.MustParse("200m"),
+						corev1.ResourceMemory: resource.MustParse("200Mi"),
+					},
+				}
 
-			if len(p.Spec.Containers) != 2 {
-				t.Fatalf("expected extra container to be added, got %v", cmp.Diff(getEmptyPod(), p))
-			}
-		})
+				if diff := cmp.Diff(expected, infraContainer(t, p).Resources, equalQuantities); diff != "" {
+					t.Fatalf("unexpected sidecar resources: %s", diff)
+				}
 
-		t.Run("does_not_create_license_Secret_for_Pod", func(t *testing.T) {
-			t.Parallel()
+				expectedAnnotation := "fraction=10%; requests=cpu=100m,memory=100Mi; limits=cpu=200m,memory=200Mi"
 
-			secret := &corev1.Secret{}
-			if err := c.Get(ctx, secretKey, secret); err == nil || !errors.IsNotFound(err) {
-				t.Fatalf("secret found in the cluster or err different from expected one: %v", err)
-			}
-		})
+				if got := p.Annotations[agent.SizingAnnotation]; got != expectedAnnotation {
+					t.Fatalf("expected sizing annotation %q, got %q", expectedAnnotation, got)
+				}
+			})
 
-		t.Run("does_not_add_pods_ServiceAccount_to_infrastructure_agent_ClusterRoleBinding", func(t *testing.T) {
-			t.Parallel()
+			t.Run("uses_tier_matching_number_of_Pod_containers", func(t *testing.T) {
+				t.Parallel()
 
-			key := client.ObjectKey{
-				Name: clusterRoleBindingName(testResourcePrefix),
-			}
+				tiers := &agent.ResourceSizing{
+					Tiers: []agent.SizingTier{
+						{
+							MaxContainers: 1,
+							Resources: corev1.ResourceRequirements{
+								Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Mi")},
+							},
+						},
+						{
+							Resources: corev1.ResourceRequirements{
+								Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("128Mi")},
+							},
+						},
+					},
+				}
 
-			crb := &rbacv1.ClusterRoleBinding{}
+				i := injectorWith(t, tiers)
 
-			if err := c.Get(ctx, key, crb); err != nil {
-				t.Fatalf("getting ClusterRoleBinding: %v", err)
-			}
+				for containers, expected := range map[int]string{1: "64Mi", 3: "128Mi"} {
+					p := podWithRequests(containers)
+					if err := i.Mutate(ctx, p, req); err != nil {
+						t.Fatalf("mutating Pod: %v", err)
+					}
 
-			found := false
+					memory := infraContainer(t, p).Resources.Requests[corev1.ResourceMemory]
+					if memory.String() != expected {
+						t.Fatalf("expected memory request %s for %d containers, got %s", expected, containers, &memory)
+					}
+				}
+			})
 
-			for _, subject := range crb.Subjects {
-				if subject.Name == "default" && subject.Namespace == testNamespace {
-					found = true
+			t.Run("respects_Namespace_LimitRange_and_ResourceQuota", func(t *testing.T) {
+				t.Parallel()
 
-					break
+				limitRange := &corev1.LimitRange{
+					ObjectMeta: metav1.ObjectMeta{Name: "limits", Namespace: testNamespace},
+					Spec: corev1.LimitRangeSpec{
+						Limits: []corev1.LimitRangeItem{
+							{
+								Type: corev1.LimitTypeContainer,
+								Max:  corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("150m")},
+							},
+						},
+					},
 				}
-			}
 
-			if found {
-				t.Fatalf("found unexpected subject in ClusterRoleBinding, got: %v", crb)
-			}
-		})
-	})
+				quota := &corev1.ResourceQuota{
+					ObjectMeta: metav1.ObjectMeta{Name: "quota", Namespace: testNamespace},
+					Spec: corev1.ResourceQuotaSpec{
+						Hard: corev1.ResourceList{"requests.memory": resource.MustParse("1100Mi")},
+					},
+					Status: corev1.ResourceQuotaStatus{
+						Used: corev1.ResourceList{"requests.memory": resource.MustParse("0")},
+					},
+				}
 
-	t.Run("handles_pods_without_labels", func(t *testing.T) {
-		t.Parallel()
+				p := podWithRequests(2)
+				if err := injectorWith(t, fraction, limitRange, quota).Mutate(ctx, p, req); err != nil {
+					t.Fatalf("mutating Pod: %v", err)
+				}
 
-		p := getEmptyPod()
-		p.Labels = nil
+				expected := corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{
+						corev1.ResourceCPU:    resource.MustParse("100m"),
+						corev1.ResourceMemory: resource.MustParse("76Mi"),
+					},
+					Limits: corev1.ResourceList{
+						corev1.ResourceCPU:    resource.MustParse("150m"),
+						corev1.ResourceMemory: resource.MustParse("200Mi"),
+					},
+				}
 
-		c := fake.NewClientBuilder().WithObjects(getCRB(testResourcePrefix)).Build()
-		config := getConfig()
+				if diff := cmp.Diff(expected, infraContainer(t, p).Resources, equalQuantities); diff != "" {
+					t.Fatalf("unexpected sidecar resources: %s", diff)
+				}
 
-		i, err := config.New(c, c, testr.New(t))
-		if err != nil {
-			t.Fatalf("creating injector: %v", err)
-		}
+				expectedConstraints := "constrainedBy=LimitRange/limits,ResourceQuota/quota"
 
-		if err := i.Mutate(ctx, p, req); err != nil {
-			t.Fatalf("mutating Pod: %v", err)
-		}
-	})
+				if got := p.Annotations[agent.SizingAnnotation]; !strings.HasSuffix(got, expectedConstraints) {
+					t.Fatalf("expected sizing annotation to list constraints, got %q", got)
+				}
+			})
 
-	t.Run("is_idempotent", func(t *testing.T) {
+			t.Run("fits_LimitRange_default_request_into_ResourceQuota", func(t *testing.T) {
+				t.Parallel()
+
+				memoryOnly := &agent.ResourceSizing{
+					Tiers: []agent.SizingTier{
+						{
+							Resources: corev1.ResourceRequirements{
+								Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Mi")},
+							},
+						},
+					},
+				}
+
+				limitRange := &corev1.LimitRange{
+					ObjectMeta: metav1.ObjectMeta{Name: "defaults", Namespace: testNamespace},
+					Spec: corev1.LimitRangeSpec{
+						Limits: []corev1.LimitRangeItem{
+							{
+								Type:           corev1.LimitTypeContainer,
+								DefaultRequest: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("200m")},
+								Default:        corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("400m")},
+							},
+						},
+					},
+				}
+
+				quota := &corev1.ResourceQuota{
+					ObjectMeta: metav1.ObjectMeta{Name: "quota", Namespace: testNamespace},
+					Spec: corev1.ResourceQuotaSpec{
+						Hard: corev1.ResourceList{"requests.cpu": resource.MustParse("1100m")},
+					},
+					Status: corev1.ResourceQuotaStatus{
+						Used: corev1.ResourceList{"requests.cpu": resource.MustParse("0")},
+					},
+				}
+
+				p := podWithRequests(2)
+				if err := injectorWith(t, memoryOnly, limitRange, quota).Mutate(ctx, p, req); err != nil {
+					t.Fatalf("mutating Pod: %v", err)
+				}
+
+				expected := corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{
+						corev1.ResourceCPU:    resource.MustParse("100m"),
+						corev1.ResourceMemory: resource.MustParse("64Mi"),
+					},
+					Limits: corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("400m"),
+					},
+				}
+
+				if diff := cmp.Diff(expected, infraContainer(t, p).Resources, equalQuantities); diff != "" {
+					t.Fatalf("unexpected sidecar resources: %s", diff)
+				}
+
+				expectedConstraints := "constrainedBy=LimitRange/defaults,ResourceQuota/quota"
+
+				if got := p.Annotations[agent.SizingAnnotation]; !strings.HasSuffix(got, expectedConstraints) {
+					t.Fatalf("expected sizing annotation to list constraints, got %q", got)
+				}
+			})
+		})
+
+		t.Run("and_when_lifecycle_is_configured", func(t *testing.T) {
+			t.Parallel()
+
+			injectorWith := func(t *testing.T, lifecycle *agent.Lifecycle) agent.Injector {
+				t.Helper()
+
+				config := getConfig()
+				config.AgentConfig.Lifecycle = lifecycle
+
+				c := fake.NewClientBuilder().WithObjects(getCRB(testResourcePrefix)).Build()
+
+				i, err := config.New(c, c, testr.New(t))
+				if err != nil {
+					t.Fatalf("creating injector: %v", err)
+				}
+
+				return i
+			}
+
+			t.Run("sets_configured_preStop_hook", func(t *testing.T) {
+				t.Parallel()
+
+				preStop := &corev1.LifecycleHandler{Sleep: &corev1.SleepAction{Seconds: 10}}
+
+				p := getEmptyPod()
+				if err := injectorWith(t, &agent.Lifecycle{PreStop: preStop}).Mutate(ctx, p, req); err != nil {
+					t.Fatalf("mutating Pod: %v", err)
+				}
+
+				sidecar := infraContainer(t, p)
+
+				if sidecar.Lifecycle == nil {
+					t.Fatalf("expected lifecycle to be set")
+				}
+
+				if diff := cmp.Diff(preStop, sidecar.Lifecycle.PreStop); diff != "" {
+					t.Fatalf("unexpected preStop hook: %s", diff)
+				}
+			})
+
+			t.Run("delays_shutdown_until_ports_of_other_containers_are_closed", func(t *testing.T) {
+				t.Parallel()
+
+				p := getEmptyPod()
+				p.Spec.Containers[0].Ports = []corev1.ContainerPort{
+					{ContainerPort: 8080},
+					{ContainerPort: 53, Protocol: corev1.ProtocolUDP},
+				}
+
+				i := injectorWith(t, &agent.Lifecycle{WaitForContainers: true, MaxShutdownDelaySeconds: 20})
+				if err := i.Mutate(ctx, p, req); err != nil {
+					t.Fatalf("mutating Pod: %v", err)
+				}
+
+				sidecar := infraContainer(t, p)
+
+				if sidecar.Lifecycle == nil || sidecar.Lifecycle.PreStop == nil || sidecar.Lifecycle.PreStop.Exec == nil {
+					t.Fatalf("expected exec preStop hook, got: %v", sidecar.Lifecycle)
+				}
+
+				script := strings.Join(sidecar.Lifecycle.PreStop.Exec.Command, " ")
+
+				for _, expected := range []string{":(1F90) ", "+ 20))"} {
+					if !strings.Contains(script, expected) {
+						t.Fatalf("expected preStop hook %q to contain %q", script, expected)
+					}
+				}
+			})
+
+			t.Run("delays_shutdown_by_maximum_delay_when_Pod_has_no_TCP_ports", func(t *testing.T) {
+				t.Parallel()
+
+				p := getEmptyPod()
+				if err := injectorWith(t, &agent.Lifecycle{WaitForContainers: true}).Mutate(ctx, p, req); err != nil {
+					t.Fatalf("mutating Pod: %v", err)
+				}
+
+				expected := []string{"/bin/sh", "-c", "sleep 30"}
+
+				if diff := cmp.Diff(expected, infraContainer(t, p).Lifecycle.PreStop.Exec.Command); diff != "" {
+					t.Fatalf("unexpected preStop hook: %s", diff)
+				}
+			})
+
+			t.Run("preStop_hook_waits_only_while_ports_of_other_containers_are_listening", func(t *testing.T) {
+				t.Parallel()
+
+				if _, err := os.Stat("/proc/net/tcp"); err != nil {
+					t.Skipf("listening sockets cannot be checked: %v", err)
+				}
+
+				i := injectorWith(t, &agent.Lifecycle{WaitForContainers: true, MaxShutdownDelaySeconds: 2})
+
+				runPreStop := func(t *testing.T, port int) time.Duration {
+					t.Helper()
+
+					p := getEmptyPod()
+					p.Spec.Containers[0].Ports = []corev1.ContainerPort{{ContainerPort: int32(port)}}
+
+					if err := i.Mutate(ctx, p, req); err != nil {
+						t.Fatalf("mutating Pod: %v", err)
+					}
+
+					command := infraContainer(t, p).Lifecycle.PreStop.Exec.Command
+					start := time.Now()
+
+					//nolint:gosec // Command is generated by the injector.
+					if output, err := exec.CommandContext(ctx, command[0], command[1:]...).CombinedOutput(); err != nil {
+						t.Fatalf("running preStop hook: %v\n%s", err, output)
+					}
+
+					return time.Since(start)
+				}
+
+				listener, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatalf("listening: %v", err)
+				}
+
+				port := listener.Addr().(*net.TCPAddr).Port
+
+				if elapsed := runPreStop(t, port); elapsed < time.Second {
+					t.Fatalf("expected hook to wait while port is listening, it returned after %v", elapsed)
+				}
+
+				if err := listener.Close(); err != nil {
+					t.Fatalf("closing listener: %v", err)
+				}
+
+				if elapsed := runPreStop(t, port); elapsed >= time.Second {
+					t.Fatalf("expected hook to return when port is closed, it returned after %v", elapsed)
+				}
+			})
+
+			t.Run("injects_native_sidecar_as_init_container_extending_only_grace_period", func(t *testing.T) {
+				t.Parallel()
+
+				i := injectorWith(t, &agent.Lifecycle{
+					NativeSidecar:                 true,
+					WaitForContainers:             true,
+					TerminationGracePeriodSeconds: ptr.To[int64](60),
+				})
+
+				p := getEmptyPod()
+				p.Spec.Containers[0].Ports = []corev1.ContainerPort{{ContainerPort: 8080}}
+				p.Spec.InitContainers = []corev1.Container{{Name: "init", Image: "init"}}
+
+				// Invoke webhook twice to verify that native sidecar is not injected again.
+				for range 2 {
+					if err := i.Mutate(ctx, p, req); err != nil {
+						t.Fatalf("mutating Pod: %v", err)
+					}
+				}
+
+				if len(p.Spec.Containers) != 1 {
+					t.Fatalf("expected no regular containers to be added, got %d containers", len(p.Spec.Containers))
+				}
+
+				if len(p.Spec.InitContainers) != 2 || p.Spec.InitContainers[1].Name != agent.AgentSidecarName {
+					t.Fatalf("expected sidecar to be added after existing init containers, got: %v", p.Spec.InitContainers)
+				}
+
+				sidecar := p.Spec.InitContainers[1]
+
+				if policy := sidecar.RestartPolicy; policy == nil || *policy != corev1.ContainerRestartPolicyAlways {
+					t.Fatalf("expected sidecar to have Always restart policy, got: %v", policy)
+				}
+
+				if sidecar.Lifecycle != nil {
+					t.Fatalf("expected native sidecar to have no preStop hook, got: %v", sidecar.Lifecycle)
+				}
+
+				if got := p.Spec.TerminationGracePeriodSeconds; got == nil || *got != 60 {
+					t.Fatalf("expected termination grace period to be extended to 60, got %v", got)
+				}
+			})
+
+			t.Run("extends_termination_grace_period_shorter_than_configured", func(t *testing.T) {
+				t.Parallel()
+
+				i := injectorWith(t, &agent.Lifecycle{TerminationGracePeriodSeconds: ptr.To[int64](60)})
+
+				for name, testData := range map[string]struct {
+					gracePeriod *int64
+					expected    int64
+				}{
+					"when_not_set": {expected: 60},
+					"when_shorter": {gracePeriod: ptr.To[int64](45), expected: 60},
+					"when_longer":  {gracePeriod: ptr.To[int64](120), expected: 120},
+				} {
+					testData := testData
+
+					t.Run(name, func(t *testing.T) {
+						t.Parallel()
+
+						p := getEmptyPod()
+						p.Spec.TerminationGracePeriodSeconds = testData.gracePeriod
+
+						if err := i.Mutate(ctx, p, req); err != nil {
+							t.Fatalf("mutating Pod: %v", err)
+						}
+
+						if got := p.Spec.TerminationGracePeriodSeconds; got == nil || *got != testData.expected {
+							t.Fatalf("expected termination grace period %d, got %v", testData.expected, got)
+						}
+					})
+				}
+			})
+		})
+
+		t.Run("and_when_probes_are_configured", func(t *testing.T) {
+			t.Parallel()
+
+			healthCheck := corev1.ProbeHandler{
+				HTTPGet: &corev1.HTTPGetAction{
+					Path:   agent.HealthEndpointPath,
+					Port:   intstr.FromInt32(9000),
+					Scheme: corev1.URISchemeHTTP,
+				},
+			}
+
+			config := getConfig()
+			config.AgentConfig.Probes = &agent.Probes{
+				Enabled:          true,
+				StatusServerPort: 9000,
+				Liveness:         &corev1.Probe{PeriodSeconds: 60},
+				Readiness: &corev1.Probe{
+					ProbeHandler: corev1.ProbeHandler{TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt32(9000)}},
+				},
+			}
+			config.AgentConfig.ConfigSelectors = []agent.ConfigSelector{
+				{
+					LabelSelector: metav1.LabelSelector{MatchLabels: map[string]string{"probes": "disabled"}},
+					Probes:        &agent.Probes{},
+				},
+				{
+					LabelSelector: metav1.LabelSelector{MatchLabels: map[string]string{"probes": "default"}},
+					Probes:        &agent.Probes{Enabled: true},
+				},
+			}
+
+			c := fake.NewClientBuilder().WithObjects(getCRB(testResourcePrefix)).Build()
+
+			i, err := config.New(c, c, testr.New(t))
+			if err != nil {
+				t.Fatalf("creating injector: %v", err)
+			}
+
+			p := getEmptyPod()
+			if err := i.Mutate(ctx, p, req); err != nil {
+				t.Fatalf("mutating Pod: %v", err)
+			}
+
+			sidecar := infraContainer(t, p)
+
+			t.Run("sets_liveness_probe_with_default_handler", func(t *testing.T) {
+				t.Parallel()
+
+				expected := &corev1.Probe{
+					ProbeHandler:     healthCheck,
+					PeriodSeconds:    60,
+					TimeoutSeconds:   5,
+					SuccessThreshold: 1,
+					FailureThreshold: 3,
+				}
+
+				if diff := cmp.Diff(expected, sidecar.LivenessProbe); diff != "" {
+					t.Fatalf("unexpected liveness probe: %s", diff)
+				}
+			})
+
+			t.Run("sets_readiness_probe_with_configured_handler", func(t *testing.T) {
+				t.Parallel()
+
+				if sidecar.ReadinessProbe == nil || sidecar.ReadinessProbe.TCPSocket == nil {
+					t.Fatalf("expected readiness probe with TCP handler, got: %v", sidecar.ReadinessProbe)
+				}
+			})
+
+			t.Run("sets_default_startup_probe", func(t *testing.T) {
+				t.Parallel()
+
+				if sidecar.StartupProbe == nil || sidecar.StartupProbe.HTTPGet == nil {
+					t.Fatalf("expected default startup probe, got: %v", sidecar.StartupProbe)
+				}
+			})
+
+			t.Run("enables_agent_status_server", func(t *testing.T) {
+				t.Parallel()
+
+				env := map[string]string{}
+				for _, e := range sidecar.Env {
+					env[e.Name] = e.Value
+				}
+
+				if env["NRIA_STATUS_SERVER_ENABLED"] != "true" || env["NRIA_STATUS_SERVER_PORT"] != "9000" {
+					t.Fatalf("expected status server to be enabled on port 9000, got env: %v", sidecar.Env)
+				}
+			})
+
+			t.Run("does_not_set_readiness_probe_when_it_is_not_configured", func(t *testing.T) {
+				t.Parallel()
+
+				p := getEmptyPod()
+				p.Labels["probes"] = "default"
+
+				if err := i.Mutate(ctx, p, req); err != nil {
+					t.Fatalf("mutating Pod: %v", err)
+				}
+
+				sidecar := infraContainer(t, p)
+
+				if sidecar.ReadinessProbe != nil {
+					t.Fatalf("expected no readiness probe, got: %v", sidecar.ReadinessProbe)
+				}
+
+				if sidecar.LivenessProbe == nil || sidecar.StartupProbe == nil {
+					t.Fatalf("expected default liveness and startup probes, got: %v", sidecar)
+				}
+			})
+
+			t.Run("removes_probes_for_Pods_matching_config_selector_disabling_them", func(t *testing.T) {
+				t.Parallel()
+
+				p := getEmptyPod()
+				p.Labels["probes"] = "disabled"
+
+				if err := i.Mutate(ctx, p, req); err != nil {
+					t.Fatalf("mutating Pod: %v", err)
+				}
+
+				sidecar := infraContainer(t, p)
+
+				if sidecar.LivenessProbe != nil || sidecar.ReadinessProbe != nil || sidecar.StartupProbe != nil {
+					t.Fatalf("expected no probes, got: %v", sidecar)
+				}
+
+				for _, e := range sidecar.Env {
+					if e.Name == "NRIA_STATUS_SERVER_ENABLED" {
+						t.Fatalf("expected status server not to be enabled")
+					}
+				}
+			})
+
+			t.Run("includes_probes_in_configuration_hash", func(t *testing.T) {
+				t.Parallel()
+
+				c := fake.NewClientBuilder().WithObjects(getCRB(testResourcePrefix)).Build()
+
+				defaultInjector, err := getConfig().New(c, c, testr.New(t))
+				if err != nil {
+					t.Fatalf("creating injector: %v", err)
+				}
+
+				defaultPod := getEmptyPod()
+				if err := defaultInjector.Mutate(ctx, defaultPod, req); err != nil {
+					t.Fatalf("mutating Pod: %v", err)
+				}
+
+				if p.Labels[agent.InjectedLabel] == defaultPod.Labels[agent.InjectedLabel] {
+					t.Fatalf("expected hash to change when probes are configured")
+				}
+			})
+		})
+
+		t.Run("and_when_Pod_matches_the_first_matching_config_rule_it_gets", func(t *testing.T) {
+			t.Parallel()
+
+			p := getEmptyPod()
+			p.Labels["matching-key"] = "matching-value"
+
+			c := fake.NewClientBuilder().WithObjects(getCRB(testResourcePrefix)).Build()
+			config := getConfig()
+			config.AgentConfig.ConfigSelectors = []agent.ConfigSelector{
+				{
+					ResourceRequirements: &corev1.ResourceRequirements{
+						Limits: corev1.ResourceList{
+							corev1.ResourceMemory: *resource.NewScaledQuantity(100, resource.Mega),
+						},
+					},
+					LabelSelector: metav1.LabelSelector{
+						MatchLabels: map[string]string{
+							"not-matching-key": "not-matching-value",
+						},
+					},
+				},
+				{
+					ExtraEnvVars: map[string]string{
+						"EXTRA_ENV": "EXTRA_VALUE",
+					},
+					ResourceRequirements: &corev1.ResourceRequirements{
+						Limits: corev1.ResourceList{
+							corev1.ResourceMemory: *resource.NewScaledQuantity(300, resource.Mega),
+						},
+					},
+					LabelSelector: metav1.LabelSelector{
+						MatchLabels: map[string]string{
+							"matching-key": "matching-value",
+						},
+					},
+				},
+			}
+
+			i, err := config.New(c, c, testr.New(t))
+			if err != nil {
+				t.Fatalf("creating injector: %v", err)
+			}
+
+			if err := i.Mutate(ctx, p, req); err != nil {
+				t.Fatalf("mutating Pod: %v", err)
+			}
+
+			infraContainer := infraContainer(t, p)
+
+			t.Run("env_var_configured_from_it", func(t *testing.T) {
+				t.Parallel()
+
+				found := false
+				for _, env := range infraContainer.Env {
+					if env.Name == "EXTRA_ENV" && env.Value == "EXTRA_VALUE" {
+						found = true
+					}
+				}
+				if !found {
+					t.Fatalf("missing expecting env var")
+				}
+			})
+
+			t.Run("resources_configured_from_it", func(t *testing.T) {
+				t.Parallel()
+
+				q := infraContainer.Resources.Limits[corev1.ResourceMemory]
+				if q != *resource.NewScaledQuantity(300, resource.Mega) {
+					t.Fatalf("not-default CPU limit was expected: %s.", q.String())
+				}
+			})
+		})
+
+		t.Run("and_when_Pod_does_not_match_any_config_rule_it_gets", func(t *testing.T) {
+			t.Parallel()
+
+			p := getEmptyPod()
+
+			c := fake.NewClientBuilder().WithObjects(getCRB(testResourcePrefix)).Build()
+			config := getConfig()
+			config.AgentConfig.ConfigSelectors = []agent.ConfigSelector{
+				{
+					ResourceRequirements: &corev1.ResourceRequirements{
+						Limits: corev1.ResourceList{
+							corev1.ResourceMemory: *resource.NewScaledQuantity(100, resource.Mega),
+						},
+					},
+					LabelSelector: metav1.LabelSelector{
+						MatchLabels: map[string]string{
+							"not-matching-key": "not-matching-value",
+						},
+					},
+				},
+			}
+
+			i, err := config.New(c, c, testr.New(t))
+			if err != nil {
+				t.Fatalf("creating injector: %v", err)
+			}
+
+			if err := i.Mutate(ctx, p, req); err != nil {
+				t.Fatalf("mutating Pod: %v", err)
+			}
+
+			infraContainer := infraContainer(t, p)
+
+			t.Run("sidecar_resources_empty", func(t *testing.T) {
+				t.Parallel()
+
+				if diff := cmp.Diff(infraContainer.Resources, corev1.ResourceRequirements{}); diff != "" {
+					t.Fatalf("unexpected resources diff:\n%s", diff)
+				}
+			})
+		})
+	})
+
+	t.Run("records_rule_deciding_about_injection_as_Pod_annotation_when", func(t *testing.T) {
+		t.Parallel()
+
+		cases := map[string]struct {
+			configMutateF func(*agent.InjectorConfig)
+			expectedRule  string
+		}{
+			"named_policy_matches": {
+				configMutateF: func(config *agent.InjectorConfig) {
+					config.Policies = []agent.InjectionPolicy{
+						{
+							Name:   "exclude-all",
+							Action: agent.PolicyActionExclude,
+						},
+					}
+				},
+				expectedRule: "exclude-all",
+			},
+			"unnamed_policy_matches": {
+				configMutateF: func(config *agent.InjectorConfig) {
+					config.Policies = []agent.InjectionPolicy{
+						{
+							NamespaceName: "foo",
+						},
+						{
+							Priority: -1,
+						},
+						{},
+					}
+				},
+				expectedRule: "policies[2]",
+			},
+			"namespace_is_excluded": {
+				configMutateF: func(config *agent.InjectorConfig) {
+					config.ExcludeNamespaces = []string{testNamespace}
+				},
+				expectedRule: agent.ExcludeNamespacesRule,
+			},
+		}
+
+		for testCaseName, testData := range cases {
+			testData := testData
+
+			t.Run(testCaseName, func(t *testing.T) {
+				t.Parallel()
+
+				config := getConfig()
+				testData.configMutateF(config)
+
+				c := fake.NewClientBuilder().WithObjects(getCRB(testResourcePrefix)).Build()
+
+				i, err := config.New(c, c, testr.New(t))
+				if err != nil {
+					t.Fatalf("creating injector: %v", err)
+				}
+
+				p := getEmptyPod()
+
+				if err := i.Mutate(testutil.ContextWithDeadline(t), p, req); err != nil {
+					t.Fatalf("mutating Pod: %v", err)
+				}
+
+				if rule := p.Annotations[agent.PolicyAnnotation]; rule != testData.expectedRule {
+					t.Fatalf("expected rule %q, got %q", testData.expectedRule, rule)
+				}
+			})
+		}
+	})
+
+	t.Run("when_Pod_matches_policy_with_profile_it_gets", func(t *testing.T) {
+		t.Parallel()
+
+		p := getEmptyPod()
+		p.Labels["matching-key"] = "matching-value"
+
+		c := fake.NewClientBuilder().WithObjects(getCRB(testResourcePrefix)).Build()
+		config := getConfig()
+		config.AgentConfig.ConfigSelectors = []agent.ConfigSelector{
+			{
+				ExtraEnvVars: map[string]string{
+					"SELECTOR_ENV": "SELECTOR_VALUE",
+				},
+			},
+		}
+		config.Profiles = []agent.AgentProfile{
+			{
+				Name: "batch",
+				Image: &agent.Image{
+					Repository: "batch-repository",
+					Tag:        "batch-tag",
+				},
+				ResourceRequirements: &corev1.ResourceRequirements{
+					Limits: corev1.ResourceList{
+						corev1.ResourceMemory: *resource.NewScaledQuantity(200, resource.Mega),
+					},
+				},
+				ExtraEnvVars: map[string]string{
+					"PROFILE_ENV": "PROFILE_VALUE",
+				},
+				CustomAttributes: []agent.CustomAttribute{
+					{
+						Name:         "computeType",
+						DefaultValue: "batch",
+					},
+				},
+				PodSecurityContext: &agent.PodSecurityContext{
+					RunAsUser: 2000,
+				},
+			},
+		}
+		config.Policies = []agent.InjectionPolicy{
+			{
+				Profile: "batch",
+				PodSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{
+						"matching-key": "matching-value",
+					},
+				},
+			},
+			{},
+		}
+
+		i, err := config.New(c, c, testr.New(t))
+		if err != nil {
+			t.Fatalf("creating injector: %v", err)
+		}
+
+		if err := i.Mutate(ctx, p, req); err != nil {
+			t.Fatalf("mutating Pod: %v", err)
+		}
+
+		infraContainer := infraContainer(t, p)
+
+		envValue := func(name string) string {
+			for _, env := range infraContainer.Env {
+				if env.Name == name {
+					return env.Value
+				}
+			}
+
+			return ""
+		}
+
+		t.Run("image_configured_from_it", func(t *testing.T) {
+			t.Parallel()
+
+			if expectedImage := "batch-repository:batch-tag"; infraContainer.Image != expectedImage {
+				t.Fatalf("expected image %q, got %q", expectedImage, infraContainer.Image)
+			}
+		})
+
+		t.Run("resources_configured_from_it", func(t *testing.T) {
+			t.Parallel()
+
+			q := infraContainer.Resources.Limits[corev1.ResourceMemory]
+			if q != *resource.NewScaledQuantity(200, resource.Mega) {
+				t.Fatalf("unexpected memory limit: %s", q.String())
+			}
+		})
+
+		t.Run("env_var_configured_from_it", func(t *testing.T) {
+			t.Parallel()
+
+			if v := envValue("PROFILE_ENV"); v != "PROFILE_VALUE" {
+				t.Fatalf("expected profile env var, got %v", infraContainer.Env)
+			}
+		})
+
+		t.Run("custom_attributes_configured_from_it", func(t *testing.T) {
+			t.Parallel()
+
+			v := envValue("NRIA_CUSTOM_ATTRIBUTES")
+			if !strings.Contains(v, `"computeType":"batch"`) || !strings.Contains(v, testClusterName) {
+				t.Fatalf("unexpected custom attributes %q", v)
+			}
+		})
+
+		t.Run("security_context_configured_from_it", func(t *testing.T) {
+			t.Parallel()
+
+			if u := infraContainer.SecurityContext.RunAsUser; u == nil || *u != 2000 {
+				t.Fatalf("unexpected RunAsUser: %v", u)
+			}
+		})
+
+		t.Run("no_config_selectors_applied", func(t *testing.T) {
+			t.Parallel()
+
+			if v := envValue("SELECTOR_ENV"); v != "" {
+				t.Fatalf("expected config selector to not be applied, got %v", infraContainer.Env)
+			}
+		})
+
+		t.Run("different_hash_than_Pod_matching_policy_without_profile", func(t *testing.T) {
+			t.Parallel()
+
+			p2 := getEmptyPod()
+
+			if err := i.Mutate(ctx, p2, req); err != nil {
+				t.Fatalf("mutating Pod: %v", err)
+			}
+
+			if p.Labels[agent.InjectedLabel] == p2.Labels[agent.InjectedLabel] {
+				t.Fatalf("expected different hashes for Pods injected with and without profile")
+			}
+		})
+	})
+
+	t.Run("when_Pod_matches_config_selector_with_integrations", func(t *testing.T) {
+		t.Parallel()
+
+		p := getEmptyPod()
+		p.Labels["app"] = "redis"
+		p.Spec.Containers[0].Ports = []corev1.ContainerPort{
+			{
+				Name:          "redis",
+				ContainerPort: 6379,
+			},
+		}
+
+		c := fake.NewClientBuilder().WithObjects(getCRB(testResourcePrefix)).Build()
+		config := getConfig()
+		config.AgentConfig.Integrations = []agent.Integration{
+			{
+				Name:   "nri-redis",
+				Config: testIntegrationConfig,
+			},
+		}
+		config.AgentConfig.ConfigSelectors = []agent.ConfigSelector{
+			{
+				LabelSelector: metav1.LabelSelector{
+					MatchLabels: map[string]string{
+						"app": "redis",
+					},
+				},
+				Integrations: []string{"nri-redis"},
+			},
+		}
+
+		i, err := config.New(c, c, testr.New(t))
+		if err != nil {
+			t.Fatalf("creating injector: %v", err)
+		}
+
+		if err := i.Mutate(ctx, p, req); err != nil {
+			t.Fatalf("mutating Pod: %v", err)
+		}
+
+		infraContainer := infraContainer(t, p)
+
+		var integrationsVolume *corev1.Volume
+
+		for _, v := range p.Spec.Volumes {
+			if v.ConfigMap != nil {
+				v := v
+				integrationsVolume = &v
+			}
+		}
+
+		if integrationsVolume == nil {
+			t.Fatalf("expected ConfigMap volume to be added, got: %v", p.Spec.Volumes)
+		}
+
+		t.Run("creates_ConfigMap_with_rendered_integration_config", func(t *testing.T) {
+			t.Parallel()
+
+			cm := &corev1.ConfigMap{}
+			key := client.ObjectKey{
+				Namespace: testNamespace,
+				Name:      integrationsVolume.ConfigMap.Name,
+			}
+
+			if err := c.Get(ctx, key, cm); err != nil {
+				t.Fatalf("getting ConfigMap: %v", err)
+			}
+
+			expectedConfig := `integrations:
+- name: nri-redis
+  env:
+    HOSTNAME: {{NRI_POD_IP}}
+    PORT: 6379
+    LABELS: '{"app":"redis"}'
+    NAMESPACE: test-namespace
+`
+
+			if diff := cmp.Diff(expectedConfig, cm.Data["nri-redis.yaml"]); diff != "" {
+				t.Fatalf("unexpected integration config: %v", diff)
+			}
+
+			if !strings.HasPrefix(cm.Name, testResourcePrefix+agent.IntegrationsConfigMapSuffix) {
+				t.Fatalf("unexpected ConfigMap name %q", cm.Name)
+			}
+		})
+
+		t.Run("mounts_integration_config_into_integrations_directory", func(t *testing.T) {
+			t.Parallel()
+
+			for _, vm := range infraContainer.VolumeMounts {
+				if vm.Name == integrationsVolume.Name && vm.MountPath == agent.IntegrationsDir+"/nri-redis.yaml" {
+					return
+				}
+			}
+
+			t.Fatalf("integration config mount not found in %v", infraContainer.VolumeMounts)
+		})
+
+		t.Run("exposes_Pod_IP_to_the_sidecar", func(t *testing.T) {
+			t.Parallel()
+
+			for _, env := range infraContainer.Env {
+				if env.ValueFrom != nil && env.ValueFrom.FieldRef != nil && env.ValueFrom.FieldRef.FieldPath == "status.podIP" {
+					return
+				}
+			}
+
+			t.Fatalf("Pod IP environment variable not found in %v", infraContainer.Env)
+		})
+
+		t.Run("shares_ConfigMap_between_Pods_with_the_same_integration_config", func(t *testing.T) {
+			t.Parallel()
+
+			p2 := p.DeepCopy()
+			p2.Labels = map[string]string{"app": "redis"}
+			p2.Spec.Containers = p2.Spec.Containers[:1]
+			p2.Spec.Volumes = nil
+
+			if err := i.Mutate(ctx, p2, req); err != nil {
+				t.Fatalf("mutating Pod: %v", err)
+			}
+
+			if diff := cmp.Diff(p.Spec.Volumes, p2.Spec.Volumes); diff != "" {
+				t.Fatalf("expected the same volumes for both Pods: %v", diff)
+			}
+		})
+	})
+
+	t.Run("when_integration_config_renders_all_Pod_labels", func(t *testing.T) {
+		t.Parallel()
+
+		c := fake.NewClientBuilder().WithObjects(getCRB(testResourcePrefix)).Build()
+		config := getConfig()
+		config.AgentConfig.Integrations = []agent.Integration{
+			{
+				Name:   "nri-labels",
+				Config: "labels: '{{ range $k, $v := .Labels }}{{ $k }}={{ $v }},{{ end }}'",
+			},
+		}
+		config.AgentConfig.ConfigSelectors = []agent.ConfigSelector{
+			{
+				Integrations: []string{"nri-labels"},
+			},
+		}
+
+		i, err := config.New(c, c, testr.New(t))
+		if err != nil {
+			t.Fatalf("creating injector: %v", err)
+		}
+
+		revisionVolumes := func(t *testing.T, revision string) []corev1.Volume {
+			t.Helper()
+
+			p := getEmptyPod()
+			p.Labels = map[string]string{
+				"app":                          "redis",
+				"pod-template-hash":            revision,
+				"controller-revision-hash":     revision,
+				"apps.kubernetes.io/pod-index": revision,
+			}
+
+			if err := i.Mutate(ctx, p, req); err != nil {
+				t.Fatalf("mutating Pod: %v", err)
+			}
+
+			return p.Spec.Volumes
+		}
+
+		t.Run("shares_ConfigMap_between_revisions_of_the_same_workload", func(t *testing.T) {
+			t.Parallel()
+
+			if diff := cmp.Diff(revisionVolumes(t, "1"), revisionVolumes(t, "2")); diff != "" {
+				t.Fatalf("expected the same volumes for both revisions: %v", diff)
+			}
+		})
+
+		t.Run("ignores_labels_set_by_operator", func(t *testing.T) {
+			t.Parallel()
+
+			p := getEmptyPod()
+			p.Labels = map[string]string{
+				"app":                      "redis",
+				agent.InjectedLabel:        "outdated-config-hash",
+				agent.OperatorCreatedLabel: agent.OperatorCreatedLabelValue,
+			}
+
+			if err := i.Mutate(ctx, p, req); err != nil {
+				t.Fatalf("mutating Pod: %v", err)
+			}
+
+			if diff := cmp.Diff(revisionVolumes(t, "3"), p.Spec.Volumes); diff != "" {
+				t.Fatalf("expected the same volumes for Pod with operator labels: %v", diff)
+			}
+		})
+	})
+
+	t.Run("when_succeeds_with_dry_run_option", func(t *testing.T) {
+		t.Parallel()
+
+		req := webhook.RequestOptions{
+			Namespace: testNamespace,
+			DryRun:    true,
+		}
+
+		p := getEmptyPod()
+		c := fake.NewClientBuilder().WithObjects(getCRB(testResourcePrefix)).Build()
+		config := getConfig()
+
+		i, err := config.New(c, c, testr.New(t))
+		if err != nil {
+			t.Fatalf("creating injector: %v", err)
+		}
+
+		if err := i.Mutate(ctx, p, req); err != nil {
+			t.Fatalf("mutating Pod: %v", err)
+		}
+
+		t.Run("adds_infrastructure_agent_sidecar_container_to_given_pod", func(t *testing.T) {
+			t.Parallel()
+
+			if len(p.Spec.Containers) != 2 {
+				t.Fatalf("expected extra container to be added, got %v", cmp.Diff(getEmptyPod(), p))
+			}
+		})
+
+		t.Run("does_not_create_integrations_ConfigMap", func(t *testing.T) {
+			t.Parallel()
+
+			p := getEmptyPod()
+			c := fake.NewClientBuilder().WithObjects(getCRB(testResourcePrefix)).Build()
+			config := getConfig()
+			config.AgentConfig.Integrations = []agent.Integration{
+				{
+					Name:   "nri-redis",
+					Config: "integrations: []",
+				},
+			}
+			config.AgentConfig.ConfigSelectors = []agent.ConfigSelector{
+				{
+					Integrations: []string{"nri-redis"},
+				},
+			}
+
+			i, err := config.New(c, c, testr.New(t))
+			if err != nil {
+				t.Fatalf("creating injector: %v", err)
+			}
+
+			if err := i.Mutate(ctx, p, req); err != nil {
+				t.Fatalf("mutating Pod: %v", err)
+			}
+
+			cms := &corev1.ConfigMapList{}
+			if err := c.List(ctx, cms); err != nil {
+				t.Fatalf("listing ConfigMaps: %v", err)
+			}
+
+			if len(cms.Items) != 0 {
+				t.Fatalf("expected no ConfigMaps to be created, got: %v", cms.Items)
+			}
+		})
+
+		t.Run("does_not_create_license_Secret_for_Pod", func(t *testing.T) {
+			t.Parallel()
+
+			secret := &corev1.Secret{}
+			if err := c.Get(ctx, secretKey, secret); err == nil || !errors.IsNotFound(err) {
+				t.Fatalf("secret found in the cluster or err different from expected one: %v", err)
+			}
+		})
+
+		t.Run("does_not_add_pods_ServiceAccount_to_infrastructure_agent_ClusterRoleBinding", func(t *testing.T) {
+			t.Parallel()
+
+			key := client.ObjectKey{
+				Name: clusterRoleBindingName(testResourcePrefix),
+			}
+
+			crb := &rbacv1.ClusterRoleBinding{}
+
+			if err := c.Get(ctx, key, crb); err != nil {
+				t.Fatalf("getting ClusterRoleBinding: %v", err)
+			}
+
+			found := false
+
+			for _, subject := range crb.Subjects {
+				if subject.Name == "default" && subject.Namespace == testNamespace {
+					found = true
+
+					break
+				}
+			}
+
+			if found {
+				t.Fatalf("found unexpected subject in ClusterRoleBinding, got: %v", crb)
+			}
+		})
+	})
+
+	t.Run("handles_pods_without_labels", func(t *testing.T) {
+		t.Parallel()
+
+		p := getEmptyPod()
+		p.Labels = nil
+
+		c := fake.NewClientBuilder().WithObjects(getCRB(testResourcePrefix)).Build()
+		config := getConfig()
+
+		i, err := config.New(c, c, testr.New(t))
+		if err != nil {
+			t.Fatalf("creating injector: %v", err)
+		}
+
+		if err := i.Mutate(ctx, p, req); err != nil {
+			t.Fatalf("mutating Pod: %v", err)
+		}
+	})
+
+	t.Run("is_idempotent", func(t *testing.T) {
+		t.Parallel()
+
+		p := getEmptyPod()
+		c := fake.NewClientBuilder().WithObjects(getCRB(testResourcePrefix)).Build()
+		config := getConfig()
+
+		i, err := config.New(c, c, testr.New(t))
+		if err != nil {
+			t.Fatalf("creating injector: %v", err)
+		}
+
+		if err := i.Mutate(ctx, p, req); err != nil {
+			t.Fatalf("mutating Pod: %v", err)
+		}
+
+		mutatedPod := p.DeepCopy()
+
+		if err := i.Mutate(ctx, p, req); err != nil {
+			t.Fatalf("mutating Pod: %v", err)
+		}
+
+		if diff := cmp.Diff(mutatedPod, p); diff != "" {
+			t.Fatalf("unexpected Pod diff\n: %v", diff)
+		}
+	})
+
+	t.Run("when_invoked_again_for_injected_Pod", func(t *testing.T) {
+		t.Parallel()
+
+		cases := map[string]struct {
+			podMutateF func(*corev1.Pod)
+			configF    func(*agent.InjectorConfig)
+		}{
+			"does_not_change_unmodified_Pod": {},
+			"does_not_duplicate_sidecar_when_injected_label_is_removed": {
+				podMutateF: func(p *corev1.Pod) {
+					delete(p.Labels, agent.InjectedLabel)
+				},
+			},
+			"restores_modified_sidecar": {
+				podMutateF: func(p *corev1.Pod) {
+					for i := range p.Spec.Containers {
+						if p.Spec.Containers[i].Name == agent.AgentSidecarName {
+							p.Spec.Containers[i].Image = "foo:bar"
+							p.Spec.Containers[i].Env = nil
+						}
+					}
+				},
+			},
+			"restores_removed_volumes": {
+				podMutateF: func(p *corev1.Pod) {
+					p.Spec.Volumes = nil
+				},
+			},
+			"restores_modified_volume": {
+				podMutateF: func(p *corev1.Pod) {
+					p.Spec.Volumes[0].VolumeSource = corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/"}}
+				},
+			},
+			"restores_modified_injected_label": {
+				podMutateF: func(p *corev1.Pod) {
+					p.Labels[agent.InjectedLabel] = "foo"
+				},
+			},
+			"injects_sidecar_when_only_injected_label_is_present": {
+				podMutateF: func(p *corev1.Pod) {
+					p.Spec.Containers = p.Spec.Containers[:1]
+					p.Spec.Volumes = nil
+				},
+			},
+			"updates_sidecar_when_configuration_changes": {
+				configF: func(config *agent.InjectorConfig) {
+					config.AgentConfig.Image.Tag = "changed"
+				},
+			},
+		}
+
+		for testCaseName, testData := range cases {
+			testData := testData
+
+			t.Run(testCaseName, func(t *testing.T) {
+				t.Parallel()
+
+				p := getEmptyPod()
+				c := fake.NewClientBuilder().WithObjects(getCRB(testResourcePrefix)).Build()
+
+				i, err := getConfig().New(c, c, testr.New(t))
+				if err != nil {
+					t.Fatalf("creating injector: %v", err)
+				}
+
+				if err := i.Mutate(ctx, p, req); err != nil {
+					t.Fatalf("mutating Pod: %v", err)
+				}
+
+				config := getConfig()
+
+				if testData.configF != nil {
+					testData.configF(config)
+
+					i, err = config.New(c, c, testr.New(t))
+					if err != nil {
+						t.Fatalf("creating injector: %v", err)
+					}
+				}
+
+				expectedPod := getEmptyPod()
+
+				if err := i.Mutate(ctx, expectedPod, req); err != nil {
+					t.Fatalf("mutating Pod: %v", err)
+				}
+
+				if testData.podMutateF != nil {
+					testData.podMutateF(p)
+				}
+
+				if err := i.Mutate(ctx, p, req); err != nil {
+					t.Fatalf("mutating Pod again: %v", err)
+				}
+
+				if diff := cmp.Diff(expectedPod, p); diff != "" {
+					t.Fatalf("unexpected Pod diff\n: %v", diff)
+				}
+			})
+		}
+	})
+
+	t.Run("does_not_emit_patch_when_invoked_again_for_unmodified_Pod", func(t *testing.T) {
+		t.Parallel()
+
+		p := getEmptyPod()
+		c := fake.NewClientBuilder().WithObjects(getCRB(testResourcePrefix)).Build()
+
+		i, err := getConfig().New(c, c, testr.New(t))
+		if err != nil {
+			t.Fatalf("creating injector: %v", err)
+		}
+
+		if err := i.Mutate(ctx, p, req); err != nil {
+			t.Fatalf("mutating Pod: %v", err)
+		}
+
+		// Simulate Pod round trip through the API server.
+		podBytes, err := json.Marshal(p)
+		if err != nil {
+			t.Fatalf("marshaling Pod: %v", err)
+		}
+
+		p = &corev1.Pod{}
+		if err := json.Unmarshal(podBytes, p); err != nil {
+			t.Fatalf("unmarshaling Pod: %v", err)
+		}
+
+		req := req
+		req.Patch = &webhook.Patch{}
+
+		if err := i.Mutate(ctx, p, req); err != nil {
+			t.Fatalf("mutating Pod again: %v", err)
+		}
+
+		if len(req.Patch.Operations) != 0 {
+			t.Fatalf("expected no patch operations, got: %v", req.Patch.Operations)
+		}
+	})
+
+	t.Run("fails_when_Pod_has_conflicting_volume_and_no_sidecar", func(t *testing.T) {
+		t.Parallel()
+
+		p := getEmptyPod()
+		c := fake.NewClientBuilder().WithObjects(getCRB(testResourcePrefix)).Build()
+
+		i, err := getConfig().New(c, c, testr.New(t))
+		if err != nil {
+			t.Fatalf("creating injector: %v", err)
+		}
+
+		injected := p.DeepCopy()
+		if err := i.Mutate(ctx, injected, req); err != nil {
+			t.Fatalf("mutating Pod: %v", err)
+		}
+
+		p.Spec.Volumes = []corev1.Volume{injected.Spec.Volumes[0]}
+
+		if err := i.Mutate(ctx, p, req); err == nil {
+			t.Fatalf("expected error when Pod has volume conflicting with injected ones")
+		}
+	})
+
+	t.Run("with_configured_volumes", func(t *testing.T) {
+		t.Parallel()
+
+		sizeLimit := resource.MustParse("64Mi")
+
+		withVolumes := func(config *agent.InjectorConfig) {
+			config.AgentConfig.Volumes = agent.SidecarVolumes{
+				EmptyDirs: map[string]corev1.EmptyDirVolumeSource{
+					"tmpfs-cache-injected": {Medium: corev1.StorageMediumMemory, SizeLimit: &sizeLimit},
+				},
+				ExtraVolumes: []corev1.Volume{
+					{
+						Name: "extra",
+						VolumeSource: corev1.VolumeSource{
+							Projected: &corev1.ProjectedVolumeSource{
+								Sources: []corev1.VolumeProjection{
+									{DownwardAPI: &corev1.DownwardAPIProjection{}},
+								},
+							},
+						},
+					},
+				},
+				ExtraVolumeMounts: []corev1.VolumeMount{
+					{Name: "extra", MountPath: "/etc/extra", ReadOnly: true},
+				},
+			}
+		}
+
+		mutate := func(t *testing.T, configF func(*agent.InjectorConfig), p *corev1.Pod) {
+			t.Helper()
+
+			c := fake.NewClientBuilder().WithObjects(getCRB(testResourcePrefix)).Build()
+			config := getConfig()
+
+			if configF != nil {
+				configF(config)
+			}
+
+			i, err := config.New(c, c, testr.New(t))
+			if err != nil {
+				t.Fatalf("creating injector: %v", err)
+			}
+
+			if err := i.Mutate(ctx, p, req); err != nil {
+				t.Fatalf("mutating Pod: %v", err)
+			}
+		}
+
+		volume := func(t *testing.T, p *corev1.Pod, name string) corev1.Volume {
+			t.Helper()
+
+			for _, v := range p.Spec.Volumes {
+				if v.Name == name {
+					return v
+				}
+			}
+
+			t.Fatalf("volume %q not found, got: %v", name, p.Spec.Volumes)
+
+			return corev1.Volume{}
+		}
+
+		t.Run("injects_emptyDir_volumes_with_configured_medium_and_size_limit", func(t *testing.T) {
+			t.Parallel()
+
+			p := getEmptyPod()
+			mutate(t, withVolumes, p)
+
+			expected := &corev1.EmptyDirVolumeSource{Medium: corev1.StorageMediumMemory, SizeLimit: &sizeLimit}
+			if diff := cmp.Diff(expected, volume(t, p, "tmpfs-cache-injected").EmptyDir); diff != "" {
+				t.Fatalf("unexpected configured emptyDir: %s", diff)
+			}
+
+			if diff := cmp.Diff(&corev1.EmptyDirVolumeSource{}, volume(t, p, "tmpfs-data-injected").EmptyDir); diff != "" {
+				t.Fatalf("unexpected default emptyDir: %s", diff)
+			}
+		})
+
+		t.Run("injects_extra_volumes_and_mounts", func(t *testing.T) {
+			t.Parallel()
+
+			p := getEmptyPod()
+			mutate(t, withVolumes, p)
+
+			if volume(t, p, "extra").Projected == nil {
+				t.Fatalf("expected extra volume to be projected volume")
+			}
+
+			found := false
+
+			for _, m := range infraContainer(t, p).VolumeMounts {
+				if m.Name == "extra" && m.MountPath == "/etc/extra" && m.ReadOnly {
+					found = true
+				}
+			}
+
+			if !found {
+				t.Fatalf("extra volume mount not found, got: %v", infraContainer(t, p).VolumeMounts)
+			}
+		})
+
+		t.Run("changes_configuration_hash", func(t *testing.T) {
+			t.Parallel()
+
+			p := getEmptyPod()
+			mutate(t, func(config *agent.InjectorConfig) {
+				config.AgentConfig.Volumes.EmptyDirs = map[string]corev1.EmptyDirVolumeSource{
+					"tmpfs-tmp-injected": {Medium: corev1.StorageMediumMemory},
+				}
+			}, p)
+
+			defaultPod := getEmptyPod()
+			mutate(t, nil, defaultPod)
+
+			if p.Labels[agent.InjectedLabel] == defaultPod.Labels[agent.InjectedLabel] {
+				t.Fatalf("expected hash to change when volumes are configured")
+			}
+		})
+
+		t.Run("renames_colliding_extra_volume_with_suffix_strategy", func(t *testing.T) {
+			t.Parallel()
+
+			p := getEmptyPod()
+			p.Spec.Volumes = []corev1.Volume{{Name: "extra"}}
+
+			mutate(t, func(config *agent.InjectorConfig) {
+				withVolumes(config)
+				config.CollisionStrategy = agent.CollisionStrategySuffix
+			}, p)
+
+			if volume(t, p, "extra-1").Projected == nil {
+				t.Fatalf("expected renamed extra volume to be projected volume")
+			}
+
+			for _, m := range infraContainer(t, p).VolumeMounts {
+				if m.MountPath == "/etc/extra" && m.Name != "extra-1" {
+					t.Fatalf("expected extra volume mount to reference renamed volume, got %q", m.Name)
+				}
+			}
+		})
+	})
+
+	t.Run("when_Namespace_enforces_Pod_Security_Standard", func(t *testing.T) {
+		t.Parallel()
+
+		namespace := func(level string) *corev1.Namespace {
+			ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: testNamespace}}
+
+			if level != "" {
+				ns.Labels = map[string]string{"pod-security.kubernetes.io/enforce": level}
+			}
+
+			return ns
+		}
+
+		withHostPathVolume := func(config *agent.InjectorConfig) {
+			config.AgentConfig.Volumes.ExtraVolumes = []corev1.Volume{
+				{Name: "host", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/"}}},
+			}
+		}
+
+		cases := map[string]struct {
+			mode        string
+			level       string
+			configF     func(*agent.InjectorConfig)
+			expectError bool
+			expectSkip  bool
+			// expectedSecurityContext, if set, is compared with security context of the injected sidecar.
+			expectedSecurityContext *corev1.SecurityContext
+		}{
+			"injects_compliant_sidecar": {
+				mode:  agent.PodSecurityModeFail,
+				level: "baseline",
+			},
+			"injects_sidecar_into_Namespace_without_labels": {
+				mode: agent.PodSecurityModeFail,
+			},
+			"fails_when_sidecar_violates_enforced_level_in_fail_mode": {
+				mode:        agent.PodSecurityModeFail,
+				level:       "restricted",
+				expectError: true,
+			},
+			"skips_injection_when_sidecar_violates_enforced_level_in_skip_mode": {
+				mode:       agent.PodSecurityModeSkip,
+				level:      "restricted",
+				expectSkip: true,
+			},
+			"evaluates_invalid_level_as_restricted": {
+				mode:       agent.PodSecurityModeSkip,
+				level:      "foo",
+				expectSkip: true,
+			},
+			"adapts_sidecar_security_context_in_adapt_mode": {
+				mode:  agent.PodSecurityModeAdapt,
+				level: "restricted",
+				configF: func(config *agent.InjectorConfig) {
+					config.AgentConfig.SecurityContext = &corev1.SecurityContext{
+						RunAsUser:    ptr.To[int64](0),
+						Capabilities: &corev1.Capabilities{Add: []corev1.Capability{"NET_ADMIN", "NET_BIND_SERVICE"}},
+					}
+				},
+				expectedSecurityContext: &corev1.SecurityContext{
+					ReadOnlyRootFilesystem:   ptr.To(true),
+					AllowPrivilegeEscalation: ptr.To(false),
+					RunAsNonRoot:             ptr.To(true),
+					Capabilities: &corev1.Capabilities{
+						Add:  []corev1.Capability{"NET_BIND_SERVICE"},
+						Drop: []corev1.Capability{"ALL"},
+					},
+					SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+				},
+			},
+			"fails_when_sidecar_cannot_be_adapted_in_adapt_mode": {
+				mode:        agent.PodSecurityModeAdapt,
+				level:       "baseline",
+				configF:     withHostPathVolume,
+				expectError: true,
+			},
+		}
+
+		for testCaseName, testData := range cases {
+			testData := testData
+
+			t.Run(testCaseName, func(t *testing.T) {
+				t.Parallel()
+
+				p := getEmptyPod()
+				p.OwnerReferences = []metav1.OwnerReference{
+					{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "foo", Controller: ptr.To(true)},
+				}
+
+				c := fake.NewClientBuilder().WithObjects(getCRB(testResourcePrefix), namespace(testData.level)).Build()
+				recorder := events.NewFakeRecorder(1)
+
+				config := getConfig()
+				config.PodSecurityMode = testData.mode
+				config.EventRecorder = recorder
+
+				if testData.configF != nil {
+					testData.configF(config)
+				}
+
+				i, err := config.New(c, c, testr.New(t))
+				if err != nil {
+					t.Fatalf("creating injector: %v", err)
+				}
+
+				req := req
+				req.Decision = &webhook.Decision{}
+
+				err = i.Mutate(ctx, p, req)
+
+				switch {
+				case testData.expectError && err == nil:
+					t.Fatalf("expected error")
+				case !testData.expectError && err != nil:
+					t.Fatalf("mutating Pod: %v", err)
+				case testData.expectError:
+					return
+				}
+
+				if testData.expectSkip {
+					if req.Decision.Inject || len(p.Spec.Containers) != 1 {
+						t.Fatalf("expected injection to be skipped, got decision: %+v", req.Decision)
+					}
+
+					select {
+					case event := <-recorder.Events:
+						if !strings.Contains(event, agent.PodSecurityViolationReason) {
+							t.Fatalf("unexpected event: %q", event)
+						}
+					default:
+						t.Fatalf("expected event to be recorded")
+					}
+
+					return
+				}
+
+				sidecar := infraContainer(t, p)
+
+				if testData.expectedSecurityContext == nil {
+					return
+				}
+
+				if diff := cmp.Diff(testData.expectedSecurityContext, sidecar.SecurityContext); diff != "" {
+					t.Fatalf("unexpected security context: %s", diff)
+				}
+			})
+		}
+	})
+
+	t.Run("when_sidecar_does_not_fit_into_ResourceQuota", func(t *testing.T) {
 		t.Parallel()
 
-		p := getEmptyPod()
-		c := fake.NewClientBuilder().WithObjects(getCRB(testResourcePrefix)).Build()
-		config := getConfig()
+		quota := func(used string) *corev1.ResourceQuota {
+			return &corev1.ResourceQuota{
+				ObjectMeta: metav1.ObjectMeta{Name: "quota", Namespace: testNamespace},
+				Spec: corev1.ResourceQuotaSpec{
+					Hard: corev1.ResourceList{"requests.memory": resource.MustParse("1Gi")},
+				},
+				Status: corev1.ResourceQuotaStatus{
+					Used: corev1.ResourceList{"requests.memory": resource.MustParse(used)},
+				},
+			}
+		}
 
-		i, err := config.New(c, c, testr.New(t))
-		if err != nil {
-			t.Fatalf("creating injector: %v", err)
+		memoryDefaults := &corev1.LimitRange{
+			ObjectMeta: metav1.ObjectMeta{Name: "defaults", Namespace: testNamespace},
+			Spec: corev1.LimitRangeSpec{
+				Limits: []corev1.LimitRangeItem{
+					{
+						Type:           corev1.LimitTypeContainer,
+						DefaultRequest: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Mi")},
+					},
+				},
+			},
 		}
 
-		if err := i.Mutate(ctx, p, req); err != nil {
-			t.Fatalf("mutating Pod: %v", err)
+		storageQuota := &corev1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{Name: "storage", Namespace: testNamespace},
+			Spec: corev1.ResourceQuotaSpec{
+				Hard: corev1.ResourceList{
+					corev1.ResourceRequestsStorage: resource.MustParse("10Gi"),
+					"requests.example.com/gpu":     resource.MustParse("1"),
+					"count/configmaps":             resource.MustParse("10"),
+				},
+			},
 		}
 
-		mutatedPod := p.DeepCopy()
+		cases := map[string]struct {
+			policy     string
+			used       string
+			expectSkip bool
+			// requests, if set, overrides requests of the sidecar.
+			requests corev1.ResourceList
+			objects  []client.Object
+			// expectedMemory is expected memory request of the injected sidecar. Empty value means no memory
+			// request is expected.
+			expectedMemory string
+		}{
+			"shrinks_sidecar_to_remaining_quota_with_shrink_policy": {
+				policy:         agent.ResourceQuotaPolicyShrink,
+				used:           "900Mi",
+				expectedMemory: "124Mi",
+			},
+			"skips_injection_when_quota_is_exhausted_with_shrink_policy": {
+				policy:     agent.ResourceQuotaPolicyShrink,
+				used:       "1Gi",
+				expectSkip: true,
+			},
+			"skips_injection_with_skip_policy": {
+				policy:     agent.ResourceQuotaPolicySkip,
+				used:       "900Mi",
+				expectSkip: true,
+			},
+			"injects_sidecar_with_configured_resources_with_proceed_policy": {
+				policy:         agent.ResourceQuotaPolicyProceed,
+				used:           "900Mi",
+				expectedMemory: "200Mi",
+			},
+			"injects_sidecar_with_configured_resources_when_it_fits": {
+				policy:         agent.ResourceQuotaPolicySkip,
+				used:           "100Mi",
+				expectedMemory: "200Mi",
+			},
+			"skips_injection_when_sidecar_does_not_request_resource_tracked_by_quota": {
+				policy:     agent.ResourceQuotaPolicyShrink,
+				used:       "100Mi",
+				requests:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+				expectSkip: true,
+			},
+			"injects_sidecar_without_request_of_resource_tracked_by_quota_when_LimitRange_defaults_it": {
+				policy:   agent.ResourceQuotaPolicySkip,
+				used:     "100Mi",
+				requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+				objects:  []client.Object{memoryDefaults},
+			},
+			"injects_sidecar_when_quota_tracks_storage_and_extended_resources_not_requested_by_sidecar": {
+				policy:         agent.ResourceQuotaPolicyShrink,
+				used:           "100Mi",
+				objects:        []client.Object{storageQuota},
+				expectedMemory: "200Mi",
+			},
+		}
 
-		if err := i.Mutate(ctx, p, req); err != nil {
-			t.Fatalf("mutating Pod: %v", err)
+		for testCaseName, testData := range cases {
+			testData := testData
+
+			t.Run(testCaseName, func(t *testing.T) {
+				t.Parallel()
+
+				objects := append([]client.Object{getCRB(testResourcePrefix), quota(testData.used)}, testData.objects...)
+				c := fake.NewClientBuilder().WithObjects(objects...).Build()
+				recorder := events.NewFakeRecorder(1)
+
+				requests := testData.requests
+				if requests == nil {
+					requests = corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("200Mi")}
+				}
+
+				config := getConfig()
+				config.ResourceQuotaPolicy = testData.policy
+				config.EventRecorder = recorder
+				config.AgentConfig.ConfigSelectors = []agent.ConfigSelector{
+					{
+						ResourceRequirements: &corev1.ResourceRequirements{
+							Requests: requests,
+						},
+					},
+				}
+
+				i, err := config.New(c, c, testr.New(t))
+				if err != nil {
+					t.Fatalf("creating injector: %v", err)
+				}
+
+				req := req
+				req.Decision = &webhook.Decision{}
+
+				p := getEmptyPod()
+				if err := i.Mutate(ctx, p, req); err != nil {
+					t.Fatalf("mutating Pod: %v", err)
+				}
+
+				if testData.expectSkip {
+					if req.Decision.Inject || len(p.Spec.Containers) != 1 {
+						t.Fatalf("expected injection to be skipped, got decision: %+v", req.Decision)
+					}
+
+					select {
+					case event := <-recorder.Events:
+						if !strings.Contains(event, agent.ResourceQuotaExceededReason) {
+							t.Fatalf("unexpected event: %q", event)
+						}
+					default:
+						t.Fatalf("expected event to be recorded")
+					}
+
+					return
+				}
+
+				memory, ok := infraContainer(t, p).Resources.Requests[corev1.ResourceMemory]
+				if testData.expectedMemory == "" {
+					if ok {
+						t.Fatalf("expected no memory request, got %s", &memory)
+					}
+
+					return
+				}
+
+				if expected := resource.MustParse(testData.expectedMemory); memory.Cmp(expected) != 0 {
+					t.Fatalf("expected memory request %s, got %s", &expected, &memory)
+				}
+			})
+		}
+	})
+
+	t.Run("when_sidecar_collides_with_Pod", func(t *testing.T) {
+		t.Parallel()
+
+		withCollidingVolume := func(p *corev1.Pod) {
+			p.Spec.Volumes = []corev1.Volume{
+				{Name: "tmpfs-data-injected", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{}}},
+				{Name: "tmpfs-data-injected-1"},
+			}
 		}
 
-		if diff := cmp.Diff(mutatedPod, p); diff != "" {
-			t.Fatalf("unexpected Pod diff\n: %v", diff)
+		withCollidingInitContainer := func(p *corev1.Pod) {
+			p.Spec.InitContainers = []corev1.Container{{Name: agent.AgentSidecarName}}
+		}
+
+		withCollidingEnv := func(config *agent.InjectorConfig) {
+			config.AgentConfig.ConfigSelectors = []agent.ConfigSelector{
+				{ExtraEnvVars: map[string]string{"NRIA_CUSTOM_ATTRIBUTES": "{}"}},
+			}
+		}
+
+		cases := map[string]struct {
+			strategy   string
+			podMutateF func(*corev1.Pod)
+			configF    func(*agent.InjectorConfig)
+			// expectedSidecar is an expected name of injected sidecar. Empty value means no sidecar is expected.
+			expectedSidecar string
+			expectedVolume  string
+			expectError     bool
+		}{
+			"fails_on_volume_collision_by_default": {
+				podMutateF:  withCollidingVolume,
+				expectError: true,
+			},
+			"fails_on_container_name_collision_with_fail_strategy": {
+				strategy:    agent.CollisionStrategyFail,
+				podMutateF:  withCollidingInitContainer,
+				expectError: true,
+			},
+			"skips_injection_on_volume_collision_with_skip_strategy": {
+				strategy:   agent.CollisionStrategySkip,
+				podMutateF: withCollidingVolume,
+			},
+			"skips_injection_on_environment_variable_collision_with_skip_strategy": {
+				strategy: agent.CollisionStrategySkip,
+				configF:  withCollidingEnv,
+			},
+			"renames_colliding_volumes_with_suffix_strategy": {
+				strategy:        agent.CollisionStrategySuffix,
+				podMutateF:      withCollidingVolume,
+				expectedSidecar: agent.AgentSidecarName,
+				expectedVolume:  "tmpfs-data-injected-2",
+			},
+			"renames_colliding_container_with_suffix_strategy": {
+				strategy:        agent.CollisionStrategySuffix,
+				podMutateF:      withCollidingInitContainer,
+				expectedSidecar: agent.AgentSidecarName + "-1",
+				expectedVolume:  "tmpfs-data-injected",
+			},
+			"fails_on_environment_variable_collision_with_suffix_strategy": {
+				strategy:    agent.CollisionStrategySuffix,
+				configF:     withCollidingEnv,
+				expectError: true,
+			},
+		}
+
+		for testCaseName, testData := range cases {
+			testData := testData
+
+			t.Run(testCaseName, func(t *testing.T) {
+				t.Parallel()
+
+				p := getEmptyPod()
+				if testData.podMutateF != nil {
+					testData.podMutateF(p)
+				}
+
+				c := fake.NewClientBuilder().WithObjects(getCRB(testResourcePrefix)).Build()
+				config := getConfig()
+				config.CollisionStrategy = testData.strategy
+
+				if testData.configF != nil {
+					testData.configF(config)
+				}
+
+				i, err := config.New(c, c, testr.New(t))
+				if err != nil {
+					t.Fatalf("creating injector: %v", err)
+				}
+
+				original := p.DeepCopy()
+
+				req := req
+				req.Decision = &webhook.Decision{}
+
+				err = i.Mutate(ctx, p, req)
+
+				switch {
+				case testData.expectError && err == nil:
+					t.Fatalf("expected error")
+				case !testData.expectError && err != nil:
+					t.Fatalf("mutating Pod: %v", err)
+				case testData.expectError:
+					return
+				}
+
+				if testData.expectedSidecar == "" {
+					if req.Decision.Inject {
+						t.Fatalf("expected injection to be skipped, got decision: %+v", req.Decision)
+					}
+
+					if diff := cmp.Diff(original.Spec, p.Spec); diff != "" {
+						t.Fatalf("unexpected Pod spec diff: %s", diff)
+					}
+
+					return
+				}
+
+				sidecar := p.Spec.Containers[len(p.Spec.Containers)-1]
+				if sidecar.Name != testData.expectedSidecar {
+					t.Fatalf("expected sidecar %q, got %q", testData.expectedSidecar, sidecar.Name)
+				}
+
+				existingVolumes := p.Spec.Volumes[:len(original.Spec.Volumes)]
+				if diff := cmp.Diff(original.Spec.Volumes, existingVolumes, cmpopts.EquateEmpty()); diff != "" {
+					t.Fatalf("unexpected diff of existing volumes: %s", diff)
+				}
+
+				mounted := false
+
+				for _, m := range sidecar.VolumeMounts {
+					if m.Name == testData.expectedVolume && m.MountPath == "/var/db/newrelic-infra/data" {
+						mounted = true
+					}
+				}
+
+				if !mounted {
+					t.Fatalf("expected volume %q to be mounted by sidecar, got: %v", testData.expectedVolume,
+						sidecar.VolumeMounts)
+				}
+
+				mutatedPod := p.DeepCopy()
+
+				if err := i.Mutate(ctx, p, req); err != nil {
+					t.Fatalf("mutating Pod again: %v", err)
+				}
+
+				if diff := cmp.Diff(mutatedPod, p); diff != "" {
+					t.Fatalf("unexpected Pod diff after mutating again: %s", diff)
+				}
+			})
 		}
 	})
 
@@ -745,11 +2745,173 @@ func Test_Mutate(t *testing.T) {
 			t.Fatalf("getting ClusterRoleBinding: %v", err)
 		}
 
-		expectedSubjects := 1
+		expectedSubjects := 1
+
+		if subjects := len(crb.Subjects); subjects != expectedSubjects {
+			t.Fatalf("expected %d subjects, got %d: %v", expectedSubjects, subjects, crb.Subjects)
+		}
+	})
+
+	t.Run("with_sharded_ClusterRoleBindings", func(t *testing.T) {
+		t.Parallel()
+
+		shardedInjector := func(t *testing.T, c client.Client, maxSubjects int) agent.Injector {
+			t.Helper()
+
+			config := getConfig()
+			config.ClusterRoleBindingSharding = agent.ClusterRoleBindingSharding{
+				Shards:      1,
+				MaxSubjects: maxSubjects,
+			}
+
+			i, err := config.New(c, c, testr.New(t))
+			if err != nil {
+				t.Fatalf("creating injector: %v", err)
+			}
+
+			return i
+		}
+
+		legacyCRB := func() *rbacv1.ClusterRoleBinding {
+			crb := getCRB(testResourcePrefix)
+			crb.RoleRef = rbacv1.RoleRef{
+				APIGroup: rbacv1.GroupName,
+				Kind:     "ClusterRole",
+				Name:     clusterRoleBindingName(testResourcePrefix),
+			}
+
+			return crb
+		}
+
+		firstShardName := agent.ShardClusterRoleBindingName(clusterRoleBindingName(testResourcePrefix), 0)
+
+		// shardCRB returns first shard ClusterRoleBinding as pre-created by the chart.
+		shardCRB := func() *rbacv1.ClusterRoleBinding {
+			crb := legacyCRB()
+			crb.Name = firstShardName
+
+			return crb
+		}
+
+		getShard := func(t *testing.T, c client.Client, name string) *rbacv1.ClusterRoleBinding {
+			t.Helper()
+
+			crb := &rbacv1.ClusterRoleBinding{}
+			if err := c.Get(ctx, client.ObjectKey{Name: name}, crb); err != nil {
+				t.Fatalf("getting ClusterRoleBinding %q: %v", name, err)
+			}
+
+			return crb
+		}
+
+		t.Run("adds_Pod_ServiceAccount_to_pre-created_shard_binding", func(t *testing.T) {
+			t.Parallel()
+
+			c := fake.NewClientBuilder().WithObjects(legacyCRB(), shardCRB()).Build()
+			i := shardedInjector(t, c, 0)
+
+			for range 2 {
+				if err := i.Mutate(ctx, getEmptyPod(), req); err != nil {
+					t.Fatalf("mutating Pod: %v", err)
+				}
+			}
+
+			shard := getShard(t, c, firstShardName)
+
+			expectedSubjects := []rbacv1.Subject{
+				{
+					Kind:      rbacv1.ServiceAccountKind,
+					Name:      "default",
+					Namespace: testNamespace,
+				},
+			}
+
+			if diff := cmp.Diff(expectedSubjects, shard.Subjects); diff != "" {
+				t.Fatalf("unexpected subjects diff: %s", diff)
+			}
+
+			if len(getShard(t, c, clusterRoleBindingName(testResourcePrefix)).Subjects) != 0 {
+				t.Fatalf("expected pre-created ClusterRoleBinding to not be modified")
+			}
+		})
+
+		t.Run("fails_when_shard_binding_is_full", func(t *testing.T) {
+			t.Parallel()
+
+			c := fake.NewClientBuilder().WithObjects(legacyCRB(), shardCRB()).Build()
+			i := shardedInjector(t, c, 1)
+
+			p := getEmptyPod()
+			p.Spec.ServiceAccountName = "first"
+
+			if err := i.Mutate(ctx, p, req); err != nil {
+				t.Fatalf("mutating Pod: %v", err)
+			}
+
+			p = getEmptyPod()
+			p.Spec.ServiceAccountName = "second"
+
+			if err := i.Mutate(ctx, p, req); err == nil {
+				t.Fatalf("expected error when shard binding is full")
+			}
+
+			if subjects := getShard(t, c, firstShardName).Subjects; len(subjects) != 1 || subjects[0].Name != "first" {
+				t.Fatalf("unexpected subjects in shard binding: %v", subjects)
+			}
+		})
+
+		t.Run("fails_when_shard_binding_does_not_exist", func(t *testing.T) {
+			t.Parallel()
+
+			c := fake.NewClientBuilder().WithObjects(legacyCRB()).Build()
+			i := shardedInjector(t, c, 0)
+
+			if err := i.Mutate(ctx, getEmptyPod(), req); err == nil {
+				t.Fatalf("expected error when shard binding does not exist")
+			}
+
+			crbs := &rbacv1.ClusterRoleBindingList{}
+			if err := c.List(ctx, crbs); err != nil {
+				t.Fatalf("listing ClusterRoleBindings: %v", err)
+			}
+
+			if len(crbs.Items) != 1 {
+				t.Fatalf("expected operator to not create ClusterRoleBindings, got: %v", crbs.Items)
+			}
+		})
+
+		t.Run("migrates_ServiceAccount_subjects_from_pre-created_binding_by_leader", func(t *testing.T) {
+			t.Parallel()
+
+			crb := legacyCRB()
+			crb.Subjects = []rbacv1.Subject{
+				{
+					Kind:      rbacv1.ServiceAccountKind,
+					Name:      "migrated",
+					Namespace: testNamespace,
+				},
+				{
+					Kind: rbacv1.GroupKind,
+					Name: "retained",
+				},
+			}
+
+			c := fake.NewClientBuilder().WithObjects(crb, shardCRB()).Build()
+			i := shardedInjector(t, c, 0)
+
+			if err := i.LeaderRunnable().Start(ctx); err != nil {
+				t.Fatalf("running leader tasks: %v", err)
+			}
+
+			if subjects := getShard(t, c, firstShardName).Subjects; len(subjects) != 1 || subjects[0].Name != "migrated" {
+				t.Fatalf("unexpected subjects in shard binding: %v", subjects)
+			}
 
-		if subjects := len(crb.Subjects); subjects != expectedSubjects {
-			t.Fatalf("expected %d subjects, got %d: %v", expectedSubjects, subjects, crb.Subjects)
-		}
+			legacySubjects := getShard(t, c, crb.Name).Subjects
+			if len(legacySubjects) != 1 || legacySubjects[0].Name != "retained" {
+				t.Fatalf("unexpected subjects in pre-created binding: %v", legacySubjects)
+			}
+		})
 	})
 
 	t.Run("injects_sidecar_when", func(t *testing.T) {
@@ -832,6 +2994,34 @@ func Test_Mutate(t *testing.T) {
 					}
 				},
 			},
+			"include_policy_matches_before_exclude_policy": {
+				configMutateF: func(config *agent.InjectorConfig) {
+					config.Policies = []agent.InjectionPolicy{
+						{},
+						{
+							Action: agent.PolicyActionExclude,
+						},
+					}
+				},
+			},
+			"include_policy_with_higher_priority_matches": {
+				configMutateF: func(config *agent.InjectorConfig) {
+					config.Policies = []agent.InjectionPolicy{
+						{
+							Action: agent.PolicyActionExclude,
+						},
+						{
+							Action:   agent.PolicyActionInclude,
+							Priority: 1,
+						},
+					}
+				},
+			},
+			"other_namespaces_are_excluded": {
+				configMutateF: func(config *agent.InjectorConfig) {
+					config.ExcludeNamespaces = []string{"kube-system"}
+				},
+			},
 			"at_least_one_policy_matches": {
 				podMutateF: func(p *corev1.Pod) {
 					p.Labels["foo"] = "baz"
@@ -913,11 +3103,6 @@ func Test_Mutate(t *testing.T) {
 					p.Labels[agent.DisableInjectionLabel] = "anyValue"
 				},
 			},
-			"injected_label_is_present": {
-				podMutateF: func(p *corev1.Pod) {
-					p.Labels[agent.InjectedLabel] = "anyValue"
-				},
-			},
 			"owner_is_Job_batch/v1": {
 				podMutateF: func(p *corev1.Pod) {
 					p.OwnerReferences = []metav1.OwnerReference{
@@ -938,6 +3123,40 @@ func Test_Mutate(t *testing.T) {
 					}
 				},
 			},
+			"exclude_policy_matches_first": {
+				podMutateF: func(p *corev1.Pod) {
+					p.Labels["foo"] = "bar"
+				},
+				configMutateF: func(config *agent.InjectorConfig) {
+					config.Policies = []agent.InjectionPolicy{
+						{
+							Action: agent.PolicyActionExclude,
+							PodSelector: &metav1.LabelSelector{
+								MatchLabels: map[string]string{
+									"foo": "bar",
+								},
+							},
+						},
+						{},
+					}
+				},
+			},
+			"exclude_policy_with_higher_priority_matches": {
+				configMutateF: func(config *agent.InjectorConfig) {
+					config.Policies = []agent.InjectionPolicy{
+						{},
+						{
+							Action:   agent.PolicyActionExclude,
+							Priority: 10,
+						},
+					}
+				},
+			},
+			"namespace_is_excluded": {
+				configMutateF: func(config *agent.InjectorConfig) {
+					config.ExcludeNamespaces = []string{"kube-system", testNamespace}
+				},
+			},
 			"there_is_no_policy_matching": {
 				configMutateF: func(config *agent.InjectorConfig) {
 					config.Policies = []agent.InjectionPolicy{
@@ -1063,6 +3282,272 @@ func Test_Mutate(t *testing.T) {
 		}
 	})
 
+	t.Run("looks_up_Namespace_for_policy_matching", func(t *testing.T) {
+		t.Parallel()
+
+		namespaceWithLabels := func(labels map[string]string) *corev1.Namespace {
+			return &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   testNamespace,
+					Labels: labels,
+				},
+			}
+		}
+
+		matching := namespaceWithLabels(map[string]string{"foo": "bar"})
+		notMatching := namespaceWithLabels(nil)
+
+		cases := map[string]struct {
+			cache        *namespaceCache
+			apiNamespace *corev1.Namespace
+		}{
+			"in_cache_when_it_is_synced": {
+				cache: &namespaceCache{
+					Reader: fake.NewClientBuilder().WithObjects(matching).Build(),
+					synced: true,
+				},
+				apiNamespace: notMatching,
+			},
+			"via_API_when_cache_is_not_synced": {
+				cache: &namespaceCache{
+					Reader: fake.NewClientBuilder().WithObjects(notMatching).Build(),
+				},
+				apiNamespace: matching,
+			},
+			"via_API_when_Namespace_is_not_in_cache": {
+				cache: &namespaceCache{
+					Reader: fake.NewClientBuilder().Build(),
+					synced: true,
+				},
+				apiNamespace: matching,
+			},
+			"via_API_when_cache_is_not_configured": {
+				apiNamespace: matching,
+			},
+		}
+
+		for testCaseName, testData := range cases {
+			testData := testData
+
+			t.Run(testCaseName, func(t *testing.T) {
+				t.Parallel()
+
+				p := getEmptyPod()
+
+				c := fake.NewClientBuilder().WithObjects(getCRB(testResourcePrefix), testData.apiNamespace).Build()
+
+				config := getConfig()
+				config.Policies = []agent.InjectionPolicy{
+					{
+						NamespaceSelector: &metav1.LabelSelector{
+							MatchLabels: map[string]string{
+								"foo": "bar",
+							},
+						},
+					},
+				}
+
+				if testData.cache != nil {
+					config.NamespaceCache = testData.cache
+				}
+
+				i, err := config.New(c, c, testr.New(t))
+				if err != nil {
+					t.Fatalf("creating injector: %v", err)
+				}
+
+				if err := i.Mutate(ctx, p, req); err != nil {
+					t.Fatalf("mutating Pod: %v", err)
+				}
+
+				// Only Namespace in the expected source matches the policy.
+				infraContainer(t, p)
+			})
+		}
+	})
+
+	t.Run("with_async_dependencies", func(t *testing.T) {
+		t.Parallel()
+
+		asyncInjector := func(t *testing.T, c client.Client, wait, resync time.Duration) agent.Injector {
+			t.Helper()
+
+			config := getConfig()
+			config.AsyncDependencies = agent.AsyncDependenciesConfig{
+				Enabled:      true,
+				Wait:         metav1.Duration{Duration: wait},
+				ResyncPeriod: metav1.Duration{Duration: resync},
+			}
+
+			i, err := config.New(c, c, testr.New(t))
+			if err != nil {
+				t.Fatalf("creating injector: %v", err)
+			}
+
+			return i
+		}
+
+		startInjector := func(t *testing.T, i agent.Injector) {
+			t.Helper()
+
+			startCtx, cancel := context.WithCancel(ctx)
+
+			done := make(chan struct{})
+
+			go func() {
+				if err := i.Start(startCtx); err != nil {
+					t.Errorf("running injector: %v", err)
+				}
+
+				close(done)
+			}()
+
+			t.Cleanup(func() {
+				cancel()
+				<-done
+			})
+		}
+
+		t.Run("provisions_dependencies_before_admitting_Pod_when_they_are_provisioned_in_time", func(t *testing.T) {
+			t.Parallel()
+
+			c := fake.NewClientBuilder().WithObjects(getCRB(testResourcePrefix)).Build()
+			i := asyncInjector(t, c, 10*time.Second, 0)
+
+			startInjector(t, i)
+
+			if err := i.Mutate(ctx, getEmptyPod(), req); err != nil {
+				t.Fatalf("mutating Pod: %v", err)
+			}
+
+			if err := c.Get(ctx, secretKey, &corev1.Secret{}); err != nil {
+				t.Fatalf("getting license Secret: %v", err)
+			}
+		})
+
+		t.Run("admits_Pod_before_dependencies_are_provisioned_when_wait_time_passes", func(t *testing.T) {
+			t.Parallel()
+
+			c := fake.NewClientBuilder().WithObjects(getCRB(testResourcePrefix)).Build()
+			i := asyncInjector(t, c, 10*time.Millisecond, 0)
+
+			p := getEmptyPod()
+
+			if err := i.Mutate(ctx, p, req); err != nil {
+				t.Fatalf("mutating Pod: %v", err)
+			}
+
+			infraContainer(t, p)
+
+			if err := c.Get(ctx, secretKey, &corev1.Secret{}); !errors.IsNotFound(err) {
+				t.Fatalf("expected license Secret to not exist yet, got: %v", err)
+			}
+		})
+
+		t.Run("provisions_dependencies_again_when_resync_period_passes", func(t *testing.T) {
+			t.Parallel()
+
+			c := fake.NewClientBuilder().WithObjects(getCRB(testResourcePrefix)).Build()
+			i := asyncInjector(t, c, 10*time.Second, 10*time.Millisecond)
+
+			startInjector(t, i)
+
+			if err := i.Mutate(ctx, getEmptyPod(), req); err != nil {
+				t.Fatalf("mutating Pod: %v", err)
+			}
+
+			secret := &corev1.Secret{}
+			if err := c.Get(ctx, secretKey, secret); err != nil {
+				t.Fatalf("getting license Secret: %v", err)
+			}
+
+			// Simulates removal of the Secret, e.g. when Namespace gets re-created.
+			if err := c.Delete(ctx, secret); err != nil {
+				t.Fatalf("deleting license Secret: %v", err)
+			}
+
+			for {
+				select {
+				case <-ctx.Done():
+					t.Fatalf("timed out waiting for license Secret to be re-created")
+				case <-time.After(20 * time.Millisecond):
+				}
+
+				if err := i.Mutate(ctx, getEmptyPod(), req); err != nil {
+					t.Fatalf("mutating Pod: %v", err)
+				}
+
+				if err := c.Get(ctx, secretKey, &corev1.Secret{}); err == nil {
+					return
+				}
+			}
+		})
+
+		t.Run("retries_provisioning_until_it_succeeds", func(t *testing.T) {
+			t.Parallel()
+
+			c := fake.NewClientBuilder().Build()
+			i := asyncInjector(t, c, 10*time.Millisecond, 0)
+
+			startInjector(t, i)
+
+			if err := i.Mutate(ctx, getEmptyPod(), req); err != nil {
+				t.Fatalf("mutating Pod: %v", err)
+			}
+
+			if err := c.Create(ctx, getCRB(testResourcePrefix)); err != nil {
+				t.Fatalf("creating ClusterRoleBinding: %v", err)
+			}
+
+			for {
+				crb := &rbacv1.ClusterRoleBinding{}
+				if err := c.Get(ctx, client.ObjectKey{Name: clusterRoleBindingName(testResourcePrefix)}, crb); err != nil {
+					t.Fatalf("getting ClusterRoleBinding: %v", err)
+				}
+
+				if len(crb.Subjects) > 0 {
+					return
+				}
+
+				select {
+				case <-ctx.Done():
+					t.Fatalf("timed out waiting for ClusterRoleBinding subject to be added")
+				case <-time.After(10 * time.Millisecond):
+				}
+			}
+		})
+	})
+
+	t.Run("traces_Namespace_lookup_and_provisioning_of_sidecar_dependencies", func(t *testing.T) {
+		t.Parallel()
+
+		recorder := tracetest.NewSpanRecorder()
+
+		c := fake.NewClientBuilder().WithObjects(getCRB(testResourcePrefix)).Build()
+		config := getConfig()
+		config.TracerProvider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+		i, err := config.New(c, c, testr.New(t))
+		if err != nil {
+			t.Fatalf("creating injector: %v", err)
+		}
+
+		if err := i.Mutate(ctx, getEmptyPod(), req); err != nil {
+			t.Fatalf("mutating Pod: %v", err)
+		}
+
+		names := []string{}
+		for _, span := range recorder.Ended() {
+			names = append(names, span.Name())
+		}
+
+		expectedNames := []string{"policyNamespace", "ensureLicenseSecretExistence", "ensureClusterRoleBindingSubject"}
+
+		if diff := cmp.Diff(expectedNames, names); diff != "" {
+			t.Fatalf("unexpected spans: %s", diff)
+		}
+	})
+
 	t.Run("updates_license_secret_when_license_key_changes", func(t *testing.T) {
 		t.Parallel()
 
@@ -1227,6 +3712,64 @@ func Test_Mutate(t *testing.T) {
 	})
 }
 
+func Test_Injector_readiness_check_for_ClusterRoleBinding(t *testing.T) {
+	t.Parallel()
+
+	shardCRB := getCRB(testResourcePrefix)
+	shardCRB.Name = agent.ShardClusterRoleBindingName(shardCRB.Name, 0)
+
+	cases := map[string]struct {
+		objects     []client.Object
+		shards      int
+		expectError bool
+	}{
+		"succeeds_when_pre-created_ClusterRoleBinding_exists": {
+			objects: []client.Object{getCRB(testResourcePrefix)},
+		},
+		"fails_when_pre-created_ClusterRoleBinding_does_not_exist": {
+			expectError: true,
+		},
+		"succeeds_when_pre-created_shard_ClusterRoleBindings_exist": {
+			objects: []client.Object{getCRB(testResourcePrefix), shardCRB},
+			shards:  1,
+		},
+		"fails_when_pre-created_shard_ClusterRoleBinding_does_not_exist": {
+			objects:     []client.Object{getCRB(testResourcePrefix), shardCRB},
+			shards:      2,
+			expectError: true,
+		},
+	}
+
+	for testCaseName, testData := range cases {
+		testData := testData
+
+		t.Run(testCaseName, func(t *testing.T) {
+			t.Parallel()
+
+			c := fake.NewClientBuilder().WithObjects(testData.objects...).Build()
+
+			config := getConfig()
+			config.ClusterRoleBindingSharding.Shards = testData.shards
+
+			i, err := config.New(c, c, testr.New(t))
+			if err != nil {
+				t.Fatalf("creating injector: %v", err)
+			}
+
+			check, ok := i.ReadyzChecks()["cluster-role-binding"]
+			if !ok {
+				t.Fatalf("ClusterRoleBinding readiness check not found")
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/readyz/cluster-role-binding", nil)
+
+			if err := check(req); (err != nil) != testData.expectError {
+				t.Fatalf("expected error: %v, got: %v", testData.expectError, err)
+			}
+		})
+	}
+}
+
 //nolint:funlen,gocognit,cyclop,gocyclo
 func Test_Mutation_hash(t *testing.T) {
 	t.Parallel()
@@ -1286,6 +3829,19 @@ func Test_Mutation_hash(t *testing.T) {
 			"cluster_name": func(config *agent.InjectorConfig) {
 				config.ClusterName = "baz"
 			},
+			"integrations_of_matching_config_selector": func(c *agent.InjectorConfig) {
+				c.AgentConfig.Integrations = []agent.Integration{
+					{
+						Name:   "nri-redis",
+						Config: "integrations: []",
+					},
+				}
+				c.AgentConfig.ConfigSelectors = []agent.ConfigSelector{
+					{
+						Integrations: []string{"nri-redis"},
+					},
+				}
+			},
 			"custom_attributes_config": func(config *agent.InjectorConfig) {
 				config.AgentConfig.CustomAttributes = []agent.CustomAttribute{
 					{
@@ -1382,6 +3938,15 @@ func infraContainer(t *testing.T, pod *corev1.Pod) corev1.Container {
 	return corev1.Container{}
 }
 
+type namespaceCache struct {
+	client.Reader
+	synced bool
+}
+
+func (n *namespaceCache) HasSynced() bool {
+	return n.synced
+}
+
 func clusterRoleBindingName(prefix string) string {
 	return fmt.Sprintf("%s%s", prefix, agent.ClusterRoleBindingSuffix)
 }
@@ -0,0 +1,252 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"text/template"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// IntegrationsConfigMapSuffix is the suffix which will be added to created integrations ConfigMap objects,
+	// combined with configured resource prefix and the hash of the rendered configuration.
+	IntegrationsConfigMapSuffix = "-integrations"
+
+	// IntegrationsDir is the directory in the sidecar container, where integration configuration files are mounted.
+	IntegrationsDir = "/etc/newrelic-infra/integrations.d"
+
+	integrationsVolumeName = "integrations-config-injected"
+	integrationFileSuffix  = ".yaml"
+	configMapHashLength    = 10
+
+	// envPodIP is exposed to the sidecar when integrations are configured, so agent can expand it when
+	// loading integration configuration files.
+	envPodIP = "NRI_POD_IP"
+)
+
+// volatileLabels are labels set by workload controllers, which differ between revisions or Pods of the same
+// workload. They are not available in integration templates, as ConfigMap names are derived from rendered content
+// and every rollout would otherwise create a new ConfigMap.
+//
+//nolint:gochecknoglobals // Used as a constant set.
+var volatileLabels = map[string]struct{}{
+	appsv1.DefaultDeploymentUniqueLabelKey: {},
+	appsv1.ControllerRevisionHashLabelKey:  {},
+	appsv1.StatefulSetPodNameLabel:         {},
+	appsv1.PodIndexLabel:                   {},
+	batchv1.ControllerUidLabel:             {},
+	// Job completion index is set both as an annotation and a label.
+	batchv1.JobCompletionIndexAnnotation: {},
+	// Legacy label holding UID of the Job.
+	"controller-uid": {},
+	// Labels set by the operator itself. Injected label holds hash of the sidecar configuration.
+	InjectedLabel:        {},
+	OperatorCreatedLabel: {},
+}
+
+// integrationTemplateData holds variables available in integration configuration templates.
+type integrationTemplateData struct {
+	Namespace string
+	Labels    map[string]string
+	Ports     map[string]int32
+	PodIP     string
+}
+
+func (config *InjectorConfig) buildIntegrations() error {
+	for i, integration := range config.AgentConfig.Integrations {
+		t, err := template.New(integration.Name).Option("missingkey=error").Parse(integration.Config)
+		if err != nil {
+			return fmt.Errorf("parsing configuration of integration %q: %w", integration.Name, err)
+		}
+
+		config.AgentConfig.Integrations[i].template = t
+	}
+
+	return nil
+}
+
+func (config InjectorConfig) validateIntegrations(fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	integrationNames := map[string]struct{}{}
+	integrationsPath := fldPath.Child("agentConfig", "integrations")
+
+	for i, integration := range config.AgentConfig.Integrations {
+		namePath := integrationsPath.Index(i).Child("name")
+
+		switch _, duplicate := integrationNames[integration.Name]; {
+		case integration.Name == "":
+			allErrs = append(allErrs, field.Required(namePath, "integration name must be set"))
+		case duplicate:
+			allErrs = append(allErrs, field.Duplicate(namePath, integration.Name))
+		default:
+			for _, msg := range validation.IsConfigMapKey(integration.Name + integrationFileSuffix) {
+				allErrs = append(allErrs, field.Invalid(namePath, integration.Name, msg))
+			}
+		}
+
+		if integration.Config == "" {
+			allErrs = append(allErrs, field.Required(integrationsPath.Index(i).Child("config"),
+				"integration config must be set"))
+		}
+
+		integrationNames[integration.Name] = struct{}{}
+	}
+
+	for i, r := range config.AgentConfig.ConfigSelectors {
+		for j, name := range r.Integrations {
+			if _, ok := integrationNames[name]; !ok {
+				allErrs = append(allErrs, field.NotFound(
+					fldPath.Child("agentConfig", "configSelectors").Index(i).Child("integrations").Index(j), name))
+			}
+		}
+	}
+
+	return allErrs
+}
+
+// selectedIntegrations returns integrations referenced by given names, preserving their order.
+func (config InjectorConfig) selectedIntegrations(names []string) []Integration {
+	integrations := []Integration{}
+
+	for _, name := range names {
+		for _, integration := range config.AgentConfig.Integrations {
+			if integration.Name == name {
+				integrations = append(integrations, integration)
+			}
+		}
+	}
+
+	return integrations
+}
+
+// integrationsConfigMap renders given integrations for given Pod and returns ConfigMap holding them.
+//
+// ConfigMap name is derived from the rendered content, so Pods rendering the same configuration, e.g. created
+// by the same Deployment, share a single ConfigMap. If there is no integrations to render, nil is returned.
+func (i *injector) integrationsConfigMap(
+	pod *corev1.Pod,
+	namespace string,
+	integrations []Integration,
+) (*corev1.ConfigMap, error) {
+	if len(integrations) == 0 {
+		return nil, nil //nolint:nilnil
+	}
+
+	data := integrationTemplateData{
+		Namespace: namespace,
+		Labels:    map[string]string{},
+		Ports:     map[string]int32{},
+		PodIP:     fmt.Sprintf("{{%s}}", envPodIP),
+	}
+
+	for k, v := range pod.Labels {
+		if _, volatile := volatileLabels[k]; !volatile {
+			data.Labels[k] = v
+		}
+	}
+
+	for _, c := range pod.Spec.Containers {
+		for _, p := range c.Ports {
+			if p.Name != "" {
+				data.Ports[p.Name] = p.ContainerPort
+			}
+		}
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Labels: map[string]string{
+				OperatorCreatedLabel: OperatorCreatedLabelValue,
+			},
+		},
+		Data: map[string]string{},
+	}
+
+	for _, integration := range integrations {
+		rendered := &bytes.Buffer{}
+
+		if err := integration.template.Execute(rendered, data); err != nil {
+			return nil, fmt.Errorf("rendering configuration of integration %q: %w", integration.Name, err)
+		}
+
+		cm.Data[integration.Name+integrationFileSuffix] = rendered.String()
+	}
+
+	hash, err := hashOf(cm.Data)
+	if err != nil {
+		return nil, fmt.Errorf("calculating integrations hash: %w", err)
+	}
+
+	cm.Name = fmt.Sprintf("%s%s-%s", i.config.ResourcePrefix, IntegrationsConfigMapSuffix, hash[:configMapHashLength])
+
+	return cm, nil
+}
+
+// withIntegrations mounts integration configuration files from given ConfigMap into given container and returns
+// volume which must be added to the Pod.
+func withIntegrations(container *corev1.Container, cm *corev1.ConfigMap) corev1.Volume {
+	keys := make([]string, 0, len(cm.Data))
+	for k := range cm.Data {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      integrationsVolumeName,
+			MountPath: path.Join(IntegrationsDir, k),
+			SubPath:   k,
+			ReadOnly:  true,
+		})
+	}
+
+	container.Env = append(container.Env, corev1.EnvVar{
+		Name: envPodIP,
+		ValueFrom: &corev1.EnvVarSource{
+			FieldRef: &corev1.ObjectFieldSelector{
+				APIVersion: "v1",
+				FieldPath:  "status.podIP",
+			},
+		},
+	})
+
+	return corev1.Volume{
+		Name: integrationsVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: cm.Name,
+				},
+			},
+		},
+	}
+}
+
+// ensureIntegrationsConfigMap creates given ConfigMap. As ConfigMap name is derived from its content, existing
+// ConfigMap is never updated.
+//
+// Created ConfigMaps are never deleted. The same ConfigMap may be reused by Pods admitted later, which are not
+// created yet, so it cannot be safely removed once no existing Pod references it.
+func (i *injector) ensureIntegrationsConfigMap(ctx context.Context, cm *corev1.ConfigMap) error {
+	if err := i.noCacheClient.Create(ctx, cm, &client.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("creating ConfigMap %s/%s: %w", cm.Namespace, cm.Name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,205 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/utils/ptr"
+
+	"github.com/newrelic/newrelic-infra-operator/internal/webhook"
+)
+
+// DefaultMaxShutdownDelaySeconds is a default time for which sidecar shutdown is delayed while waiting for other
+// containers of the Pod to exit.
+const DefaultMaxShutdownDelaySeconds = 30
+
+// Lifecycle configures how the sidecar is started and stopped with the Pod.
+type Lifecycle struct {
+	// NativeSidecar injects the sidecar as an init container with "Always" restart policy, which kubelet starts
+	// before regular containers and stops only after all of them exit. Requires Kubernetes 1.29 or newer.
+	NativeSidecar bool `json:"nativeSidecar"`
+	// PreStop is a handler executed before the sidecar is stopped, e.g. to give agent time to flush collected
+	// samples. Cannot be used together with WaitForContainers, unless sidecar is native.
+	PreStop *corev1.LifecycleHandler `json:"preStop"`
+	// WaitForContainers delays sidecar shutdown until other containers of the Pod exit, so samples from the
+	// whole lifetime of the Pod are reported. Classic sidecars get preStop hook waiting until TCP ports declared
+	// by other containers are no longer listening, for at most MaxShutdownDelaySeconds. Hook is run using
+	// "/bin/sh" and "sleep" of the sidecar image, which must provide them. If Pod declares no TCP ports or image
+	// has no "date" and "grep" to check them, shutdown is delayed for MaxShutdownDelaySeconds. Native sidecars are stopped by kubelet after
+	// other containers exit, so no hook is added and only termination grace period is extended.
+	WaitForContainers bool `json:"waitForContainers"`
+	// MaxShutdownDelaySeconds limits time for which sidecar shutdown is delayed by WaitForContainers. Defaults to
+	// DefaultMaxShutdownDelaySeconds.
+	MaxShutdownDelaySeconds int32 `json:"maxShutdownDelaySeconds"`
+	// TerminationGracePeriodSeconds, if set, is a minimum termination grace period of injected Pods. Pods with
+	// shorter grace period get it extended, so the sidecar is not killed before completing its shutdown.
+	TerminationGracePeriodSeconds *int64 `json:"terminationGracePeriodSeconds"`
+}
+
+func (l *Lifecycle) maxShutdownDelay() int32 {
+	if l.MaxShutdownDelaySeconds == 0 {
+		return DefaultMaxShutdownDelaySeconds
+	}
+
+	return l.MaxShutdownDelaySeconds
+}
+
+// apply sets Pod-independent lifecycle of given container.
+func (l *Lifecycle) apply(container *corev1.Container) {
+	if l == nil {
+		return
+	}
+
+	if l.NativeSidecar {
+		container.RestartPolicy = ptr.To(corev1.ContainerRestartPolicyAlways)
+	}
+
+	if l.PreStop != nil {
+		container.Lifecycle = &corev1.Lifecycle{
+			PreStop: l.PreStop.DeepCopy(),
+		}
+	}
+}
+
+// coordinateShutdown adds preStop hook delaying shutdown of given classic sidecar until other containers of the
+// Pod exit and extends termination grace period of the Pod, if configured.
+func (l *Lifecycle) coordinateShutdown(
+	pod *corev1.Pod,
+	sidecarIndex int,
+	container *corev1.Container,
+	requestOptions webhook.RequestOptions,
+) {
+	if l == nil {
+		return
+	}
+
+	if l.WaitForContainers && !isNativeSidecar(container) {
+		container.Lifecycle = &corev1.Lifecycle{
+			PreStop: &corev1.LifecycleHandler{
+				Exec: &corev1.ExecAction{
+					Command: []string{"/bin/sh", "-c", waitForPortsScript(appPorts(pod, sidecarIndex), l.maxShutdownDelay())},
+				},
+			},
+		}
+	}
+
+	minGracePeriod := l.TerminationGracePeriodSeconds
+	if minGracePeriod == nil {
+		return
+	}
+
+	gracePeriod := ptr.Deref(pod.Spec.TerminationGracePeriodSeconds, corev1.DefaultTerminationGracePeriodSeconds)
+	if gracePeriod >= *minGracePeriod {
+		return
+	}
+
+	requestOptions.AddPatch(webhook.SetTerminationGracePeriod(*minGracePeriod))
+	pod.Spec.TerminationGracePeriodSeconds = ptr.To(*minGracePeriod)
+}
+
+// isNativeSidecar returns true if given container is run as an init container kept running alongside regular
+// containers, which kubelet stops only after all regular containers exit.
+func isNativeSidecar(container *corev1.Container) bool {
+	return container.RestartPolicy != nil && *container.RestartPolicy == corev1.ContainerRestartPolicyAlways
+}
+
+// appPorts returns sorted TCP ports declared by regular containers of the Pod, except the sidecar.
+func appPorts(pod *corev1.Pod, sidecarIndex int) []int32 {
+	unique := map[int32]struct{}{}
+
+	for idx, c := range pod.Spec.Containers {
+		if idx == sidecarIndex {
+			continue
+		}
+
+		for _, p := range c.Ports {
+			if p.Protocol == "" || p.Protocol == corev1.ProtocolTCP {
+				unique[p.ContainerPort] = struct{}{}
+			}
+		}
+	}
+
+	ports := make([]int32, 0, len(unique))
+	for p := range unique {
+		ports = append(ports, p)
+	}
+
+	sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+
+	return ports
+}
+
+// waitForPortsScript returns shell script waiting until none of given ports is listening, for at most
+// given number of seconds. Containers of the Pod share network namespace, so listening sockets of other
+// containers are visible in /proc/net of the sidecar. Without ports, script waits for given number of seconds.
+//
+// Script requires "sh" and "sleep" in the sidecar image. If "date" or "grep" is missing or /proc/net/tcp cannot
+// be read, listening sockets cannot be checked, so script waits for given number of seconds as well, rather than
+// letting the sidecar stop early.
+func waitForPortsScript(ports []int32, maxSeconds int32) string {
+	if len(ports) == 0 {
+		return fmt.Sprintf("sleep %d", maxSeconds)
+	}
+
+	hexPorts := make([]string, 0, len(ports))
+	for _, p := range ports {
+		hexPorts = append(hexPorts, fmt.Sprintf("%04X", p))
+	}
+
+	// Socket state 0A is TCP_LISTEN.
+	pattern := fmt.Sprintf("^ *[0-9]+: [0-9A-F]+:(%s) [0-9A-F]+:[0-9A-F]+ 0A ", strings.Join(hexPorts, "|"))
+
+	return fmt.Sprintf(
+		"if ! command -v date >/dev/null || ! command -v grep >/dev/null || [ ! -r /proc/net/tcp ]; "+
+			"then exec sleep %d; fi; "+
+			"deadline=$(($(date +%%s) + %d)); "+
+			"while [ \"$(date +%%s)\" -lt \"$deadline\" ] && grep -qE '%s' /proc/net/tcp /proc/net/tcp6 2>/dev/null; "+
+			"do sleep 1; done",
+		maxSeconds, maxSeconds, pattern)
+}
+
+func (l *Lifecycle) validate(fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if l == nil {
+		return allErrs
+	}
+
+	if l.PreStop != nil {
+		handler := l.PreStop
+
+		if handler.Exec == nil && handler.HTTPGet == nil && handler.TCPSocket == nil && handler.Sleep == nil {
+			allErrs = append(allErrs, field.Required(fldPath.Child("preStop"), "handler must be set"))
+		}
+
+		if l.WaitForContainers && !l.NativeSidecar {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child("preStop"),
+				"preStop hook must not be set when waitForContainers is enabled for classic sidecar"))
+		}
+	}
+
+	if l.MaxShutdownDelaySeconds < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("maxShutdownDelaySeconds"), l.MaxShutdownDelaySeconds,
+			"must not be negative"))
+	}
+
+	gracePeriod := l.TerminationGracePeriodSeconds
+
+	switch {
+	case gracePeriod == nil:
+	case *gracePeriod < 0:
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("terminationGracePeriodSeconds"), *gracePeriod,
+			"must not be negative"))
+	case l.WaitForContainers && !l.NativeSidecar && *gracePeriod <= int64(l.maxShutdownDelay()):
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("terminationGracePeriodSeconds"), *gracePeriod,
+			"must be greater than maxShutdownDelaySeconds, so agent can stop after waiting for other containers"))
+	}
+
+	return allErrs
+}
@@ -0,0 +1,94 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// NamespaceLookupSourceCache is a value of "source" label of namespace lookups metric for lookups
+	// served from the cache.
+	NamespaceLookupSourceCache = "cache"
+
+	// NamespaceLookupSourceAPI is a value of "source" label of namespace lookups metric for lookups
+	// served by the API server.
+	NamespaceLookupSourceAPI = "api"
+)
+
+//nolint:gochecknoglobals // Metrics must be registered once per process.
+var namespaceLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "newrelic_infra_operator_namespace_lookups_total",
+	Help: "Number of Namespace lookups done for policy matching, partitioned by the source which served them.",
+}, []string{"source"})
+
+//nolint:gochecknoinits // Registering metrics with controller-runtime registry is done on package initialization.
+func init() {
+	metrics.Registry.MustRegister(namespaceLookups)
+}
+
+// NamespaceCache provides cached read access to Namespace objects.
+type NamespaceCache interface {
+	client.Reader
+
+	// HasSynced returns true when the cache has been populated, so reading from it does not block.
+	HasSynced() bool
+}
+
+// LooksUpNamespaces returns true if injector with given configuration fetches Namespaces of admitted Pods, which
+// happens when some policy uses namespaceSelector or Pod Security Admission labels are evaluated.
+func (config InjectorConfig) LooksUpNamespaces() bool {
+	if config.PodSecurityMode != "" {
+		return true
+	}
+
+	for _, policy := range config.Policies {
+		if policy.NamespaceSelector != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getNamespace fetches namespace object by name. Namespace is looked up in the cache first, if it is synced.
+// If it is not synced or Namespace is not found in it, e.g. because it has just been created, Namespace is
+// fetched from the API.
+func (i *injector) getNamespace(ctx context.Context, namespace string) (*corev1.Namespace, error) {
+	key := client.ObjectKey{
+		Name: namespace,
+	}
+
+	if i.namespaceCache != nil && i.namespaceCache.HasSynced() {
+		ns := &corev1.Namespace{}
+
+		err := i.namespaceCache.Get(ctx, key, ns)
+		if err == nil {
+			namespaceLookups.WithLabelValues(NamespaceLookupSourceCache).Inc()
+
+			return ns, nil
+		}
+
+		if !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("getting Namespace %q from cache: %w", namespace, err)
+		}
+	}
+
+	ns := &corev1.Namespace{}
+
+	namespaceLookups.WithLabelValues(NamespaceLookupSourceAPI).Inc()
+
+	if err := i.noCacheClient.Get(ctx, key, ns); err != nil {
+		return nil, fmt.Errorf("getting Namespace %q: %w", namespace, err)
+	}
+
+	return ns, nil
+}
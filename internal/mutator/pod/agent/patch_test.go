@@ -0,0 +1,337 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package agent_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	jsonpatchapply "github.com/evanphx/json-patch/v5"
+	"github.com/go-logr/logr/testr"
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/newrelic/newrelic-infra-operator/internal/mutator/pod/agent"
+	"github.com/newrelic/newrelic-infra-operator/internal/testutil"
+	"github.com/newrelic/newrelic-infra-operator/internal/webhook"
+)
+
+// Test_Mutation_patch verifies that applying JSON Patch operations emitted by the injector to the Pod from
+// admission request produces the same Pod as diffing the mutated Pod against the request object.
+//
+//nolint:funlen
+func Test_Mutation_patch(t *testing.T) {
+	t.Parallel()
+
+	ctx := testutil.ContextWithDeadline(t)
+
+	withIntegrations := func(config *agent.InjectorConfig) {
+		config.AgentConfig.Integrations = []agent.Integration{
+			{
+				Name:   "nri-redis",
+				Config: testIntegrationConfig,
+			},
+		}
+		config.AgentConfig.ConfigSelectors = []agent.ConfigSelector{
+			{
+				LabelSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "redis"}},
+				Integrations:  []string{"nri-redis"},
+			},
+		}
+	}
+
+	cases := map[string]struct {
+		pod    func() *corev1.Pod
+		config func(*agent.InjectorConfig)
+		dryRun bool
+		// injected, if set, modifies the Pod after it has been injected, before the webhook is invoked again.
+		injected func(*corev1.Pod)
+		// unchanged, if set, expects no patch operations to be emitted.
+		unchanged bool
+	}{
+		"Pod_with_empty_labels": {
+			pod: getEmptyPod,
+		},
+		"Pod_without_labels": {
+			pod: func() *corev1.Pod {
+				p := getEmptyPod()
+				p.Labels = nil
+
+				return p
+			},
+		},
+		"Pod_with_labels_annotations_volumes_and_multiple_containers": {
+			pod: func() *corev1.Pod {
+				p := getEmptyPod()
+				p.Labels["app.kubernetes.io/name"] = "foo"
+				p.Annotations = map[string]string{"foo~bar/baz": "qux"}
+				p.Spec.Volumes = []corev1.Volume{{Name: "data"}}
+				p.Spec.Containers = append(p.Spec.Containers, corev1.Container{
+					Name:  "bar",
+					Image: "bar",
+					Env:   []corev1.EnvVar{{Name: "FOO", Value: "bar"}},
+				})
+
+				return p
+			},
+		},
+		"Pod_with_termination_grace_period_extended_and_shutdown_coordinated": {
+			pod: func() *corev1.Pod {
+				p := getEmptyPod()
+				p.Spec.Containers[0].Ports = []corev1.ContainerPort{{ContainerPort: 8080}}
+
+				return p
+			},
+			config: func(config *agent.InjectorConfig) {
+				config.AgentConfig.Lifecycle = &agent.Lifecycle{
+					WaitForContainers:             true,
+					TerminationGracePeriodSeconds: ptr.To[int64](60),
+				}
+			},
+		},
+		"Pod_with_native_sidecar": {
+			pod: getEmptyPod,
+			config: func(config *agent.InjectorConfig) {
+				config.AgentConfig.Lifecycle = &agent.Lifecycle{NativeSidecar: true}
+			},
+		},
+		"Pod_with_init_containers_and_native_sidecar": {
+			pod: func() *corev1.Pod {
+				p := getEmptyPod()
+				p.Spec.InitContainers = []corev1.Container{{Name: "init", Image: "init"}}
+
+				return p
+			},
+			config: func(config *agent.InjectorConfig) {
+				config.AgentConfig.Lifecycle = &agent.Lifecycle{NativeSidecar: true}
+			},
+		},
+		"Pod_with_sidecar_sized_by_config_selector": {
+			pod: func() *corev1.Pod {
+				p := getEmptyPod()
+				p.Spec.Containers[0].Resources.Requests = corev1.ResourceList{
+					corev1.ResourceMemory: resource.MustParse("1Gi"),
+				}
+
+				return p
+			},
+			config: func(config *agent.InjectorConfig) {
+				config.AgentConfig.ConfigSelectors = []agent.ConfigSelector{
+					{
+						Sizing: &agent.ResourceSizing{
+							Fraction: &agent.FractionSizing{Percent: 10, LimitPercent: 200},
+						},
+					},
+				}
+			},
+		},
+		"Pod_with_custom_attributes_from_labels": {
+			pod: func() *corev1.Pod {
+				p := getEmptyPod()
+				p.Labels[customAttributeFromLabel] = customAttributeFromLabelValue
+
+				return p
+			},
+			config: func(config *agent.InjectorConfig) {
+				config.AgentConfig.CustomAttributes = agent.CustomAttributes{
+					{Name: customAttributeFromLabelName, FromLabel: customAttributeFromLabel},
+				}
+			},
+		},
+		"Pod_matching_config_selector_with_integrations": {
+			pod: func() *corev1.Pod {
+				p := getEmptyPod()
+				p.Labels["app"] = "redis"
+				p.Spec.Containers[0].Ports = []corev1.ContainerPort{{Name: "redis", ContainerPort: 6379}}
+
+				return p
+			},
+			config: withIntegrations,
+		},
+		"Pod_with_configured_sidecar_volumes": {
+			pod: func() *corev1.Pod {
+				p := getEmptyPod()
+				p.Spec.Volumes = []corev1.Volume{{Name: "data"}}
+
+				return p
+			},
+			config: func(config *agent.InjectorConfig) {
+				config.AgentConfig.Volumes = agent.SidecarVolumes{
+					EmptyDirs: map[string]corev1.EmptyDirVolumeSource{
+						"tmpfs-tmp-injected": {Medium: corev1.StorageMediumMemory},
+					},
+					ExtraVolumes:      []corev1.Volume{{Name: "extra"}},
+					ExtraVolumeMounts: []corev1.VolumeMount{{Name: "extra", MountPath: "/etc/extra"}},
+				}
+			},
+		},
+		"Pod_not_matching_any_policy": {
+			pod: getEmptyPod,
+			config: func(config *agent.InjectorConfig) {
+				config.Policies = []agent.InjectionPolicy{{NamespaceName: "other"}}
+			},
+		},
+		"Pod_created_with_dry_run": {
+			pod:    getEmptyPod,
+			dryRun: true,
+		},
+		"Pod_with_volume_colliding_with_sidecar_volume_using_suffix_strategy": {
+			pod: func() *corev1.Pod {
+				p := getEmptyPod()
+				p.Spec.Volumes = []corev1.Volume{{Name: "tmpfs-data-injected"}}
+
+				return p
+			},
+			config: func(config *agent.InjectorConfig) {
+				config.CollisionStrategy = agent.CollisionStrategySuffix
+			},
+		},
+		"Pod_already_injected": {
+			pod:       getEmptyPod,
+			injected:  func(*corev1.Pod) {},
+			unchanged: true,
+		},
+		"Pod_already_injected_with_config_selector_extra_env_vars": {
+			pod: getEmptyPod,
+			config: func(config *agent.InjectorConfig) {
+				config.AgentConfig.ConfigSelectors = []agent.ConfigSelector{
+					{
+						ExtraEnvVars: map[string]string{
+							"NRIA_VERBOSE":             "1",
+							"NRIA_LOG_FORMAT":          "json",
+							"NRIA_LOG_LEVEL":           "debug",
+							"NRIA_HTTP_SERVER_ENABLED": "true",
+							"NRIA_MAX_PROCS":           "1",
+						},
+					},
+				}
+			},
+			injected:  func(*corev1.Pod) {},
+			unchanged: true,
+		},
+		"Pod_already_injected_with_native_sidecar": {
+			pod: getEmptyPod,
+			config: func(config *agent.InjectorConfig) {
+				config.AgentConfig.Lifecycle = &agent.Lifecycle{NativeSidecar: true}
+			},
+			injected:  func(*corev1.Pod) {},
+			unchanged: true,
+		},
+		"Pod_already_injected_with_modified_native_sidecar": {
+			pod: getEmptyPod,
+			config: func(config *agent.InjectorConfig) {
+				config.AgentConfig.Lifecycle = &agent.Lifecycle{NativeSidecar: true}
+			},
+			injected: func(p *corev1.Pod) {
+				p.Spec.InitContainers[0].Image = "foo:bar"
+			},
+		},
+		"Pod_already_injected_with_modified_sidecar_and_volumes": {
+			pod: getEmptyPod,
+			injected: func(p *corev1.Pod) {
+				p.Spec.Containers[1].Image = "foo:bar"
+				p.Spec.Volumes = p.Spec.Volumes[1:]
+				p.Spec.Volumes[0].VolumeSource = corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/"}}
+			},
+		},
+		"Pod_already_injected_without_injected_label": {
+			pod: getEmptyPod,
+			injected: func(p *corev1.Pod) {
+				delete(p.Labels, agent.InjectedLabel)
+			},
+		},
+	}
+
+	for testCaseName, testData := range cases {
+		testData := testData
+
+		t.Run(testCaseName, func(t *testing.T) {
+			t.Parallel()
+
+			c := fake.NewClientBuilder().WithObjects(getCRB(testResourcePrefix)).Build()
+			config := getConfig()
+
+			if testData.config != nil {
+				testData.config(config)
+			}
+
+			i, err := config.New(c, c, testr.New(t))
+			if err != nil {
+				t.Fatalf("creating injector: %v", err)
+			}
+
+			pod := testData.pod()
+
+			if testData.injected != nil {
+				if err := i.Mutate(ctx, pod, webhook.RequestOptions{Namespace: testNamespace}); err != nil {
+					t.Fatalf("injecting Pod: %v", err)
+				}
+
+				testData.injected(pod)
+			}
+
+			raw, err := json.Marshal(pod)
+			if err != nil {
+				t.Fatalf("marshaling Pod: %v", err)
+			}
+
+			requestOptions := webhook.RequestOptions{
+				Namespace: testNamespace,
+				DryRun:    testData.dryRun,
+				Patch:     &webhook.Patch{},
+			}
+
+			if err := i.Mutate(ctx, pod, requestOptions); err != nil {
+				t.Fatalf("mutating Pod: %v", err)
+			}
+
+			if operations := requestOptions.Patch.Operations; testData.unchanged && len(operations) > 0 {
+				t.Fatalf("expected no patch operations, got: %v", operations)
+			}
+
+			mutated, err := json.Marshal(pod)
+			if err != nil {
+				t.Fatalf("marshaling mutated Pod: %v", err)
+			}
+
+			expected := applyPatch(t, raw, admission.PatchResponseFromRaw(raw, mutated).Patches)
+			patched := applyPatch(t, raw, requestOptions.Patch.Operations)
+
+			if diff := cmp.Diff(expected, patched); diff != "" {
+				t.Fatalf("unexpected Pod after applying patch: %s", diff)
+			}
+		})
+	}
+}
+
+func applyPatch(t *testing.T, raw []byte, operations any) *corev1.Pod {
+	t.Helper()
+
+	operationsBytes, err := json.Marshal(operations)
+	if err != nil {
+		t.Fatalf("marshaling patch: %v", err)
+	}
+
+	patch, err := jsonpatchapply.DecodePatch(operationsBytes)
+	if err != nil {
+		t.Fatalf("decoding patch: %v", err)
+	}
+
+	patched, err := patch.Apply(raw)
+	if err != nil {
+		t.Fatalf("applying patch %s: %v", operationsBytes, err)
+	}
+
+	pod := &corev1.Pod{}
+	if err := json.Unmarshal(patched, pod); err != nil {
+		t.Fatalf("unmarshaling patched Pod: %v", err)
+	}
+
+	return pod
+}
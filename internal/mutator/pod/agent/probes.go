@@ -0,0 +1,186 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+const (
+	// DefaultStatusServerPort is a default port of agent's status server, which serves health endpoint.
+	DefaultStatusServerPort = 8003
+
+	// HealthEndpointPath is a path of agent's health endpoint probed by default.
+	HealthEndpointPath = "/v1/status/health"
+
+	envStatusServerEnabled = "NRIA_STATUS_SERVER_ENABLED"
+	envStatusServerPort    = "NRIA_STATUS_SERVER_PORT"
+)
+
+// Probes configures liveness, readiness and startup probes of the sidecar. When enabled, agent's status server
+// gets enabled and liveness and startup probes which are not configured check its health endpoint. Configured
+// probes without a handler check health endpoint as well, with timing taken from the configured probe.
+type Probes struct {
+	Enabled bool `json:"enabled"`
+	// StatusServerPort is a port on which agent's status server listens. Defaults to DefaultStatusServerPort.
+	StatusServerPort int32         `json:"statusServerPort"`
+	Liveness         *corev1.Probe `json:"liveness"`
+	// Readiness probe is only added when configured. Pod is not Ready while any of its containers is not ready,
+	// so failing agent would otherwise remove the Pod from Service endpoints, making monitoring affect traffic
+	// of the application. Liveness probe restarts failing agent without affecting Pod readiness.
+	Readiness *corev1.Probe `json:"readiness"`
+	Startup   *corev1.Probe `json:"startup"`
+}
+
+func (p *Probes) port() int32 {
+	if p.StatusServerPort == 0 {
+		return DefaultStatusServerPort
+	}
+
+	return p.StatusServerPort
+}
+
+// apply sets probes and status server environment variables on given container. Nil probes leave container
+// unchanged, disabled probes remove them.
+func (p *Probes) apply(container *corev1.Container) {
+	if p == nil {
+		return
+	}
+
+	env := []corev1.EnvVar{}
+
+	for _, e := range container.Env {
+		if e.Name != envStatusServerEnabled && e.Name != envStatusServerPort {
+			env = append(env, e)
+		}
+	}
+
+	container.Env = env
+	container.LivenessProbe = nil
+	container.ReadinessProbe = nil
+	container.StartupProbe = nil
+
+	if !p.Enabled {
+		return
+	}
+
+	port := p.port()
+
+	container.Env = append(container.Env,
+		corev1.EnvVar{Name: envStatusServerEnabled, Value: "true"},
+		corev1.EnvVar{Name: envStatusServerPort, Value: strconv.Itoa(int(port))},
+	)
+
+	container.LivenessProbe = withDefaults(p.Liveness, &corev1.Probe{
+		PeriodSeconds:    30,
+		TimeoutSeconds:   5,
+		FailureThreshold: 3,
+	}, port)
+
+	if p.Readiness != nil {
+		container.ReadinessProbe = withDefaults(p.Readiness, &corev1.Probe{
+			PeriodSeconds:    10,
+			TimeoutSeconds:   5,
+			FailureThreshold: 3,
+		}, port)
+	}
+
+	container.StartupProbe = withDefaults(p.Startup, &corev1.Probe{
+		PeriodSeconds:    5,
+		TimeoutSeconds:   5,
+		FailureThreshold: 24,
+	}, port)
+}
+
+// withDefaults returns copy of given probe with handler and zero timing fields taken from given defaults.
+// Handler defaults to HTTP request to agent's health endpoint.
+func withDefaults(probe, defaults *corev1.Probe, port int32) *corev1.Probe {
+	defaults.SuccessThreshold = 1
+	defaults.HTTPGet = &corev1.HTTPGetAction{
+		Path:   HealthEndpointPath,
+		Port:   intstr.FromInt32(port),
+		Scheme: corev1.URISchemeHTTP,
+	}
+
+	if probe == nil {
+		return defaults
+	}
+
+	p := probe.DeepCopy()
+
+	if p.Exec == nil && p.HTTPGet == nil && p.TCPSocket == nil && p.GRPC == nil {
+		p.ProbeHandler = defaults.ProbeHandler
+	}
+
+	for _, f := range []struct{ value, fallback *int32 }{
+		{&p.PeriodSeconds, &defaults.PeriodSeconds},
+		{&p.TimeoutSeconds, &defaults.TimeoutSeconds},
+		{&p.FailureThreshold, &defaults.FailureThreshold},
+		{&p.SuccessThreshold, &defaults.SuccessThreshold},
+	} {
+		if *f.value == 0 {
+			*f.value = *f.fallback
+		}
+	}
+
+	return p
+}
+
+func (p *Probes) validate(fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if p == nil {
+		return allErrs
+	}
+
+	if p.StatusServerPort < 0 || p.StatusServerPort > 65535 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("statusServerPort"), p.StatusServerPort,
+			"must be a valid port number"))
+	}
+
+	for _, named := range []struct {
+		name  string
+		probe *corev1.Probe
+	}{
+		{"liveness", p.Liveness},
+		{"readiness", p.Readiness},
+		{"startup", p.Startup},
+	} {
+		if named.probe != nil {
+			allErrs = append(allErrs, validateProbe(named.probe, named.name != "readiness", fldPath.Child(named.name))...)
+		}
+	}
+
+	return allErrs
+}
+
+func validateProbe(probe *corev1.Probe, singleSuccess bool, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	for _, f := range []struct {
+		name  string
+		value int32
+	}{
+		{"initialDelaySeconds", probe.InitialDelaySeconds},
+		{"periodSeconds", probe.PeriodSeconds},
+		{"timeoutSeconds", probe.TimeoutSeconds},
+		{"successThreshold", probe.SuccessThreshold},
+		{"failureThreshold", probe.FailureThreshold},
+	} {
+		if f.value < 0 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child(f.name), f.value, "must not be negative"))
+		}
+	}
+
+	// Kubernetes requires liveness and startup probes to have success threshold of 1.
+	if singleSuccess && probe.SuccessThreshold > 1 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("successThreshold"), probe.SuccessThreshold, "must be 1"))
+	}
+
+	return allErrs
+}
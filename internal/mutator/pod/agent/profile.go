@@ -0,0 +1,172 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// AgentProfile bundles sidecar configuration, which can be referenced by name from injection policies, so Pods
+// matched by different policies get different sidecars injected.
+//
+// Image, PodSecurityContext and CustomAttributes which are not set are taken from the global agent configuration.
+// Config selectors are not applied to Pods injected using a profile.
+type AgentProfile struct {
+	Name                 string                       `json:"name"`
+	Image                *Image                       `json:"image"`
+	ResourceRequirements *corev1.ResourceRequirements `json:"resourceRequirements"`
+	ExtraEnvVars         map[string]string            `json:"extraEnvVars"`
+	CustomAttributes     CustomAttributes             `json:"customAttributes"`
+	PodSecurityContext   *PodSecurityContext          `json:"podSecurityContext"`
+}
+
+// sidecar holds pre-built sidecar container with configuration required to finish it for specific Pod.
+type sidecar struct {
+	container        corev1.Container
+	customAttributes CustomAttributes
+	hash             string
+	integrations     []Integration
+	sizing           *ResourceSizing
+}
+
+func (config InjectorConfig) validateProfiles(fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	profileNames := map[string]struct{}{}
+	profilesPath := fldPath.Child("profiles")
+
+	for i, profile := range config.Profiles {
+		profilePath := profilesPath.Index(i)
+
+		switch _, duplicate := profileNames[profile.Name]; {
+		case profile.Name == "":
+			allErrs = append(allErrs, field.Required(profilePath.Child("name"), "profile name must be set"))
+		case duplicate:
+			allErrs = append(allErrs, field.Duplicate(profilePath.Child("name"), profile.Name))
+		}
+
+		profileNames[profile.Name] = struct{}{}
+
+		if profile.Image != nil {
+			allErrs = append(allErrs, profile.Image.validate(profilePath.Child("image"))...)
+		}
+
+		allErrs = append(allErrs, profile.CustomAttributes.validate(profilePath.Child("customAttributes"))...)
+	}
+
+	for i, policy := range config.Policies {
+		if policy.Profile == "" {
+			continue
+		}
+
+		profilePath := fldPath.Child("policies").Index(i).Child("profile")
+
+		if policy.Action == PolicyActionExclude {
+			allErrs = append(allErrs, field.Forbidden(profilePath,
+				fmt.Sprintf("policy with %q action must not reference a profile", PolicyActionExclude)))
+
+			continue
+		}
+
+		if _, ok := profileNames[policy.Profile]; !ok {
+			allErrs = append(allErrs, field.NotFound(profilePath, policy.Profile))
+		}
+	}
+
+	return allErrs
+}
+
+// buildProfiles builds sidecar for each configured profile and links them to the policies referencing them.
+func (config *InjectorConfig) buildProfiles(licenseSecretName string, logger logr.Logger) error {
+	sidecars := map[string]*sidecar{}
+
+	for _, profile := range config.Profiles {
+		s, err := config.profileSidecar(profile, licenseSecretName)
+		if err != nil {
+			return fmt.Errorf("building sidecar for profile %q: %w", profile.Name, err)
+		}
+
+		logger.Info("label value for Pod with profile", "InjectedLabel", InjectedLabel,
+			"profile", profile.Name, "hash", s.hash)
+
+		sidecars[profile.Name] = s
+	}
+
+	for i, policy := range config.Policies {
+		if policy.Profile != "" {
+			config.Policies[i].profile = sidecars[policy.Profile]
+		}
+	}
+
+	return nil
+}
+
+// profileConfig returns configuration with global agent configuration overridden by given profile.
+func (config InjectorConfig) profileConfig(profile AgentProfile) InjectorConfig {
+	profileConfig := config
+
+	if profile.Image != nil {
+		profileConfig.AgentConfig.Image = *profile.Image
+	}
+
+	if profile.PodSecurityContext != nil {
+		profileConfig.AgentConfig.PodSecurityContext = *profile.PodSecurityContext
+	}
+
+	if profile.CustomAttributes != nil {
+		profileConfig.AgentConfig.CustomAttributes = append(CustomAttributes{}, profile.CustomAttributes...)
+		profileConfig.AgentConfig.CustomAttributes = append(profileConfig.AgentConfig.CustomAttributes,
+			clusterNameCustomAttribute(config.ClusterName))
+	}
+
+	return profileConfig
+}
+
+func (config InjectorConfig) profileSidecar(profile AgentProfile, licenseSecretName string) (*sidecar, error) {
+	profileConfig := config.profileConfig(profile)
+	container := profileConfig.container(licenseSecretName)
+
+	if profile.ResourceRequirements != nil {
+		container.Resources = *profile.ResourceRequirements
+	}
+
+	envNames := make([]string, 0, len(profile.ExtraEnvVars))
+	for k := range profile.ExtraEnvVars {
+		envNames = append(envNames, k)
+	}
+
+	sort.Strings(envNames)
+
+	for _, k := range envNames {
+		container.Env = append(container.Env, corev1.EnvVar{Name: k, Value: profile.ExtraEnvVars[k]})
+	}
+
+	configHash := &configHash{
+		CustomAttributes:     profileConfig.AgentConfig.CustomAttributes,
+		ResourcePrefix:       config.ResourcePrefix,
+		ClusterName:          config.ClusterName,
+		Image:                profileConfig.AgentConfig.Image,
+		PodSecurityContext:   profileConfig.AgentConfig.PodSecurityContext,
+		ResourceRequirements: profile.ResourceRequirements,
+		ExtraEnvVars:         profile.ExtraEnvVars,
+		Volumes:              config.AgentConfig.Volumes.hashed(),
+		Lifecycle:            config.AgentConfig.Lifecycle,
+		Container:            container,
+	}
+
+	hash, err := configHash.calculate()
+	if err != nil {
+		return nil, fmt.Errorf("calculating config hash: %w", err)
+	}
+
+	return &sidecar{
+		container:        container,
+		customAttributes: profileConfig.AgentConfig.CustomAttributes,
+		hash:             hash,
+	}, nil
+}
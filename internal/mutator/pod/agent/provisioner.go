@@ -0,0 +1,234 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// DefaultAsyncDependenciesWait is a default time admission waits for dependencies of the Pod to be provisioned.
+	DefaultAsyncDependenciesWait = 2 * time.Second
+
+	// DefaultAsyncDependenciesWorkers is a default number of workers provisioning dependencies.
+	DefaultAsyncDependenciesWorkers = 2
+
+	// DefaultAsyncDependenciesResyncPeriod is a default time after which provisioned dependencies are provisioned
+	// again when the next Pod using them is admitted.
+	DefaultAsyncDependenciesResyncPeriod = 10 * time.Minute
+
+	workerRestartInterval = time.Second
+)
+
+// AsyncDependenciesConfig configures provisioning of sidecar dependencies like license Secret, ClusterRoleBinding
+// subject and integrations ConfigMap outside of admission requests.
+//
+// When enabled, admission only enqueues dependencies which has not been provisioned yet by this operator instance and
+// waits at most Wait for them. If they are not ready by then, Pod is admitted anyway, as the sidecar will be able
+// to start once they get provisioned.
+//
+// Dependencies provisioned more than ResyncPeriod ago are enqueued again, so objects removed in the meantime, e.g.
+// together with re-created Namespace, get restored.
+type AsyncDependenciesConfig struct {
+	Enabled      bool            `json:"enabled"`
+	Wait         metav1.Duration `json:"wait"`
+	Workers      int             `json:"workers"`
+	ResyncPeriod metav1.Duration `json:"resyncPeriod"`
+}
+
+// dependencies identifies set of objects required by the sidecar injected into Pod.
+type dependencies struct {
+	namespace      string
+	serviceAccount string
+	configMap      string
+}
+
+type provisionRequest struct {
+	configMap *corev1.ConfigMap
+	done      chan struct{}
+	// provisionedAt is set when dependencies get provisioned.
+	provisionedAt time.Time
+}
+
+// expired returns true if dependencies were provisioned longer than given period before given time.
+func (r *provisionRequest) expired(now time.Time, period time.Duration) bool {
+	return !r.provisionedAt.IsZero() && now.Sub(r.provisionedAt) >= period
+}
+
+// provisioner provisions sidecar dependencies using a rate limited work queue.
+type provisioner struct {
+	queue     workqueue.TypedRateLimitingInterface[dependencies]
+	provision func(context.Context, dependencies, *corev1.ConfigMap) error
+	wait      time.Duration
+	workers   int
+	resync    time.Duration
+	logger    logr.Logger
+
+	lock     sync.Mutex
+	requests map[dependencies]*provisionRequest
+}
+
+func (config AsyncDependenciesConfig) validate(fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if config.Wait.Duration < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("wait"), config.Wait.String(), "must not be negative"))
+	}
+
+	if config.Workers < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("workers"), config.Workers, "must not be negative"))
+	}
+
+	if config.ResyncPeriod.Duration < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("resyncPeriod"), config.ResyncPeriod.String(),
+			"must not be negative"))
+	}
+
+	return allErrs
+}
+
+func (config AsyncDependenciesConfig) newProvisioner(
+	provision func(context.Context, dependencies, *corev1.ConfigMap) error,
+	logger logr.Logger,
+) *provisioner {
+	if !config.Enabled {
+		return nil
+	}
+
+	p := &provisioner{
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[dependencies](),
+			workqueue.TypedRateLimitingQueueConfig[dependencies]{
+				Name: "sidecar-dependencies",
+			},
+		),
+		provision: provision,
+		wait:      config.Wait.Duration,
+		workers:   config.Workers,
+		resync:    config.ResyncPeriod.Duration,
+		logger:    logger,
+		requests:  map[dependencies]*provisionRequest{},
+	}
+
+	if p.wait == 0 {
+		p.wait = DefaultAsyncDependenciesWait
+	}
+
+	if p.workers == 0 {
+		p.workers = DefaultAsyncDependenciesWorkers
+	}
+
+	if p.resync == 0 {
+		p.resync = DefaultAsyncDependenciesResyncPeriod
+	}
+
+	return p
+}
+
+// enqueue schedules provisioning of given dependencies, if they have not been scheduled before or they were
+// provisioned longer than resync period ago, and waits until they are provisioned, configured wait time passes
+// or given context gets cancelled.
+func (p *provisioner) enqueue(ctx context.Context, deps dependencies, configMap *corev1.ConfigMap) {
+	p.lock.Lock()
+
+	request, ok := p.requests[deps]
+	if !ok || request.expired(time.Now(), p.resync) {
+		request = &provisionRequest{
+			configMap: configMap,
+			done:      make(chan struct{}),
+		}
+
+		p.requests[deps] = request
+		p.queue.Add(deps)
+	}
+
+	p.lock.Unlock()
+
+	timer := time.NewTimer(p.wait)
+	defer timer.Stop()
+
+	select {
+	case <-request.done:
+	case <-timer.C:
+		requestLogger(ctx, p.logger).Info("Admitting Pod before its dependencies got provisioned",
+			"namespace", deps.namespace, "serviceAccount", deps.serviceAccount)
+	case <-ctx.Done():
+	}
+}
+
+// Start runs provisioning workers until given context is cancelled.
+func (p *provisioner) Start(ctx context.Context) error {
+	defer p.queue.ShutDown()
+
+	for range p.workers {
+		go wait.UntilWithContext(ctx, p.runWorker, workerRestartInterval)
+	}
+
+	go wait.UntilWithContext(ctx, p.evictExpired, p.resync)
+
+	<-ctx.Done()
+
+	return nil
+}
+
+func (p *provisioner) runWorker(ctx context.Context) {
+	for p.processNextItem(ctx) {
+	}
+}
+
+func (p *provisioner) processNextItem(ctx context.Context) bool {
+	deps, shutdown := p.queue.Get()
+	if shutdown {
+		return false
+	}
+
+	defer p.queue.Done(deps)
+
+	p.lock.Lock()
+	request := p.requests[deps]
+	p.lock.Unlock()
+
+	if err := p.provision(ctx, deps, request.configMap); err != nil {
+		p.logger.Error(err, "Provisioning sidecar dependencies failed, retrying",
+			"namespace", deps.namespace, "serviceAccount", deps.serviceAccount)
+
+		p.queue.AddRateLimited(deps)
+
+		return true
+	}
+
+	p.queue.Forget(deps)
+
+	p.lock.Lock()
+	request.provisionedAt = time.Now()
+	p.lock.Unlock()
+
+	close(request.done)
+
+	return true
+}
+
+// evictExpired forgets dependencies provisioned longer than resync period ago, so requests for Namespaces and
+// ServiceAccounts which are no longer used do not accumulate.
+func (p *provisioner) evictExpired(_ context.Context) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	now := time.Now()
+
+	for deps, request := range p.requests {
+		if request.expired(now, p.resync) {
+			delete(p.requests, deps)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/newrelic/newrelic-infra-operator/internal/webhook"
+)
+
+const (
+	// ResourceQuotaPolicyShrink reduces sidecar resources to fit into LimitRanges and remaining ResourceQuotas
+	// of the Namespace. If sidecar cannot fit, injection is skipped.
+	ResourceQuotaPolicyShrink = "shrink"
+
+	// ResourceQuotaPolicySkip admits the Pod without the sidecar if sidecar does not fit into LimitRanges and
+	// remaining ResourceQuotas of the Namespace.
+	ResourceQuotaPolicySkip = "skip"
+
+	// ResourceQuotaPolicyProceed injects the sidecar even if it does not fit, so Pod creation may fail.
+	ResourceQuotaPolicyProceed = "proceed"
+
+	// ResourceQuotaExceededReason is the reason of the event emitted when injection is skipped.
+	ResourceQuotaExceededReason = "SidecarExceedsResourceQuota"
+
+	// ResourceQuotaDecisionFits is a value of "decision" label of resource quota decisions metric for sidecars
+	// fitting into Namespace constraints.
+	ResourceQuotaDecisionFits = "fits"
+
+	// ResourceQuotaDecisionShrunk is a value of "decision" label of resource quota decisions metric for sidecars
+	// with resources reduced to fit into Namespace constraints.
+	ResourceQuotaDecisionShrunk = "shrunk"
+
+	// ResourceQuotaDecisionSkipped is a value of "decision" label of resource quota decisions metric for Pods
+	// admitted without the sidecar.
+	ResourceQuotaDecisionSkipped = "skipped"
+
+	// ResourceQuotaDecisionProceeded is a value of "decision" label of resource quota decisions metric for
+	// sidecars injected despite not fitting into Namespace constraints.
+	ResourceQuotaDecisionProceeded = "proceeded"
+)
+
+//nolint:gochecknoglobals // Metrics must be registered once per process.
+var resourceQuotaDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "newrelic_infra_operator_resource_quota_decisions_total",
+	Help: "Number of sidecars checked against Namespace LimitRanges and ResourceQuotas, partitioned by decision.",
+}, []string{"decision"})
+
+//nolint:gochecknoinits // Registering metrics with controller-runtime registry is done on package initialization.
+func init() {
+	metrics.Registry.MustRegister(resourceQuotaDecisions)
+}
+
+// checkResourceQuota checks if given sidecar fits into LimitRanges and remaining ResourceQuotas of the Pod's
+// Namespace and handles it according to the configured policy. If injection should be skipped, the reason
+// is returned.
+func (i *injector) checkResourceQuota(
+	pod *corev1.Pod,
+	ns *corev1.Namespace,
+	sidecarIndex int,
+	constraints *resourceConstraints,
+	container *corev1.Container,
+	requestOptions webhook.RequestOptions,
+) string {
+	policy := i.config.ResourceQuotaPolicy
+	if policy == "" || constraints == nil {
+		return ""
+	}
+
+	otherResources := podResources(pod, sidecarIndex)
+
+	violations := constraints.violations(container.Resources, otherResources)
+	if len(violations) == 0 {
+		resourceQuotaDecisions.WithLabelValues(ResourceQuotaDecisionFits).Inc()
+
+		return ""
+	}
+
+	message := fmt.Sprintf("sidecar does not fit into resource constraints of Namespace %q: %s",
+		ns.Name, strings.Join(violations, "; "))
+
+	switch policy {
+	case ResourceQuotaPolicyProceed:
+		resourceQuotaDecisions.WithLabelValues(ResourceQuotaDecisionProceeded).Inc()
+
+		return ""
+	case ResourceQuotaPolicyShrink:
+		shrunk, _ := constraints.constrain(container.Resources, otherResources)
+
+		if len(constraints.violations(shrunk, otherResources)) == 0 {
+			resourceQuotaDecisions.WithLabelValues(ResourceQuotaDecisionShrunk).Inc()
+
+			container.Resources = shrunk
+
+			return ""
+		}
+
+		message += ", shrinking sidecar resources is not sufficient"
+	}
+
+	resourceQuotaDecisions.WithLabelValues(ResourceQuotaDecisionSkipped).Inc()
+
+	i.recordSkipEvent(pod, ns, ResourceQuotaExceededReason, message, requestOptions)
+
+	return message
+}
+
+// violations returns descriptions of LimitRange and ResourceQuota constraints violated by the sidecar with
+// given resources, when admitted together with given resources of other containers of the Pod.
+//
+// Like in constrain, only quotas without scopes are considered.
+func (c *resourceConstraints) violations(
+	resources corev1.ResourceRequirements,
+	podResources corev1.ResourceRequirements,
+) []string {
+	violations := []string{}
+
+	for _, limitRange := range c.limitRanges {
+		for _, item := range limitRange.Spec.Limits {
+			if item.Type != corev1.LimitTypeContainer {
+				continue
+			}
+
+			for _, v := range limitRangeItemViolations(resources, item) {
+				violations = append(violations, fmt.Sprintf("LimitRange/%s: %s", limitRange.Name, v))
+			}
+		}
+	}
+
+	// Quotas are checked against resources defaulted by LimitRange admission, which runs before the quota one.
+	defaulted := c.withLimitRangeDefaults(resources)
+
+	for _, quota := range c.quotas {
+		if len(quota.Spec.Scopes) > 0 || quota.Spec.ScopeSelector != nil {
+			continue
+		}
+
+		for _, v := range quotaViolations(defaulted, quota, podResources) {
+			violations = append(violations, fmt.Sprintf("ResourceQuota/%s: %s", quota.Name, v))
+		}
+	}
+
+	return violations
+}
+
+// withLimitRangeDefaults returns given resources with requests and limits not set by the sidecar defaulted like
+// by LimitRange admission. Requests not set by LimitRanges default to limits, like in Pod defaulting.
+func (c *resourceConstraints) withLimitRangeDefaults(
+	resources corev1.ResourceRequirements,
+) corev1.ResourceRequirements {
+	defaulted := *resources.DeepCopy()
+
+	for _, limitRange := range c.limitRanges {
+		for _, item := range limitRange.Spec.Limits {
+			if item.Type != corev1.LimitTypeContainer {
+				continue
+			}
+
+			for _, defaults := range []struct {
+				list     *corev1.ResourceList
+				defaults corev1.ResourceList
+			}{
+				{&defaulted.Requests, item.DefaultRequest},
+				{&defaulted.Limits, item.Default},
+			} {
+				for name, quantity := range defaults.defaults {
+					if _, ok := (*defaults.list)[name]; !ok {
+						setQuantity(defaults.list, name, quantity)
+					}
+				}
+			}
+		}
+	}
+
+	for name, limit := range defaulted.Limits {
+		if _, ok := defaulted.Requests[name]; !ok {
+			setQuantity(&defaulted.Requests, name, limit)
+		}
+	}
+
+	return defaulted
+}
+
+func limitRangeItemViolations(resources corev1.ResourceRequirements, item corev1.LimitRangeItem) []string {
+	violations := []string{}
+
+	for _, list := range []struct {
+		kind      string
+		resources corev1.ResourceList
+	}{
+		{"request", resources.Requests},
+		{"limit", resources.Limits},
+	} {
+		for name, quantity := range list.resources {
+			if min, ok := item.Min[name]; ok && quantity.Cmp(min) < 0 {
+				violations = append(violations, fmt.Sprintf("%s %s %s is below minimum %s",
+					name, list.kind, quantity.String(), min.String()))
+			}
+
+			if max, ok := item.Max[name]; ok && quantity.Cmp(max) > 0 {
+				violations = append(violations, fmt.Sprintf("%s %s %s exceeds maximum %s",
+					name, list.kind, quantity.String(), max.String()))
+			}
+		}
+	}
+
+	for name, max := range item.Max {
+		if _, hasLimit := resources.Limits[name]; !hasLimit {
+			if _, hasDefault := item.Default[name]; !hasDefault {
+				violations = append(violations, fmt.Sprintf("%s limit must be set, maximum is %s", name, max.String()))
+			}
+		}
+	}
+
+	for name, defaultLimit := range item.Default {
+		request, hasRequest := resources.Requests[name]
+
+		if _, hasLimit := resources.Limits[name]; !hasLimit && hasRequest && request.Cmp(defaultLimit) > 0 {
+			violations = append(violations, fmt.Sprintf("%s request %s exceeds default limit %s",
+				name, request.String(), defaultLimit.String()))
+		}
+	}
+
+	for name, ratio := range item.MaxLimitRequestRatio {
+		request, hasRequest := resources.Requests[name]
+		limit, hasLimit := resources.Limits[name]
+
+		if hasRequest && hasLimit && limit.Cmp(scaled(name, request, ratio.MilliValue(), 1000)) > 0 {
+			violations = append(violations, fmt.Sprintf("%s limit to request ratio exceeds maximum %s",
+				name, ratio.String()))
+		}
+	}
+
+	sort.Strings(violations)
+
+	return violations
+}
+
+func quotaViolations(
+	resources corev1.ResourceRequirements,
+	quota corev1.ResourceQuota,
+	podResources corev1.ResourceRequirements,
+) []string {
+	violations := []string{}
+
+	for quotaName, hard := range quota.Spec.Hard {
+		name, limits := quotaResource(quotaName)
+		if name == "" {
+			continue
+		}
+
+		list, podList := resources.Requests, podResources.Requests
+		if limits {
+			list, podList = resources.Limits, podResources.Limits
+		}
+
+		quantity, ok := list[name]
+		if !ok {
+			// Quota admission rejects Pods with containers not specifying tracked compute resources.
+			if requiredByQuota(name) {
+				violations = append(violations, fmt.Sprintf("%s must be set", quotaName))
+			}
+
+			continue
+		}
+
+		remaining := hard.DeepCopy()
+		remaining.Sub(quota.Status.Used[quotaName])
+		remaining.Sub(podList[name])
+
+		if quantity.Cmp(remaining) > 0 {
+			if remaining.Sign() < 0 {
+				remaining = *resource.NewQuantity(0, remaining.Format)
+			}
+
+			violations = append(violations, fmt.Sprintf("%s %s exceeds remaining %s",
+				quotaName, quantity.String(), remaining.String()))
+		}
+	}
+
+	sort.Strings(violations)
+
+	return violations
+}
+
+// requiredByQuota returns true if quota admission rejects containers not specifying given resource, when it is
+// tracked by a quota. Containers not specifying other resources, e.g. extended ones, are admitted.
+func requiredByQuota(name corev1.ResourceName) bool {
+	switch name {
+	case corev1.ResourceCPU, corev1.ResourceMemory, corev1.ResourceEphemeralStorage:
+		return true
+	default:
+		return false
+	}
+}
@@ -8,10 +8,13 @@ import (
 	"context"
 	"fmt"
 
+	"go.opentelemetry.io/otel/trace"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/newrelic/newrelic-infra-operator/internal/tracing"
 )
 
 const (
@@ -23,14 +26,17 @@ const (
 
 // ensureLicenseSecretExistence assures that the license secret exists and it is well configured, otherwise patches the
 // existing object or create a new one.
-func (i *injector) ensureLicenseSecretExistence(ctx context.Context, namespace string) error {
+func (i *injector) ensureLicenseSecretExistence(ctx context.Context, namespace string) (err error) {
+	ctx, span := i.tracer.Start(ctx, "ensureLicenseSecretExistence", trace.WithAttributes(namespaceAttribute(namespace)))
+	defer func() { tracing.End(span, err) }()
+
 	s := &corev1.Secret{}
 	key := client.ObjectKey{
 		Namespace: namespace,
 		Name:      i.licenseSecretName,
 	}
 
-	err := i.noCacheClient.Get(ctx, key, s)
+	err = i.noCacheClient.Get(ctx, key, s)
 
 	if apierrors.IsNotFound(err) {
 		return i.createSecret(ctx, namespace)
@@ -0,0 +1,363 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/pod-security-admission/api"
+	"k8s.io/pod-security-admission/policy"
+	"k8s.io/utils/ptr"
+
+	"github.com/newrelic/newrelic-infra-operator/internal/webhook"
+)
+
+const (
+	// SecurityContextPresetRestricted is the security context preset, which makes the sidecar compliant with
+	// "restricted" Pod Security Standard. Sidecar image must then run as non-root user.
+	SecurityContextPresetRestricted = "restricted"
+
+	// PodSecurityModeAdapt adapts security context of the sidecar violating Pod Security Standard enforced
+	// in the Pod's Namespace, so it complies with it. If sidecar can't be adapted, injection fails.
+	PodSecurityModeAdapt = "adapt"
+
+	// PodSecurityModeSkip admits the Pod without the sidecar, if sidecar violates Pod Security Standard enforced
+	// in the Pod's Namespace. Event explaining why the sidecar was not injected is emitted.
+	PodSecurityModeSkip = "skip"
+
+	// PodSecurityModeFail fails the injection, if sidecar violates Pod Security Standard enforced in the Pod's
+	// Namespace.
+	PodSecurityModeFail = "fail"
+
+	// PodSecurityViolationReason is the reason of the event emitted when injection is skipped.
+	PodSecurityViolationReason = "SidecarViolatesPodSecurity"
+)
+
+//nolint:gochecknoglobals
+var (
+	// baselineCapabilities are capabilities, which can be added to containers at "baseline" level.
+	baselineCapabilities = map[corev1.Capability]struct{}{
+		"AUDIT_WRITE": {}, "CHOWN": {}, "DAC_OVERRIDE": {}, "FOWNER": {}, "FSETID": {}, "KILL": {}, "MKNOD": {},
+		"NET_BIND_SERVICE": {}, "SETFCAP": {}, "SETGID": {}, "SETPCAP": {}, "SETUID": {}, "SYS_CHROOT": {},
+	}
+
+	// restrictedCapabilities are capabilities, which can be added to containers at "restricted" level.
+	restrictedCapabilities = map[corev1.Capability]struct{}{"NET_BIND_SERVICE": {}}
+
+	// baselineSELinuxTypes are SELinux types, which can be set on containers at "baseline" level.
+	baselineSELinuxTypes = map[string]struct{}{
+		"": {}, "container_t": {}, "container_init_t": {}, "container_kvm_t": {}, "container_engine_t": {},
+	}
+)
+
+//nolint:gochecknoglobals
+var podSecurityEvaluator = sync.OnceValues(func() (policy.Evaluator, error) {
+	return policy.NewEvaluator(policy.DefaultChecks(), nil)
+})
+
+// securityContext returns security context of the sidecar. Preset is applied on top of the defaults, followed
+// by user and group from PodSecurityContext and fields set in SecurityContext.
+func (config InfraAgentConfig) securityContext() *corev1.SecurityContext {
+	sc := &corev1.SecurityContext{
+		ReadOnlyRootFilesystem:   ptr.To[bool](true),
+		AllowPrivilegeEscalation: ptr.To[bool](false),
+	}
+
+	if config.SecurityContextPreset == SecurityContextPresetRestricted {
+		sc.RunAsNonRoot = ptr.To[bool](true)
+		sc.Capabilities = &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}}
+		sc.SeccompProfile = &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault}
+	}
+
+	if config.PodSecurityContext.RunAsUser != 0 {
+		sc.RunAsUser = ptr.To(config.PodSecurityContext.RunAsUser)
+	}
+
+	if config.PodSecurityContext.RunAsGroup != 0 {
+		sc.RunAsGroup = ptr.To(config.PodSecurityContext.RunAsGroup)
+	}
+
+	if config.SecurityContext != nil {
+		mergeSecurityContext(sc, config.SecurityContext)
+	}
+
+	return sc
+}
+
+// mergeSecurityContext sets fields of dst, which are set in src.
+func mergeSecurityContext(dst, src *corev1.SecurityContext) {
+	dstValue := reflect.ValueOf(dst).Elem()
+	srcValue := reflect.ValueOf(src.DeepCopy()).Elem()
+
+	for i := range srcValue.NumField() {
+		if f := srcValue.Field(i); !f.IsZero() {
+			dstValue.Field(i).Set(f)
+		}
+	}
+}
+
+// podSecurityViolations returns reasons why Pod with given security context, consisting only of given sidecar
+// and its volumes, would not be admitted at given Pod Security Standard level.
+func podSecurityViolations(
+	levelVersion api.LevelVersion,
+	podSecurityContext *corev1.PodSecurityContext,
+	container corev1.Container,
+	volumes []corev1.Volume,
+) (string, error) {
+	evaluator, err := podSecurityEvaluator()
+	if err != nil {
+		return "", fmt.Errorf("creating Pod Security evaluator: %w", err)
+	}
+
+	spec := &corev1.PodSpec{
+		SecurityContext: podSecurityContext,
+		Containers:      []corev1.Container{container},
+		Volumes:         volumes,
+	}
+
+	result := policy.AggregateCheckResults(evaluator.EvaluatePod(levelVersion, &metav1.ObjectMeta{}, spec))
+	if result.Allowed {
+		return "", nil
+	}
+
+	return result.ForbiddenDetail(), nil
+}
+
+func (config InjectorConfig) validateSecurity(fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	agentPath := fldPath.Child("agentConfig")
+
+	switch config.PodSecurityMode {
+	case "", PodSecurityModeAdapt, PodSecurityModeSkip, PodSecurityModeFail:
+	default:
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("podSecurityMode"), config.PodSecurityMode,
+			[]string{PodSecurityModeAdapt, PodSecurityModeSkip, PodSecurityModeFail}))
+	}
+
+	switch config.AgentConfig.SecurityContextPreset {
+	case "", SecurityContextPresetRestricted:
+	default:
+		allErrs = append(allErrs, field.NotSupported(agentPath.Child("securityContextPreset"),
+			config.AgentConfig.SecurityContextPreset, []string{SecurityContextPresetRestricted}))
+	}
+
+	if config.AgentConfig.PodSecurityLevel == "" {
+		return allErrs
+	}
+
+	level, err := api.ParseLevel(config.AgentConfig.PodSecurityLevel)
+	if err != nil {
+		return append(allErrs, field.NotSupported(agentPath.Child("podSecurityLevel"),
+			config.AgentConfig.PodSecurityLevel, []api.Level{api.LevelPrivileged, api.LevelBaseline, api.LevelRestricted}))
+	}
+
+	volumes := config.AgentConfig.Volumes.volumes()
+
+	check := func(container corev1.Container, fldPath *field.Path) {
+		violations, err := podSecurityViolations(api.LevelVersion{Level: level, Version: api.LatestVersion()},
+			nil, container, volumes)
+
+		switch {
+		case err != nil:
+			allErrs = append(allErrs, field.InternalError(fldPath, err))
+		case violations != "":
+			allErrs = append(allErrs, field.Invalid(fldPath, config.AgentConfig.PodSecurityLevel,
+				fmt.Sprintf("sidecar violates %q Pod Security Standard: %s", level, violations)))
+		}
+	}
+
+	check(config.container(""), agentPath.Child("podSecurityLevel"))
+
+	for i, profile := range config.Profiles {
+		profileConfig := config.profileConfig(profile)
+
+		check(profileConfig.container(""), fldPath.Child("profiles").Index(i))
+	}
+
+	return allErrs
+}
+
+// checkPodSecurity checks if the sidecar complies with Pod Security Standard level enforced in given Namespace
+// according to the configured mode. Security context of the sidecar may be adapted, or reason why injection
+// should be skipped is returned.
+func (i *injector) checkPodSecurity(
+	pod *corev1.Pod,
+	ns *corev1.Namespace,
+	container *corev1.Container,
+	volumes []corev1.Volume,
+	requestOptions webhook.RequestOptions,
+) (string, error) {
+	if i.config.PodSecurityMode == "" || ns == nil {
+		return "", nil
+	}
+
+	// Invalid labels are evaluated as "restricted" level, like API server does.
+	podSecurityPolicy, _ := api.PolicyToEvaluate(ns.Labels, api.Policy{
+		Enforce: api.LevelVersion{Level: api.LevelPrivileged, Version: api.LatestVersion()},
+	})
+
+	enforce := podSecurityPolicy.Enforce
+	if enforce.Level == api.LevelPrivileged {
+		return "", nil
+	}
+
+	violations, err := podSecurityViolations(enforce, pod.Spec.SecurityContext, *container, volumes)
+	if err != nil || violations == "" {
+		return "", err
+	}
+
+	message := fmt.Sprintf("sidecar violates %q Pod Security Standard enforced in Namespace %q: %s",
+		enforce.Level, ns.Name, violations)
+
+	switch i.config.PodSecurityMode {
+	case PodSecurityModeAdapt:
+		adapted := container.DeepCopy()
+		adaptSecurityContext(enforce.Level, adapted)
+
+		violations, err := podSecurityViolations(enforce, pod.Spec.SecurityContext, *adapted, volumes)
+		if err != nil {
+			return "", err
+		}
+
+		if violations != "" {
+			//nolint:err113
+			return "", fmt.Errorf("%s, adapted sidecar still violates it: %s", message, violations)
+		}
+
+		*container = *adapted
+
+		return "", nil
+	case PodSecurityModeSkip:
+		i.recordSkipEvent(pod, ns, PodSecurityViolationReason, message, requestOptions)
+
+		return message, nil
+	default:
+		//nolint:err113
+		return "", errors.New(message)
+	}
+}
+
+// recordSkipEvent emits event with given reason about sidecar not being injected. As Pod does not exist yet,
+// event is emitted for the Pod's controller, if there is one, or for its Namespace.
+func (i *injector) recordSkipEvent(
+	pod *corev1.Pod,
+	ns *corev1.Namespace,
+	reason string,
+	message string,
+	requestOptions webhook.RequestOptions,
+) {
+	if i.config.EventRecorder == nil || requestOptions.DryRun {
+		return
+	}
+
+	var regarding runtime.Object = ns
+
+	if owner := metav1.GetControllerOf(pod); owner != nil {
+		regarding = &metav1.PartialObjectMetadata{
+			TypeMeta: metav1.TypeMeta{APIVersion: owner.APIVersion, Kind: owner.Kind},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      owner.Name,
+				Namespace: ns.Name,
+				UID:       owner.UID,
+			},
+		}
+	}
+
+	i.config.EventRecorder.Eventf(regarding, nil, corev1.EventTypeWarning, reason,
+		"InjectSidecar", "Sidecar not injected into Pod %s: %s", podName(pod), message)
+}
+
+func podName(pod *corev1.Pod) string {
+	if pod.Name != "" {
+		return pod.Name
+	}
+
+	return pod.GenerateName
+}
+
+// adaptSecurityContext changes security context of given container, so it complies with given Pod Security
+// Standard level.
+//
+//nolint:cyclop
+func adaptSecurityContext(level api.Level, container *corev1.Container) {
+	if container.SecurityContext == nil {
+		container.SecurityContext = &corev1.SecurityContext{}
+	}
+
+	sc := container.SecurityContext
+
+	if sc.Privileged != nil && *sc.Privileged {
+		sc.Privileged = ptr.To(false)
+	}
+
+	if sc.ProcMount != nil && *sc.ProcMount != corev1.DefaultProcMount {
+		sc.ProcMount = nil
+	}
+
+	if sc.SeccompProfile != nil && sc.SeccompProfile.Type == corev1.SeccompProfileTypeUnconfined {
+		sc.SeccompProfile = &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault}
+	}
+
+	if sc.AppArmorProfile != nil && sc.AppArmorProfile.Type == corev1.AppArmorProfileTypeUnconfined {
+		sc.AppArmorProfile = &corev1.AppArmorProfile{Type: corev1.AppArmorProfileTypeRuntimeDefault}
+	}
+
+	if o := sc.SELinuxOptions; o != nil {
+		o.User, o.Role = "", ""
+
+		if _, ok := baselineSELinuxTypes[o.Type]; !ok {
+			o.Type = ""
+		}
+	}
+
+	if sc.WindowsOptions != nil && sc.WindowsOptions.HostProcess != nil && *sc.WindowsOptions.HostProcess {
+		sc.WindowsOptions.HostProcess = ptr.To(false)
+	}
+
+	for i := range container.Ports {
+		container.Ports[i].HostPort = 0
+	}
+
+	allowedCapabilities := baselineCapabilities
+
+	if level == api.LevelRestricted {
+		allowedCapabilities = restrictedCapabilities
+
+		sc.AllowPrivilegeEscalation = ptr.To(false)
+		sc.RunAsNonRoot = ptr.To(true)
+
+		if sc.RunAsUser != nil && *sc.RunAsUser == 0 {
+			sc.RunAsUser = nil
+		}
+
+		if sc.SeccompProfile == nil {
+			sc.SeccompProfile = &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault}
+		}
+
+		if sc.Capabilities == nil {
+			sc.Capabilities = &corev1.Capabilities{}
+		}
+
+		sc.Capabilities.Drop = []corev1.Capability{"ALL"}
+	}
+
+	if sc.Capabilities != nil && len(sc.Capabilities.Add) > 0 {
+		add := []corev1.Capability{}
+
+		for _, c := range sc.Capabilities.Add {
+			if _, ok := allowedCapabilities[c]; ok {
+				add = append(add, c)
+			}
+		}
+
+		sc.Capabilities.Add = add
+	}
+}
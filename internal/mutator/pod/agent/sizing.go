@@ -0,0 +1,239 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	"github.com/newrelic/newrelic-infra-operator/internal/webhook"
+)
+
+// SizingAnnotation is the name of the annotation describing how resources of the sidecar were computed.
+const SizingAnnotation = "infra-operator.newrelic.com/agent-sizing"
+
+// ResourceSizing computes resource requirements of the sidecar from the Pod it is injected into. Exactly one
+// of Fraction and Tiers must be set. Computed resources are adjusted to comply with LimitRanges of the Pod's
+// Namespace and to fit into its remaining ResourceQuotas.
+type ResourceSizing struct {
+	// Fraction sizes sidecar relatively to total requests of other containers of the Pod.
+	Fraction *FractionSizing `json:"fraction"`
+	// Tiers sizes sidecar by number of containers of the Pod. The first tier admitting the number of containers
+	// is used.
+	Tiers []SizingTier `json:"tiers"`
+}
+
+// FractionSizing sizes sidecar requests as a percentage of total requests of other containers of the Pod,
+// bounded by Min and Max.
+type FractionSizing struct {
+	// Percent of total requests of other containers requested by the sidecar.
+	Percent int32 `json:"percent"`
+	// Min holds minimum requests of the sidecar. Resources listed here are requested even when other containers
+	// do not request them.
+	Min corev1.ResourceList `json:"min"`
+	// Max holds maximum requests of the sidecar.
+	Max corev1.ResourceList `json:"max"`
+	// LimitPercent, if set, sets sidecar limits to given percentage of computed requests, e.g. 200 sets limits
+	// to twice the requests. If not set, sidecar has no limits.
+	LimitPercent int32 `json:"limitPercent"`
+}
+
+// SizingTier defines resources of the sidecar injected into Pods with at most MaxContainers containers,
+// not including the sidecar. Zero MaxContainers admits any number of containers.
+type SizingTier struct {
+	MaxContainers int32                       `json:"maxContainers"`
+	Resources     corev1.ResourceRequirements `json:"resources"`
+}
+
+// sizeSidecar sets resources of given sidecar computed using given sizing and constrained by given Namespace
+// constraints and annotates the Pod with description of computed resources.
+func sizeSidecar(
+	pod *corev1.Pod,
+	sidecarIndex int,
+	sizing *ResourceSizing,
+	constraints *resourceConstraints,
+	container *corev1.Container,
+	requestOptions webhook.RequestOptions,
+) {
+	if sizing == nil {
+		return
+	}
+
+	otherResources := podResources(pod, sidecarIndex)
+
+	resources, rule := sizing.resources(pod, sidecarIndex, otherResources)
+
+	resources, constrainedBy := constraints.constrain(resources, otherResources)
+	container.Resources = resources
+
+	description := sizingDescription(rule, resources, constrainedBy)
+
+	if pod.Annotations[SizingAnnotation] != description {
+		requestOptions.AddPatch(webhook.AddAnnotation(pod.Annotations, SizingAnnotation, description))
+
+		if pod.Annotations == nil {
+			pod.Annotations = map[string]string{}
+		}
+
+		pod.Annotations[SizingAnnotation] = description
+	}
+}
+
+// resources returns sidecar resources for given Pod and the sizing rule used to compute them.
+func (s *ResourceSizing) resources(
+	pod *corev1.Pod,
+	sidecarIndex int,
+	otherResources corev1.ResourceRequirements,
+) (corev1.ResourceRequirements, string) {
+	if s.Fraction != nil {
+		return s.Fraction.resources(otherResources.Requests), fmt.Sprintf("fraction=%d%%", s.Fraction.Percent)
+	}
+
+	containers := int32(len(pod.Spec.Containers))
+	if sidecarIndex >= 0 && sidecarIndex < len(pod.Spec.Containers) {
+		containers--
+	}
+
+	for idx, tier := range s.Tiers {
+		if tier.MaxContainers == 0 || containers <= tier.MaxContainers {
+			return *tier.Resources.DeepCopy(), "tier=" + strconv.Itoa(idx)
+		}
+	}
+
+	return corev1.ResourceRequirements{}, "tier=none"
+}
+
+func (f *FractionSizing) resources(totalRequests corev1.ResourceList) corev1.ResourceRequirements {
+	resources := corev1.ResourceRequirements{}
+
+	for _, list := range []corev1.ResourceList{totalRequests, f.Min} {
+		for name := range list {
+			if _, ok := resources.Requests[name]; ok {
+				continue
+			}
+
+			request := scaled(name, totalRequests[name], int64(f.Percent), 100)
+
+			if min, ok := f.Min[name]; ok && request.Cmp(min) < 0 {
+				request = min
+			}
+
+			if max, ok := f.Max[name]; ok && request.Cmp(max) > 0 {
+				request = max
+			}
+
+			if request.IsZero() {
+				continue
+			}
+
+			setQuantity(&resources.Requests, name, request)
+
+			if f.LimitPercent > 0 {
+				setQuantity(&resources.Limits, name, scaled(name, request, int64(f.LimitPercent), 100))
+			}
+		}
+	}
+
+	return resources
+}
+
+// sizingDescription returns value of SizingAnnotation, e.g.
+// "fraction=10%; requests=cpu=50m,memory=64Mi; limits=memory=128Mi; constrainedBy=LimitRange/default".
+func sizingDescription(rule string, resources corev1.ResourceRequirements, constrainedBy []string) string {
+	parts := []string{
+		rule,
+		"requests=" + resourceListString(resources.Requests),
+		"limits=" + resourceListString(resources.Limits),
+	}
+
+	if len(constrainedBy) > 0 {
+		parts = append(parts, "constrainedBy="+strings.Join(constrainedBy, ","))
+	}
+
+	return strings.Join(parts, "; ")
+}
+
+func resourceListString(list corev1.ResourceList) string {
+	quantities := make([]string, 0, len(list))
+
+	for name, quantity := range list {
+		quantities = append(quantities, fmt.Sprintf("%s=%s", name, quantity.String()))
+	}
+
+	sort.Strings(quantities)
+
+	return strings.Join(quantities, ",")
+}
+
+func (s *ResourceSizing) validate(fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if s == nil {
+		return allErrs
+	}
+
+	switch {
+	case s.Fraction == nil && len(s.Tiers) == 0:
+		allErrs = append(allErrs, field.Required(fldPath, "either fraction or tiers must be set"))
+	case s.Fraction != nil && len(s.Tiers) > 0:
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("tiers"), "tiers must not be set together with fraction"))
+	case s.Fraction != nil:
+		allErrs = append(allErrs, s.Fraction.validate(fldPath.Child("fraction"))...)
+	default:
+		allErrs = append(allErrs, validateTiers(s.Tiers, fldPath.Child("tiers"))...)
+	}
+
+	return allErrs
+}
+
+func (f *FractionSizing) validate(fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if f.Percent <= 0 || f.Percent > 100 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("percent"), f.Percent, "must be between 1 and 100"))
+	}
+
+	if f.LimitPercent != 0 && f.LimitPercent < 100 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("limitPercent"), f.LimitPercent,
+			"must be at least 100, so limits are not lower than requests"))
+	}
+
+	for name, max := range f.Max {
+		if min, ok := f.Min[name]; ok && min.Cmp(max) > 0 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("max").Key(string(name)), max.String(),
+				"must not be lower than minimum"))
+		}
+	}
+
+	return allErrs
+}
+
+func validateTiers(tiers []SizingTier, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	previous := int32(0)
+
+	for idx, tier := range tiers {
+		maxContainersPath := fldPath.Index(idx).Child("maxContainers")
+
+		switch {
+		case tier.MaxContainers < 0:
+			allErrs = append(allErrs, field.Invalid(maxContainersPath, tier.MaxContainers, "must not be negative"))
+		case tier.MaxContainers == 0 && idx != len(tiers)-1:
+			allErrs = append(allErrs, field.Invalid(maxContainersPath, tier.MaxContainers,
+				"only the last tier may admit any number of containers"))
+		case tier.MaxContainers != 0 && tier.MaxContainers <= previous:
+			allErrs = append(allErrs, field.Invalid(maxContainersPath, tier.MaxContainers,
+				"must be greater than maxContainers of previous tier"))
+		}
+
+		previous = tier.MaxContainers
+	}
+
+	return allErrs
+}
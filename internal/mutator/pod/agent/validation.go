@@ -0,0 +1,208 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// validate validates configuration required to create an injector, including values which are not part
+// of the configuration file.
+func (config InjectorConfig) validate() error {
+	if config.License == "" {
+		return fmt.Errorf("%w: %s", errEmpty, "license key")
+	}
+
+	if config.ClusterName == "" {
+		return fmt.Errorf("%w: %s", errEmpty, "cluster name")
+	}
+
+	return config.Validate(field.NewPath("infraAgentInjection")).ToAggregate()
+}
+
+// Validate validates the configuration, returning all found errors with paths of invalid fields relative to
+// given path. License and cluster name are not validated, as they are usually provided via environment.
+func (config InjectorConfig) Validate(fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	allErrs = append(allErrs, config.AgentConfig.Image.validate(fldPath.Child("agentConfig", "image"))...)
+
+	if config.ResourcePrefix == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("resourcePrefix"), "resource prefix must be set"))
+	}
+
+	allErrs = append(allErrs,
+		config.AgentConfig.CustomAttributes.validate(fldPath.Child("agentConfig", "customAttributes"))...)
+
+	allErrs = append(allErrs, config.AgentConfig.Probes.validate(fldPath.Child("agentConfig", "probes"))...)
+
+	for i, selector := range config.AgentConfig.ConfigSelectors {
+		selectorPath := fldPath.Child("agentConfig", "configSelectors").Index(i)
+
+		allErrs = append(allErrs, validateLabelSelector(&selector.LabelSelector, selectorPath.Child("labelSelector"))...)
+		allErrs = append(allErrs, selector.Probes.validate(selectorPath.Child("probes"))...)
+		allErrs = append(allErrs, selector.Sizing.validate(selectorPath.Child("sizing"))...)
+
+		if selector.Sizing != nil && selector.ResourceRequirements != nil {
+			allErrs = append(allErrs, field.Forbidden(selectorPath.Child("sizing"),
+				"sizing must not be set together with resourceRequirements"))
+		}
+	}
+
+	allErrs = append(allErrs, config.AgentConfig.Lifecycle.validate(fldPath.Child("agentConfig", "lifecycle"))...)
+	allErrs = append(allErrs, config.AgentConfig.Volumes.validate(fldPath.Child("agentConfig", "volumes"))...)
+	allErrs = append(allErrs, config.validateSecurity(fldPath)...)
+	allErrs = append(allErrs, config.validatePolicies(fldPath.Child("policies"))...)
+	allErrs = append(allErrs, config.validateIntegrations(fldPath)...)
+	allErrs = append(allErrs, config.validateProfiles(fldPath)...)
+	allErrs = append(allErrs, config.AsyncDependencies.validate(fldPath.Child("asyncDependencies"))...)
+	allErrs = append(allErrs,
+		config.ClusterRoleBindingSharding.validate(fldPath.Child("clusterRoleBindingSharding"))...)
+
+	switch config.CollisionStrategy {
+	case "", CollisionStrategyFail, CollisionStrategySkip, CollisionStrategySuffix:
+	default:
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("collisionStrategy"), config.CollisionStrategy,
+			[]string{CollisionStrategyFail, CollisionStrategySkip, CollisionStrategySuffix}))
+	}
+
+	switch config.ResourceQuotaPolicy {
+	case "", ResourceQuotaPolicyShrink, ResourceQuotaPolicySkip, ResourceQuotaPolicyProceed:
+	default:
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("resourceQuotaPolicy"), config.ResourceQuotaPolicy,
+			[]string{ResourceQuotaPolicyShrink, ResourceQuotaPolicySkip, ResourceQuotaPolicyProceed}))
+	}
+
+	return allErrs
+}
+
+// Warnings returns issues with the configuration, which do not prevent the operator from running, but are likely
+// a mistake, like config selectors which never match any Pod, because earlier config selectors match all Pods
+// they would match.
+func (config InjectorConfig) Warnings(fldPath *field.Path) field.ErrorList {
+	warnings := field.ErrorList{}
+	selectorsPath := fldPath.Child("agentConfig", "configSelectors")
+
+	selectors := make([]labels.Selector, len(config.AgentConfig.ConfigSelectors))
+
+	for i, configSelector := range config.AgentConfig.ConfigSelectors {
+		selector, err := metav1.LabelSelectorAsSelector(&configSelector.LabelSelector)
+		if err != nil {
+			// Invalid selectors are reported by Validate.
+			continue
+		}
+
+		selectors[i] = selector
+
+		for j := range i {
+			if selectors[j] == nil || !shadows(selectors[j], selector) {
+				continue
+			}
+
+			warnings = append(warnings, field.Invalid(selectorsPath.Index(i).Child("labelSelector"),
+				configSelector.LabelSelector.String(),
+				fmt.Sprintf("config selector is unreachable, as all Pods it matches are matched by %s first",
+					selectorsPath.Index(j))))
+
+			break
+		}
+	}
+
+	return warnings
+}
+
+// shadows returns true if every set of labels matching selector b also matches selector a, which is the case
+// when all requirements of a are also requirements of b.
+func shadows(a, b labels.Selector) bool {
+	aRequirements, _ := a.Requirements()
+	bRequirements, _ := b.Requirements()
+
+	bSet := map[string]struct{}{}
+	for _, r := range bRequirements {
+		bSet[r.String()] = struct{}{}
+	}
+
+	for _, r := range aRequirements {
+		if _, ok := bSet[r.String()]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (config InjectorConfig) validatePolicies(fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if len(config.Policies) == 0 {
+		allErrs = append(allErrs, field.Required(fldPath, "at least one injection policy must be configured"))
+	}
+
+	supportedActions := []string{PolicyActionInclude, PolicyActionExclude}
+
+	for i, policy := range config.Policies {
+		policyPath := fldPath.Index(i)
+
+		switch policy.Action {
+		case "", PolicyActionInclude, PolicyActionExclude:
+		default:
+			allErrs = append(allErrs, field.NotSupported(policyPath.Child("action"), policy.Action, supportedActions))
+		}
+
+		allErrs = append(allErrs, validateLabelSelector(policy.NamespaceSelector, policyPath.Child("namespaceSelector"))...)
+		allErrs = append(allErrs, validateLabelSelector(policy.PodSelector, policyPath.Child("podSelector"))...)
+	}
+
+	return allErrs
+}
+
+func validateLabelSelector(selector *metav1.LabelSelector, fldPath *field.Path) field.ErrorList {
+	return metav1validation.ValidateLabelSelector(selector, metav1validation.LabelSelectorValidationOptions{}, fldPath)
+}
+
+func (image Image) validate(fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if image.Repository == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("repository"), "image repository must be set"))
+	}
+
+	if image.Tag == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("tag"), "image tag must be set"))
+	}
+
+	return allErrs
+}
+
+func (cas CustomAttributes) validate(fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	customAttributeNames := map[string]struct{}{}
+
+	for i, ca := range cas {
+		namePath := fldPath.Index(i).Child("name")
+
+		switch _, duplicate := customAttributeNames[ca.Name]; {
+		case ca.Name == "":
+			allErrs = append(allErrs, field.Required(namePath, "custom attribute name must be set"))
+		case ca.Name == clusterNameAttribute:
+			allErrs = append(allErrs, field.Invalid(namePath, ca.Name, "custom attribute name is reserved"))
+		case duplicate:
+			allErrs = append(allErrs, field.Duplicate(namePath, ca.Name))
+		}
+
+		if ca.DefaultValue == "" && ca.FromLabel == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Index(i),
+				"either defaultValue or fromLabel must be set"))
+		}
+
+		customAttributeNames[ca.Name] = struct{}{}
+	}
+
+	return allErrs
+}
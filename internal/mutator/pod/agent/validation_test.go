@@ -0,0 +1,235 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package agent_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/utils/ptr"
+
+	"github.com/newrelic/newrelic-infra-operator/internal/mutator/pod/agent"
+)
+
+//nolint:funlen
+func Test_Validating_config(t *testing.T) {
+	t.Parallel()
+
+	fldPath := field.NewPath("infraAgentInjection")
+
+	t.Run("returns_no_errors_for_valid_config", func(t *testing.T) {
+		t.Parallel()
+
+		if errs := getConfig().Validate(fldPath); len(errs) != 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+	})
+
+	t.Run("returns_all_errors_with_field_paths", func(t *testing.T) {
+		t.Parallel()
+
+		config := getConfig()
+		config.ResourcePrefix = ""
+		config.AgentConfig.Image.Tag = ""
+		config.AgentConfig.CustomAttributes = agent.CustomAttributes{
+			{Name: "foo", DefaultValue: "bar"},
+			{Name: "foo", DefaultValue: "baz"},
+		}
+		config.Policies = []agent.InjectionPolicy{
+			{},
+			{Action: "skip"},
+			{
+				PodSelector: &metav1.LabelSelector{
+					MatchExpressions: []metav1.LabelSelectorRequirement{
+						{Key: "app", Operator: "Equals", Values: []string{"foo"}},
+					},
+				},
+			},
+		}
+		config.CollisionStrategy = "rename"
+		config.ResourceQuotaPolicy = "ignore"
+		config.AgentConfig.ConfigSelectors = []agent.ConfigSelector{
+			{
+				ResourceRequirements: &corev1.ResourceRequirements{},
+				Sizing: &agent.ResourceSizing{
+					Fraction: &agent.FractionSizing{Percent: 150, LimitPercent: 50},
+				},
+			},
+			{
+				Sizing: &agent.ResourceSizing{
+					Tiers: []agent.SizingTier{{}, {MaxContainers: 2}},
+				},
+			},
+		}
+		config.AgentConfig.Lifecycle = &agent.Lifecycle{
+			PreStop:                       &corev1.LifecycleHandler{},
+			WaitForContainers:             true,
+			TerminationGracePeriodSeconds: ptr.To[int64](30),
+		}
+		config.AgentConfig.Probes = &agent.Probes{
+			StatusServerPort: 70000,
+			Liveness:         &corev1.Probe{SuccessThreshold: 2},
+			Readiness:        &corev1.Probe{SuccessThreshold: 2, PeriodSeconds: -1},
+		}
+		config.AgentConfig.Volumes = agent.SidecarVolumes{
+			EmptyDirs: map[string]corev1.EmptyDirVolumeSource{
+				"tmpfs-cache-injected": {Medium: "Tape"},
+				"cache":                {},
+			},
+			ExtraVolumes:      []corev1.Volume{{Name: "tmpfs-tmp-injected"}},
+			ExtraVolumeMounts: []corev1.VolumeMount{{Name: "foo", MountPath: "/tmp"}},
+		}
+
+		expectedFields := []string{
+			"infraAgentInjection.agentConfig.image.tag",
+			"infraAgentInjection.resourcePrefix",
+			"infraAgentInjection.agentConfig.customAttributes[1].name",
+			"infraAgentInjection.agentConfig.probes.statusServerPort",
+			"infraAgentInjection.agentConfig.probes.liveness.successThreshold",
+			"infraAgentInjection.agentConfig.probes.readiness.periodSeconds",
+			"infraAgentInjection.agentConfig.configSelectors[0].sizing.fraction.percent",
+			"infraAgentInjection.agentConfig.configSelectors[0].sizing.fraction.limitPercent",
+			"infraAgentInjection.agentConfig.configSelectors[0].sizing",
+			"infraAgentInjection.agentConfig.configSelectors[1].sizing.tiers[0].maxContainers",
+			"infraAgentInjection.agentConfig.lifecycle.preStop",
+			"infraAgentInjection.agentConfig.lifecycle.preStop",
+			"infraAgentInjection.agentConfig.lifecycle.terminationGracePeriodSeconds",
+			"infraAgentInjection.agentConfig.volumes.emptyDirs[cache]",
+			"infraAgentInjection.agentConfig.volumes.emptyDirs[tmpfs-cache-injected].medium",
+			"infraAgentInjection.agentConfig.volumes.extraVolumes[0].name",
+			"infraAgentInjection.agentConfig.volumes.extraVolumeMounts[0].name",
+			"infraAgentInjection.agentConfig.volumes.extraVolumeMounts[0].mountPath",
+			"infraAgentInjection.policies[1].action",
+			"infraAgentInjection.policies[2].podSelector.matchExpressions[0].operator",
+			"infraAgentInjection.collisionStrategy",
+			"infraAgentInjection.resourceQuotaPolicy",
+		}
+
+		fields := []string{}
+		for _, err := range config.Validate(fldPath) {
+			fields = append(fields, err.Field)
+		}
+
+		if diff := cmp.Diff(expectedFields, fields); diff != "" {
+			t.Fatalf("unexpected errors: %s", diff)
+		}
+	})
+
+	t.Run("allows_preStop_hook_and_any_grace_period_for_native_sidecar_waiting_for_containers", func(t *testing.T) {
+		t.Parallel()
+
+		config := getConfig()
+		config.AgentConfig.Lifecycle = &agent.Lifecycle{
+			NativeSidecar:                 true,
+			PreStop:                       &corev1.LifecycleHandler{Sleep: &corev1.SleepAction{Seconds: 5}},
+			WaitForContainers:             true,
+			TerminationGracePeriodSeconds: ptr.To[int64](10),
+		}
+
+		if errs := config.Validate(fldPath); len(errs) != 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+	})
+
+	t.Run("checks_sidecar_against_configured_Pod_Security_Standard_level", func(t *testing.T) {
+		t.Parallel()
+
+		cases := map[string]struct {
+			configF        func(*agent.InjectorConfig)
+			expectedFields []string
+		}{
+			"default_sidecar_complies_with_baseline_level": {
+				configF: func(config *agent.InjectorConfig) {
+					config.AgentConfig.PodSecurityLevel = "baseline"
+				},
+			},
+			"default_sidecar_violates_restricted_level": {
+				configF: func(config *agent.InjectorConfig) {
+					config.AgentConfig.PodSecurityLevel = "restricted"
+				},
+				expectedFields: []string{"infraAgentInjection.agentConfig.podSecurityLevel"},
+			},
+			"restricted_preset_complies_with_restricted_level": {
+				configF: func(config *agent.InjectorConfig) {
+					config.AgentConfig.PodSecurityLevel = "restricted"
+					config.AgentConfig.SecurityContextPreset = agent.SecurityContextPresetRestricted
+				},
+			},
+			"privileged_profile_violates_baseline_level": {
+				configF: func(config *agent.InjectorConfig) {
+					config.AgentConfig.PodSecurityLevel = "baseline"
+					config.AgentConfig.SecurityContext = &corev1.SecurityContext{Privileged: ptr.To(true)}
+					config.Profiles = []agent.AgentProfile{{Name: "foo"}}
+				},
+				expectedFields: []string{
+					"infraAgentInjection.agentConfig.podSecurityLevel",
+					"infraAgentInjection.profiles[0]",
+				},
+			},
+			"unsupported_level_preset_and_mode": {
+				configF: func(config *agent.InjectorConfig) {
+					config.AgentConfig.PodSecurityLevel = "strict"
+					config.AgentConfig.SecurityContextPreset = "strict"
+					config.PodSecurityMode = "warn"
+				},
+				expectedFields: []string{
+					"infraAgentInjection.podSecurityMode",
+					"infraAgentInjection.agentConfig.securityContextPreset",
+					"infraAgentInjection.agentConfig.podSecurityLevel",
+				},
+			},
+		}
+
+		for testCaseName, testData := range cases {
+			testData := testData
+
+			t.Run(testCaseName, func(t *testing.T) {
+				t.Parallel()
+
+				config := getConfig()
+				testData.configF(config)
+
+				fields := []string{}
+				for _, err := range config.Validate(fldPath) {
+					fields = append(fields, err.Field)
+				}
+
+				if diff := cmp.Diff(testData.expectedFields, fields, cmpopts.EquateEmpty()); diff != "" {
+					t.Fatalf("unexpected errors: %s", diff)
+				}
+			})
+		}
+	})
+
+	t.Run("warns_about_config_selectors_shadowed_by_earlier_ones", func(t *testing.T) {
+		t.Parallel()
+
+		config := getConfig()
+		config.AgentConfig.ConfigSelectors = []agent.ConfigSelector{
+			{LabelSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "foo"}}},
+			{LabelSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "bar"}}},
+			{LabelSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "foo", "tier": "web"}}},
+			{},
+			{LabelSelector: metav1.LabelSelector{MatchLabels: map[string]string{"tier": "db"}}},
+		}
+
+		expectedFields := []string{
+			"infraAgentInjection.agentConfig.configSelectors[2].labelSelector",
+			"infraAgentInjection.agentConfig.configSelectors[4].labelSelector",
+		}
+
+		fields := []string{}
+		for _, warning := range config.Warnings(fldPath) {
+			fields = append(fields, warning.Field)
+		}
+
+		if diff := cmp.Diff(expectedFields, fields); diff != "" {
+			t.Fatalf("unexpected warnings: %s", diff)
+		}
+	})
+}
@@ -0,0 +1,178 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// SidecarVolumes configures volumes added to the Pod together with the sidecar.
+type SidecarVolumes struct {
+	// EmptyDirs configures emptyDir volumes backing agent's writable directories, indexed by volume name,
+	// e.g. "tmpfs-cache-injected". Volumes not listed are disk-backed and have no size limit.
+	EmptyDirs map[string]corev1.EmptyDirVolumeSource `json:"emptyDirs"`
+	// ExtraVolumes are additional volumes added to the Pod.
+	ExtraVolumes []corev1.Volume `json:"extraVolumes"`
+	// ExtraVolumeMounts are additional volume mounts of the sidecar, referencing either agent volumes
+	// or ExtraVolumes.
+	ExtraVolumeMounts []corev1.VolumeMount `json:"extraVolumeMounts"`
+}
+
+// volumes returns all volumes mounted by the sidecar, except the integrations volume.
+func (v SidecarVolumes) volumes() []corev1.Volume {
+	volumes := []corev1.Volume{}
+
+	for _, m := range standardVolumes() {
+		emptyDir := v.EmptyDirs[m.Name]
+
+		volumes = append(volumes, corev1.Volume{
+			Name: m.Name,
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: emptyDir.DeepCopy(),
+			},
+		})
+	}
+
+	for _, volume := range v.ExtraVolumes {
+		volumes = append(volumes, *volume.DeepCopy())
+	}
+
+	return volumes
+}
+
+// volumeMounts returns volume mounts of the sidecar, except mounts of the integrations volume.
+func (v SidecarVolumes) volumeMounts() []corev1.VolumeMount {
+	return append(standardVolumes(), v.ExtraVolumeMounts...)
+}
+
+// hashed returns volumes configuration to be included in the config hash. Default configuration is omitted,
+// so it does not change the hash of Pods injected by operator versions where volumes were not configurable.
+func (v SidecarVolumes) hashed() *SidecarVolumes {
+	if len(v.EmptyDirs) == 0 && len(v.ExtraVolumes) == 0 && len(v.ExtraVolumeMounts) == 0 {
+		return nil
+	}
+
+	return &v
+}
+
+func (v SidecarVolumes) validate(fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	volumeNames := map[string]struct{}{}
+	mountPaths := map[string]struct{}{}
+
+	for _, m := range standardVolumes() {
+		volumeNames[m.Name] = struct{}{}
+		mountPaths[m.MountPath] = struct{}{}
+	}
+
+	emptyDirNames := make([]string, 0, len(v.EmptyDirs))
+	for name := range v.EmptyDirs {
+		emptyDirNames = append(emptyDirNames, name)
+	}
+
+	sort.Strings(emptyDirNames)
+
+	for _, name := range emptyDirNames {
+		emptyDirPath := fldPath.Child("emptyDirs").Key(name)
+
+		if _, ok := volumeNames[name]; !ok {
+			allErrs = append(allErrs, field.NotSupported(emptyDirPath, name, standardVolumeNames()))
+
+			continue
+		}
+
+		allErrs = append(allErrs, validateEmptyDir(v.EmptyDirs[name], emptyDirPath)...)
+	}
+
+	for i, volume := range v.ExtraVolumes {
+		namePath := fldPath.Child("extraVolumes").Index(i).Child("name")
+
+		if _, duplicate := volumeNames[volume.Name]; duplicate {
+			allErrs = append(allErrs, field.Duplicate(namePath, volume.Name))
+		}
+
+		if volume.Name == "" {
+			allErrs = append(allErrs, field.Required(namePath, "volume name must be set"))
+		}
+
+		if volume.Name == integrationsVolumeName {
+			allErrs = append(allErrs, field.Invalid(namePath, volume.Name, "name is reserved for integrations volume"))
+		}
+
+		volumeNames[volume.Name] = struct{}{}
+	}
+
+	for i, mount := range v.ExtraVolumeMounts {
+		mountPath := fldPath.Child("extraVolumeMounts").Index(i)
+
+		if _, ok := volumeNames[mount.Name]; !ok {
+			allErrs = append(allErrs, field.NotFound(mountPath.Child("name"), mount.Name))
+		}
+
+		switch _, duplicate := mountPaths[mount.MountPath]; {
+		case mount.MountPath == "":
+			allErrs = append(allErrs, field.Required(mountPath.Child("mountPath"), "mount path must be set"))
+		case duplicate:
+			allErrs = append(allErrs, field.Duplicate(mountPath.Child("mountPath"), mount.MountPath))
+		}
+
+		mountPaths[mount.MountPath] = struct{}{}
+	}
+
+	return allErrs
+}
+
+func validateEmptyDir(emptyDir corev1.EmptyDirVolumeSource, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	switch medium := emptyDir.Medium; {
+	case medium == corev1.StorageMediumDefault, medium == corev1.StorageMediumMemory:
+	case medium == corev1.StorageMediumHugePages, strings.HasPrefix(string(medium), "HugePages-"):
+	default:
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("medium"), medium,
+			[]corev1.StorageMedium{corev1.StorageMediumMemory, corev1.StorageMediumHugePages}))
+	}
+
+	if emptyDir.SizeLimit != nil && emptyDir.SizeLimit.Sign() < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("sizeLimit"), emptyDir.SizeLimit.String(),
+			"size limit must not be negative"))
+	}
+
+	return allErrs
+}
+
+func standardVolumeNames() []string {
+	names := []string{}
+
+	for _, m := range standardVolumes() {
+		names = append(names, m.Name)
+	}
+
+	return names
+}
+
+func standardVolumes() []corev1.VolumeMount {
+	return []corev1.VolumeMount{
+		{
+			Name:      "tmpfs-data-injected",
+			MountPath: "/var/db/newrelic-infra/data",
+		},
+		{
+			Name:      "tmpfs-user-data-injected",
+			MountPath: "/var/db/newrelic-infra/user_data",
+		},
+		{
+			Name:      "tmpfs-tmp-injected",
+			MountPath: "/tmp",
+		},
+		{
+			Name:      "tmpfs-cache-injected",
+			MountPath: "/var/cache/nr-kubernetes",
+		},
+	}
+}
@@ -7,10 +7,15 @@ package operator
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"time"
 
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
@@ -19,7 +24,10 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	"github.com/go-logr/logr"
+	"github.com/newrelic/newrelic-infra-operator/internal/audit"
 	"github.com/newrelic/newrelic-infra-operator/internal/mutator/pod/agent"
+	"github.com/newrelic/newrelic-infra-operator/internal/preflight"
+	"github.com/newrelic/newrelic-infra-operator/internal/tracing"
 )
 
 const (
@@ -28,6 +36,13 @@ const (
 
 	// DefaultHealthProbeBindAddress is a default bind address for health probes.
 	DefaultHealthProbeBindAddress = ":9440"
+
+	cacheSyncCheckTimeout = 100 * time.Millisecond
+
+	tracingShutdownTimeout = 5 * time.Second
+
+	// DefaultLeaderElectionID is a default name of the Lease used for leader election.
+	DefaultLeaderElectionID = "newrelic-infra-operator-leader"
 )
 
 // Options holds the configuration for an operator.
@@ -40,10 +55,34 @@ type Options struct {
 	Logger                 logr.Logger  `json:"-"`
 	IgnoreMutationErrors   bool         `json:"ignoreMutationErrors"`
 
+	// LogLevel, LogFormat and LogSampling configure operator logs. They are consumed by the CLI, which creates
+	// the Logger.
+	LogLevel    string      `json:"logLevel"`
+	LogFormat   string      `json:"logFormat"`
+	LogSampling LogSampling `json:"logSampling"`
+
+	// LeaderElection enables electing leader between operator replicas using a Lease. Webhook is served by all
+	// replicas, only background tasks which must not run concurrently are run by the leader.
+	LeaderElection          bool   `json:"leaderElection"`
+	LeaderElectionID        string `json:"leaderElectionID"`
+	LeaderElectionNamespace string `json:"leaderElectionNamespace"`
+
 	InfraAgentInjection agent.InjectorConfig `json:"infraAgentInjection"`
+	Audit               audit.Config         `json:"audit"`
+	Tracing             tracing.Config       `json:"tracing"`
+}
+
+// LogSampling configures sampling of repeated log entries. Every second, first Initial entries with the same
+// level and message are logged and after that only every Thereafter-th of them. Sampling is disabled
+// when Initial is 0. When sampling is enabled, Thereafter must be positive.
+type LogSampling struct {
+	Initial    int `json:"initial"`
+	Thereafter int `json:"thereafter"`
 }
 
 // Run starts operator main loop. At the moment it only runs TLS webhook server and healthcheck web server.
+//
+//nolint:funlen
 func Run(ctx context.Context, options Options) error {
 	if options.RestConfig == nil {
 		// Required for in-cluster client configuration.
@@ -55,7 +94,13 @@ func Run(ctx context.Context, options Options) error {
 		options.RestConfig = restConfig
 	}
 
-	mgr, err := manager.New(options.RestConfig, options.withDefaults().toManagerOptions())
+	preflightRunner := preflight.New(options.Logger.WithName("preflight"))
+
+	extraHandlers := map[string]http.Handler{
+		PreflightEndpoint: preflightRunner,
+	}
+
+	mgr, err := manager.New(options.RestConfig, options.withDefaults().toManagerOptions(extraHandlers))
 	if err != nil {
 		return fmt.Errorf("initializing manager: %w", err)
 	}
@@ -64,6 +109,10 @@ func Run(ctx context.Context, options Options) error {
 		return fmt.Errorf("adding readiness check: %w", err)
 	}
 
+	if err := mgr.AddReadyzCheck("webhook", mgr.GetWebhookServer().StartedChecker()); err != nil {
+		return fmt.Errorf("adding webhook readiness check: %w", err)
+	}
+
 	if err := mgr.AddHealthzCheck("ping", healthz.Ping); err != nil {
 		return fmt.Errorf("adding health check: %w", err)
 	}
@@ -78,16 +127,85 @@ func Run(ctx context.Context, options Options) error {
 		return fmt.Errorf("creating client: %w", err)
 	}
 
+	preflightRunner.Add(preflightChecks(options, noCacheClient)...)
+
+	if err := mgr.Add(preflightRunner); err != nil {
+		return fmt.Errorf("adding preflight checks to manager: %w", err)
+	}
+
+	if err := mgr.AddReadyzCheck("preflight", preflightRunner.Checker); err != nil {
+		return fmt.Errorf("adding preflight readiness check: %w", err)
+	}
+
+	// Watching Namespaces cluster-wide is only worth it when they are looked up during admission.
+	if options.InfraAgentInjection.LooksUpNamespaces() {
+		namespaceCache, err := newNamespaceCache(ctx, mgr.GetCache())
+		if err != nil {
+			return fmt.Errorf("creating Namespace cache: %w", err)
+		}
+
+		if err := mgr.AddReadyzCheck("cache-sync", cacheSyncChecker(mgr.GetCache())); err != nil {
+			return fmt.Errorf("adding cache readiness check: %w", err)
+		}
+
+		options.InfraAgentInjection.NamespaceCache = namespaceCache
+	}
+
+	tracerProvider, err := options.Tracing.New(ctx)
+	if err != nil {
+		return fmt.Errorf("creating tracer provider: %w", err)
+	}
+
+	defer func() {
+		// Manager context is already cancelled at this point, so give pending spans a moment to get flushed.
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+		defer cancel()
+
+		if err := tracerProvider.Shutdown(shutdownCtx); err != nil {
+			options.Logger.Error(err, "Shutting down tracer provider")
+		}
+	}()
+
+	options.InfraAgentInjection.TracerProvider = tracerProvider
+	options.InfraAgentInjection.EventRecorder = mgr.GetEventRecorder("newrelic-infra-operator")
+
 	agentInjector, err := options.InfraAgentInjection.New(mgr.GetClient(), noCacheClient, options.Logger)
 	if err != nil {
 		return fmt.Errorf("creating injector: %w", err)
 	}
 
+	for name, check := range agentInjector.ReadyzChecks() {
+		if err := mgr.AddReadyzCheck(name, check); err != nil {
+			return fmt.Errorf("adding %q readiness check: %w", name, err)
+		}
+	}
+
+	if err := mgr.Add(agentInjector); err != nil {
+		return fmt.Errorf("adding injector to manager: %w", err)
+	}
+
+	if err := mgr.Add(agentInjector.LeaderRunnable()); err != nil {
+		return fmt.Errorf("adding injector leader tasks to manager: %w", err)
+	}
+
+	auditSink, err := options.Audit.New(options.Logger.WithName("audit"))
+	if err != nil {
+		return fmt.Errorf("creating audit sink: %w", err)
+	}
+
+	defer func() {
+		if err := auditSink.Close(); err != nil {
+			options.Logger.Error(err, "Closing audit sink")
+		}
+	}()
+
 	admissionWebhook := &webhook.Admission{
 		Handler: &podMutatorHandler{
 			decoder:              admission.NewDecoder(mgr.GetScheme()),
 			ignoreMutationErrors: options.IgnoreMutationErrors,
 			logger:               options.Logger,
+			auditSink:            auditSink,
+			tracer:               tracing.Tracer(tracerProvider),
 			mutators: []podMutator{
 				agentInjector,
 			},
@@ -103,12 +221,58 @@ func Run(ctx context.Context, options Options) error {
 	return nil
 }
 
-func (o *Options) toManagerOptions() manager.Options {
+// namespaceCache serves Namespaces from the manager cache, backed by explicitly registered informer, so Namespace
+// lookups done during admission do not lazily create informers or block on cache synchronization.
+type namespaceCache struct {
+	client.Reader
+	informer cache.Informer
+}
+
+func newNamespaceCache(ctx context.Context, c cache.Cache) (*namespaceCache, error) {
+	informer, err := c.GetInformer(ctx, &corev1.Namespace{}, cache.BlockUntilSynced(false))
+	if err != nil {
+		return nil, fmt.Errorf("getting Namespace informer: %w", err)
+	}
+
+	return &namespaceCache{
+		Reader:   c,
+		informer: informer,
+	}, nil
+}
+
+// HasSynced returns true when Namespace informer has been synced.
+func (n *namespaceCache) HasSynced() bool {
+	return n.informer.HasSynced()
+}
+
+// cacheSyncChecker returns readiness check verifying that all informers of given cache are synced.
+func cacheSyncChecker(c cache.Cache) healthz.Checker {
+	return func(req *http.Request) error {
+		ctx, cancel := context.WithTimeout(req.Context(), cacheSyncCheckTimeout)
+		defer cancel()
+
+		if !c.WaitForCacheSync(ctx) {
+			//nolint:err113
+			return fmt.Errorf("informer caches are not synced")
+		}
+
+		return nil
+	}
+}
+
+func (o *Options) toManagerOptions(extraHandlers map[string]http.Handler) manager.Options {
 	return manager.Options{
-		HealthProbeBindAddress: o.HealthProbeBindAddress,
+		HealthProbeBindAddress:     o.HealthProbeBindAddress,
+		LeaderElection:             o.LeaderElection,
+		LeaderElectionID:           o.LeaderElectionID,
+		LeaderElectionNamespace:    o.LeaderElectionNamespace,
+		LeaderElectionResourceLock: resourcelock.LeasesResourceLock,
+		// Leader does not hold any state, so it can step down immediately allowing faster failover.
+		LeaderElectionReleaseOnCancel: true,
 		Metrics: metricsserver.Options{
-			BindAddress: o.MetricsBindAddress,
-			CertDir:     o.CertDir,
+			BindAddress:   o.MetricsBindAddress,
+			CertDir:       o.CertDir,
+			ExtraHandlers: extraHandlers,
 		},
 		WebhookServer: webhook.NewServer(webhook.Options{
 			Port:    o.Port,
@@ -127,5 +291,9 @@ func (o *Options) withDefaults() *Options {
 		o.HealthProbeBindAddress = DefaultHealthProbeBindAddress
 	}
 
+	if o.LeaderElectionID == "" {
+		o.LeaderElectionID = DefaultLeaderElectionID
+	}
+
 	return o
 }
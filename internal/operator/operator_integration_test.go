@@ -27,6 +27,7 @@ import (
 	"time"
 
 	admissionv1 "k8s.io/api/admission/v1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -96,7 +97,10 @@ func Test_Running_operator(t *testing.T) {
 	t.Run("listens_on_default_port_for_health_checks", func(t *testing.T) {
 		t.Parallel()
 
-		ctx, _, _ := runOperator(t, func(o *operator.Options) { o.HealthProbeBindAddress = "" })
+		ctx, options, _ := runOperator(t, func(o *operator.Options) { o.HealthProbeBindAddress = "" })
+
+		createClusterRoleBinding(ctx, t, options)
+		createMutatingWebhookConfiguration(ctx, t, options)
 
 		url := fmt.Sprintf("http://%s%s/readyz", testHost, operator.DefaultHealthProbeBindAddress)
 		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
@@ -121,6 +125,15 @@ func Test_Running_operator(t *testing.T) {
 				return false
 			}
 
+			// Readiness checks like preflight checks may need a moment to pass after operator starts.
+			if resp.StatusCode == http.StatusInternalServerError {
+				t.Logf("operator not ready yet: %v", resp)
+
+				time.Sleep(1 * time.Second)
+
+				return false
+			}
+
 			if resp.StatusCode != http.StatusOK {
 				t.Fatalf("got %d response code, expected %d: %v", resp.StatusCode, http.StatusOK, resp)
 			}
@@ -133,6 +146,7 @@ func Test_Running_operator(t *testing.T) {
 		ctx, options, ca := runOperator(t, nil)
 
 		createClusterRoleBinding(ctx, t, options)
+		createMutatingWebhookConfiguration(ctx, t, options)
 
 		t.Run("readiness_probe", func(t *testing.T) {
 			t.Parallel()
@@ -160,6 +174,15 @@ func Test_Running_operator(t *testing.T) {
 					return false
 				}
 
+				// Readiness checks like preflight checks may need a moment to pass after operator starts.
+				if resp.StatusCode == http.StatusInternalServerError {
+					t.Logf("operator not ready yet: %v", resp)
+
+					time.Sleep(1 * time.Second)
+
+					return false
+				}
+
 				if resp.StatusCode != http.StatusOK {
 					t.Fatalf("got non 200 response code: %v", resp)
 				}
@@ -303,6 +326,98 @@ func Test_Running_operator(t *testing.T) {
 	// We may touch environment variables in those tests, which are global, so run serially.
 	//
 	//nolint:paralleltest
+	t.Run("reports_not_ready_with_reason_when_ClusterRoleBinding_does_not_exist", func(t *testing.T) {
+		ctx, options, _ := runOperator(t, nil)
+
+		url := fmt.Sprintf("http://%s/readyz/cluster-role-binding", options.HealthProbeBindAddress)
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			t.Fatalf("creating request: %v", err)
+		}
+
+		retryUntilFinished(func() bool {
+			resp, err := http.DefaultClient.Do(req) //nolint:bodyclose
+
+			defer closeResponseBody(t, resp)
+
+			if err != nil {
+				if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+					t.Fatalf("test timed out: %v", err)
+				}
+
+				t.Logf("fetching readiness probe: %v", err)
+
+				time.Sleep(1 * time.Second)
+
+				return false
+			}
+
+			if resp.StatusCode != http.StatusInternalServerError {
+				t.Fatalf("got %d response code, expected %d: %v", resp.StatusCode, http.StatusInternalServerError, resp)
+			}
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("reading response body: %v", err)
+			}
+
+			if !bytes.Contains(body, []byte("not found")) {
+				t.Fatalf("expected failure reason in response, got %q", string(body))
+			}
+
+			return true
+		})
+	})
+
+	t.Run("reports_readiness_with_leader_election_enabled", func(t *testing.T) {
+		ctx, options, _ := runOperator(t, func(o *operator.Options) {
+			o.LeaderElection = true
+			o.LeaderElectionNamespace = "default"
+		})
+
+		createClusterRoleBinding(ctx, t, options)
+		createMutatingWebhookConfiguration(ctx, t, options)
+
+		url := fmt.Sprintf("http://%s/readyz", options.HealthProbeBindAddress)
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			t.Fatalf("creating request: %v", err)
+		}
+
+		retryUntilFinished(func() bool {
+			resp, err := http.DefaultClient.Do(req) //nolint:bodyclose
+
+			defer closeResponseBody(t, resp)
+
+			if err != nil {
+				if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+					t.Fatalf("test timed out: %v", err)
+				}
+
+				t.Logf("fetching readiness probe: %v", err)
+
+				time.Sleep(1 * time.Second)
+
+				return false
+			}
+
+			// Readiness checks like preflight checks may need a moment to pass after operator starts.
+			if resp.StatusCode == http.StatusInternalServerError {
+				t.Logf("operator not ready yet: %v", resp)
+
+				time.Sleep(1 * time.Second)
+
+				return false
+			}
+
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("got non 200 response code: %v", resp)
+			}
+
+			return true
+		})
+	})
+
 	t.Run("fails_when", func(t *testing.T) {
 		t.Run("there_is_no_kubernetes_credentials_available", func(t *testing.T) {
 			ctx := testutil.ContextWithDeadline(t)
@@ -444,8 +559,9 @@ func createClusterRoleBinding(ctx context.Context, t *testing.T, options operato
 			Name: fmt.Sprintf("%s%s", testPrefix, agent.ClusterRoleBindingSuffix),
 		},
 		RoleRef: rbacv1.RoleRef{
-			// Note that we are not interested into having the real role bound.
-			Name: "view",
+			// Note that we are not interested into having the real role bound, but preflight checks
+			// verify the name of referenced role.
+			Name: fmt.Sprintf("%s%s", testPrefix, agent.ClusterRoleBindingSuffix),
 			Kind: "ClusterRole",
 		},
 	}
@@ -575,3 +691,46 @@ func dirWithCertsAndCA(t *testing.T) (string, []byte) {
 
 	return dir, cert.Bytes()
 }
+
+func createMutatingWebhookConfiguration(ctx context.Context, t *testing.T, options operator.Options) {
+	t.Helper()
+
+	c, err := client.New(options.RestConfig, client.Options{})
+	if err != nil {
+		t.Fatalf("initializing client: %v", err)
+	}
+
+	path := operator.PodMutateEndpoint
+	sideEffects := admissionregistrationv1.SideEffectClassNone
+
+	mwc := admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: testPrefix,
+		},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{
+			{
+				Name: "newrelic-infra-operator.newrelic.com",
+				ClientConfig: admissionregistrationv1.WebhookClientConfig{
+					Service: &admissionregistrationv1.ServiceReference{
+						Name:      testPrefix,
+						Namespace: "default",
+						Path:      &path,
+					},
+				},
+				SideEffects:             &sideEffects,
+				AdmissionReviewVersions: []string{"v1"},
+			},
+		},
+	}
+
+	// Making sure that preflight checks pass.
+	if err := c.Create(ctx, &mwc, &client.CreateOptions{}); err != nil {
+		t.Fatalf("creating MutatingWebhookConfiguration: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := c.Delete(ctx, &mwc, &client.DeleteOptions{}); err != nil {
+			t.Logf("removing MutatingWebhookConfiguration: %v", err)
+		}
+	})
+}
@@ -5,13 +5,20 @@ package operator
 
 import (
 	"context"
-	"encoding/json"
+	"fmt"
 	"net/http"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
 	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/newrelic/newrelic-infra-operator/internal/audit"
+	"github.com/newrelic/newrelic-infra-operator/internal/tracing"
 	"github.com/newrelic/newrelic-infra-operator/internal/webhook"
 )
 
@@ -24,42 +31,179 @@ type podMutatorHandler struct {
 	mutators             []podMutator
 	ignoreMutationErrors bool
 	logger               logr.Logger
+	auditSink            audit.Sink
+	tracer               trace.Tracer
 }
 
 // Handle is in charge of handling the request received involving new pods.
 func (a *podMutatorHandler) Handle(ctx context.Context, req admission.Request) admission.Response {
+	start := time.Now()
 	pod := &corev1.Pod{}
 
-	if err := a.decoder.Decode(req, pod); err != nil {
-		return admission.Errored(http.StatusBadRequest, err)
-	}
-
 	requestOptions := webhook.RequestOptions{
 		Namespace: req.Namespace,
+		Decision: &webhook.Decision{
+			PolicyIndex: -1,
+		},
+		Patch: &webhook.Patch{},
 	}
 
 	if req.DryRun != nil {
 		requestOptions.DryRun = *req.DryRun
 	}
 
+	ctx, span := a.tracer.Start(ctx, "podMutatorHandler.Handle", trace.WithAttributes(
+		attribute.String("k8s.admission.uid", string(req.UID)),
+		attribute.String("k8s.namespace.name", req.Namespace),
+		attribute.Bool("k8s.admission.dry_run", requestOptions.DryRun),
+	))
+
+	resp, err := a.handle(ctx, req, pod, requestOptions)
+
+	span.SetAttributes(
+		attribute.Bool("newrelic.infra_operator.inject", requestOptions.Decision.Inject),
+		attribute.String("newrelic.infra_operator.reason", requestOptions.Decision.Reason),
+	)
+	tracing.End(span, err)
+
+	latency := time.Since(start)
+
+	a.audit(req, pod, requestOptions, resp, err, latency)
+
+	a.requestLogger(req, pod).V(1).Info("Admission request handled",
+		"inject", requestOptions.Decision.Inject, "reason", requestOptions.Decision.Reason, "latency", latency.String())
+
+	return resp
+}
+
+// requestLogger returns logger annotated with details of given admission request, so all log entries
+// related to single admission can be correlated.
+func (a *podMutatorHandler) requestLogger(req admission.Request, pod *corev1.Pod) logr.Logger {
+	return a.logger.WithValues(
+		"uid", req.UID,
+		"namespace", req.Namespace,
+		"generateName", pod.GenerateName,
+		"owner", podOwner(pod),
+	)
+}
+
+func (a *podMutatorHandler) handle(
+	ctx context.Context,
+	req admission.Request,
+	pod *corev1.Pod,
+	requestOptions webhook.RequestOptions,
+) (admission.Response, error) {
+	_, decodeSpan := a.tracer.Start(ctx, "decode")
+	err := a.decoder.Decode(req, pod)
+	tracing.End(decodeSpan, err)
+
+	if err != nil {
+		a.requestLogger(req, pod).Error(err, "Decoding Pod failed")
+
+		return admission.Errored(http.StatusBadRequest, err), fmt.Errorf("decoding Pod: %w", err)
+	}
+
+	logger := a.requestLogger(req, pod)
+
+	// Mutators log using logger from the context, so their log entries carry request details as well.
+	ctx = logr.NewContext(ctx, logger)
+
 	for _, m := range a.mutators {
-		if err := m.Mutate(ctx, pod, requestOptions); err != nil {
+		if err := a.mutate(ctx, m, pod, requestOptions); err != nil {
 			if a.ignoreMutationErrors {
-				a.logger.Error(err, "Pod %s/%s mutation failed", pod.Name, req.Namespace, err)
+				logger.Error(err, "Pod mutation failed, admitting Pod without mutation")
 				// Return the original unmodified pod without mutation
-				return admission.PatchResponseFromRaw(req.Object.Raw, req.Object.Raw)
+				return admission.PatchResponseFromRaw(req.Object.Raw, req.Object.Raw), err
 			}
 
-			return admission.Errored(http.StatusInternalServerError, err)
+			logger.Error(err, "Pod mutation failed")
+
+			return admission.Errored(http.StatusInternalServerError, err), err
 		}
 	}
 
-	marshaledPod, err := json.Marshal(pod)
+	// Mutators describe their changes explicitly, so the Pod does not have to be marshaled and diffed against
+	// the request object, which could produce spurious operations e.g. from fields reordering.
+	return admission.Patched("", requestOptions.Patch.Operations...), nil
+}
+
+// mutate runs given mutator within its own span.
+func (a *podMutatorHandler) mutate(
+	ctx context.Context,
+	m podMutator,
+	pod *corev1.Pod,
+	requestOptions webhook.RequestOptions,
+) error {
+	ctx, span := a.tracer.Start(ctx, "Mutate", trace.WithAttributes(
+		attribute.String("newrelic.infra_operator.mutator", fmt.Sprintf("%T", m)),
+	))
+
+	err := m.Mutate(ctx, pod, requestOptions)
+
+	tracing.End(span, err)
+
+	return err
+}
+
+// audit emits audit record describing decision made for given request, based on the response sent for it.
+func (a *podMutatorHandler) audit(
+	req admission.Request,
+	pod *corev1.Pod,
+	requestOptions webhook.RequestOptions,
+	resp admission.Response,
+	err error,
+	latency time.Duration,
+) {
+	if a.auditSink == nil {
+		return
+	}
+
+	decision := requestOptions.Decision
+
+	record := audit.Record{
+		Time:         time.Now(),
+		UID:          string(req.UID),
+		Namespace:    req.Namespace,
+		GenerateName: pod.GenerateName,
+		Owner:        podOwner(pod),
+		Reason:       decision.Reason,
+		PolicyIndex:  decision.PolicyIndex,
+		Rule:         decision.Rule,
+		ConfigHash:   decision.ConfigHash,
+		DryRun:       requestOptions.DryRun,
+		LatencyMs:    float64(latency) / float64(time.Millisecond),
+	}
+
+	switch {
+	case err != nil && resp.Allowed:
+		record.Decision = audit.DecisionIgnored
+	case err != nil:
+		record.Decision = audit.DecisionErrored
+	case decision.Inject:
+		record.Decision = audit.DecisionInjected
+	default:
+		record.Decision = audit.DecisionSkipped
+	}
+
 	if err != nil {
-		return admission.Errored(http.StatusInternalServerError, err)
+		record.Errors = []string{err.Error()}
+	}
+
+	a.auditSink.Emit(record)
+}
+
+// podOwner returns kind and name of Pod controller or first owner, if Pod has no controller.
+func podOwner(pod *corev1.Pod) string {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil && len(pod.OwnerReferences) > 0 {
+		owner = &pod.OwnerReferences[0]
+	}
+
+	if owner == nil {
+		return ""
 	}
 
-	return admission.PatchResponseFromRaw(req.Object.Raw, marshaledPod)
+	return fmt.Sprintf("%s/%s", owner.Kind, owner.Name)
 }
 
 // InjectDecoder injects the decoder and is useful to respect the DecoderInjector interface.
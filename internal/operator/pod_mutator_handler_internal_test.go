@@ -8,15 +8,22 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strings"
 	"testing"
 
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
 	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+	"github.com/newrelic/newrelic-infra-operator/internal/audit"
 	"github.com/newrelic/newrelic-infra-operator/internal/testutil"
+	"github.com/newrelic/newrelic-infra-operator/internal/tracing"
 	"github.com/newrelic/newrelic-infra-operator/internal/webhook"
 )
 
@@ -49,7 +56,9 @@ func Test_Pod_mutator_handle(t *testing.T) {
 
 		handler.mutators = []podMutator{
 			&mockMutator{
-				mutateF: func(_ context.Context, pod *corev1.Pod, _ webhook.RequestOptions) error {
+				mutateF: func(_ context.Context, pod *corev1.Pod, requestOptions webhook.RequestOptions) error {
+					requestOptions.AddPatch(webhook.AddLabel(pod.Labels, "foo", "bar"))
+
 					pod.Labels = map[string]string{"foo": "bar"}
 
 					return nil
@@ -97,6 +106,192 @@ func Test_Pod_mutator_handle(t *testing.T) {
 		}
 	})
 
+	t.Run("emits_audit_record_with", func(t *testing.T) {
+		t.Parallel()
+
+		cases := map[string]struct {
+			mutateF              func(context.Context, *corev1.Pod, webhook.RequestOptions) error
+			ignoreMutationErrors bool
+			// malformed, if true, sends request with Pod which cannot be decoded.
+			malformed           bool
+			expectedDecision    string
+			expectedPolicyIndex int
+		}{
+			"injected_decision_when_mutator_decides_to_inject": {
+				mutateF: func(_ context.Context, _ *corev1.Pod, opts webhook.RequestOptions) error {
+					opts.Record(webhook.Decision{Inject: true, PolicyIndex: 1, ConfigHash: "foo"})
+
+					return nil
+				},
+				expectedDecision:    audit.DecisionInjected,
+				expectedPolicyIndex: 1,
+			},
+			"skipped_decision_when_mutator_decides_not_to_inject": {
+				mutateF: func(_ context.Context, _ *corev1.Pod, opts webhook.RequestOptions) error {
+					opts.Record(webhook.Decision{PolicyIndex: -1, Reason: "no policy matched"})
+
+					return nil
+				},
+				expectedDecision:    audit.DecisionSkipped,
+				expectedPolicyIndex: -1,
+			},
+			"errored_decision_when_mutation_fails": {
+				mutateF: func(_ context.Context, _ *corev1.Pod, _ webhook.RequestOptions) error {
+					return fmt.Errorf("test error")
+				},
+				expectedDecision:    audit.DecisionErrored,
+				expectedPolicyIndex: -1,
+			},
+			"error_ignored_decision_when_mutation_fails_and_ignoring_errors_is_enabled": {
+				mutateF: func(_ context.Context, _ *corev1.Pod, _ webhook.RequestOptions) error {
+					return fmt.Errorf("test error")
+				},
+				ignoreMutationErrors: true,
+				expectedDecision:     audit.DecisionIgnored,
+				expectedPolicyIndex:  -1,
+			},
+			"errored_decision_when_request_is_malformed_and_ignoring_errors_is_enabled": {
+				ignoreMutationErrors: true,
+				malformed:            true,
+				expectedDecision:     audit.DecisionErrored,
+				expectedPolicyIndex:  -1,
+			},
+		}
+
+		for testCaseName, testData := range cases {
+			testData := testData
+
+			t.Run(testCaseName, func(t *testing.T) {
+				t.Parallel()
+
+				sink := &mockSink{}
+
+				handler := newHandler(t)
+				handler.auditSink = sink
+				handler.ignoreMutationErrors = testData.ignoreMutationErrors
+				handler.mutators = []podMutator{&mockMutator{mutateF: testData.mutateF}}
+
+				req := testRequest()
+				req.UID = "test-uid"
+				req.Namespace = "test-namespace"
+
+				if testData.malformed {
+					req.Object.Raw = []byte(`{`)
+				}
+
+				handler.Handle(ctx, req)
+
+				if len(sink.records) != 1 {
+					t.Fatalf("expected exactly one audit record, got %v", sink.records)
+				}
+
+				record := sink.records[0]
+
+				if record.Decision != testData.expectedDecision {
+					t.Fatalf("expected decision %q, got %q", testData.expectedDecision, record.Decision)
+				}
+
+				if record.PolicyIndex != testData.expectedPolicyIndex {
+					t.Fatalf("expected policy index %d, got %d", testData.expectedPolicyIndex, record.PolicyIndex)
+				}
+
+				if record.UID != "test-uid" || record.Namespace != "test-namespace" {
+					t.Fatalf("expected request details to be recorded, got %v", record)
+				}
+
+				if hasErrors := len(record.Errors) > 0; hasErrors != (testData.expectedDecision == audit.DecisionErrored ||
+					testData.expectedDecision == audit.DecisionIgnored) {
+					t.Fatalf("unexpected errors in record: %v", record.Errors)
+				}
+			})
+		}
+	})
+
+	t.Run("passes_logger_with_admission_request_details_to_mutators", func(t *testing.T) {
+		t.Parallel()
+
+		handler := newHandler(t)
+
+		logs := []string{}
+		handler.logger = funcr.New(func(prefix, args string) {
+			logs = append(logs, args)
+		}, funcr.Options{})
+
+		handler.mutators = []podMutator{
+			&mockMutator{
+				mutateF: func(ctx context.Context, _ *corev1.Pod, _ webhook.RequestOptions) error {
+					logr.FromContextOrDiscard(ctx).Info("test")
+
+					return nil
+				},
+			},
+		}
+
+		req := testRequest()
+		req.UID = "test-uid"
+		req.Namespace = "test-namespace"
+
+		handler.Handle(ctx, req)
+
+		if len(logs) != 1 {
+			t.Fatalf("expected exactly one log entry, got %v", logs)
+		}
+
+		for _, expected := range []string{
+			`"uid"="test-uid"`,
+			`"namespace"="test-namespace"`,
+			`"generateName"="foo-"`,
+			`"owner"="ReplicaSet/foo"`,
+		} {
+			if !strings.Contains(logs[0], expected) {
+				t.Fatalf("expected log entry %q to contain %q", logs[0], expected)
+			}
+		}
+	})
+
+	t.Run("traces_admission_request_and_each_mutation", func(t *testing.T) {
+		t.Parallel()
+
+		recorder := tracetest.NewSpanRecorder()
+
+		handler := newHandler(t)
+		handler.tracer = tracing.Tracer(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+		handler.mutators = []podMutator{
+			&mockMutator{
+				mutateF: func(_ context.Context, _ *corev1.Pod, _ webhook.RequestOptions) error {
+					return fmt.Errorf("mutation failed")
+				},
+			},
+		}
+
+		handler.Handle(ctx, testRequest())
+
+		spans := map[string]sdktrace.ReadOnlySpan{}
+		for _, span := range recorder.Ended() {
+			spans[span.Name()] = span
+		}
+
+		handleSpan, ok := spans["podMutatorHandler.Handle"]
+		if !ok {
+			t.Fatalf("expected admission request span, got %v", spans)
+		}
+
+		if handleSpan.Status().Code != codes.Error {
+			t.Fatalf("expected admission request span to have error status, got %v", handleSpan.Status())
+		}
+
+		for _, name := range []string{"decode", "Mutate"} {
+			span, ok := spans[name]
+			if !ok {
+				t.Fatalf("expected %q span, got %v", name, spans)
+			}
+
+			if span.Parent().SpanID() != handleSpan.SpanContext().SpanID() {
+				t.Fatalf("expected %q span to be child of admission request span", name)
+			}
+		}
+	})
+
 	t.Run("returns_error_when", func(t *testing.T) {
 		t.Parallel()
 
@@ -151,6 +346,18 @@ func (m *mockMutator) Mutate(ctx context.Context, pod *corev1.Pod, reqOptions we
 	return m.mutateF(ctx, pod, reqOptions)
 }
 
+type mockSink struct {
+	records []audit.Record
+}
+
+func (m *mockSink) Emit(record audit.Record) {
+	m.records = append(m.records, record)
+}
+
+func (m *mockSink) Close() error {
+	return nil
+}
+
 func testRequest() admission.Request {
 	return admission.Request{
 		AdmissionRequest: admissionv1.AdmissionRequest{
@@ -160,6 +367,16 @@ func testRequest() admission.Request {
     "kind": "Pod",
     "metadata": {
         "name": "foo",
+        "generateName": "foo-",
+        "ownerReferences": [
+            {
+                "apiVersion": "apps/v1",
+                "kind": "ReplicaSet",
+                "name": "foo",
+                "uid": "test",
+                "controller": true
+            }
+        ],
         "creationTimestamp": "2021-04-29T11:15:14Z"
     },
     "spec": {
@@ -191,5 +408,6 @@ func newHandler(t *testing.T) *podMutatorHandler {
 	return &podMutatorHandler{
 		decoder: d,
 		logger:  logr.Logger{},
+		tracer:  tracing.Tracer(nil),
 	}
 }
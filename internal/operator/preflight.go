@@ -0,0 +1,110 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package operator
+
+import (
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/newrelic/newrelic-infra-operator/internal/mutator/pod/agent"
+	"github.com/newrelic/newrelic-infra-operator/internal/preflight"
+)
+
+// PreflightEndpoint is a URI on metrics server where results of preflight checks are served. It is not available
+// when metrics server is disabled, then only aggregated result is served on health probe server as readiness
+// check "/readyz/preflight".
+const PreflightEndpoint = "/preflight"
+
+// preflightChecks returns checks verifying that resources and permissions required by operator configured
+// with given options are in place.
+func preflightChecks(options Options, c client.Client) []preflight.Check {
+	clusterRoleBindingName := options.InfraAgentInjection.ResourcePrefix + agent.ClusterRoleBindingSuffix
+
+	checks := []preflight.Check{
+		// ClusterRole bound to the agents is created by the chart with the same name as the ClusterRoleBinding.
+		preflight.ClusterRoleBindingCheck(c, clusterRoleBindingName, clusterRoleBindingName),
+		preflight.MutatingWebhookCheck(c, PodMutateEndpoint),
+	}
+
+	licenseSecretName := options.InfraAgentInjection.ResourcePrefix + agent.LicenseSecretSuffix
+
+	for _, attributes := range requiredPermissions(options, clusterRoleBindingName, licenseSecretName) {
+		checks = append(checks, preflight.AccessCheck(c, attributes))
+	}
+
+	return checks
+}
+
+func requiredPermissions(
+	options Options,
+	clusterRoleBindingName string,
+	licenseSecretName string,
+) []authorizationv1.ResourceAttributes {
+	permissions := []authorizationv1.ResourceAttributes{
+		// Operator is only allowed to read and update license Secrets it creates.
+		{Verb: "get", Resource: "secrets", Name: licenseSecretName},
+		{Verb: "create", Resource: "secrets"},
+		{Verb: "update", Resource: "secrets", Name: licenseSecretName},
+		{Verb: "create", Resource: "configmaps"},
+		{Verb: "list", Group: "rbac.authorization.k8s.io", Resource: "clusterrolebindings"},
+		{Verb: "watch", Group: "rbac.authorization.k8s.io", Resource: "clusterrolebindings"},
+		{Verb: "update", Group: "rbac.authorization.k8s.io", Resource: "clusterrolebindings", Name: clusterRoleBindingName},
+		{Verb: "list", Group: "admissionregistration.k8s.io", Resource: "mutatingwebhookconfigurations"},
+		// Required by the event recorder emitting events when injection is skipped.
+		{Verb: "create", Group: "events.k8s.io", Resource: "events"},
+	}
+
+	// Namespaces are served from cache, falling back to the API when cache is not synced yet.
+	if options.InfraAgentInjection.LooksUpNamespaces() {
+		for _, verb := range []string{"get", "list", "watch"} {
+			permissions = append(permissions, authorizationv1.ResourceAttributes{Verb: verb, Resource: "namespaces"})
+		}
+	}
+
+	if namespaceConstraintsChecked(options.InfraAgentInjection) {
+		for _, resource := range []string{"limitranges", "resourcequotas"} {
+			for _, verb := range []string{"list", "watch"} {
+				permissions = append(permissions, authorizationv1.ResourceAttributes{Verb: verb, Resource: resource})
+			}
+		}
+	}
+
+	for shard := range options.InfraAgentInjection.ClusterRoleBindingSharding.Shards {
+		permissions = append(permissions, authorizationv1.ResourceAttributes{
+			Verb:     "update",
+			Group:    "rbac.authorization.k8s.io",
+			Resource: "clusterrolebindings",
+			Name:     agent.ShardClusterRoleBindingName(clusterRoleBindingName, shard),
+		})
+	}
+
+	if options.LeaderElection && options.LeaderElectionNamespace != "" {
+		for _, verb := range []string{"get", "create", "update"} {
+			permissions = append(permissions, authorizationv1.ResourceAttributes{
+				Verb:      verb,
+				Group:     "coordination.k8s.io",
+				Resource:  "leases",
+				Namespace: options.LeaderElectionNamespace,
+			})
+		}
+	}
+
+	return permissions
+}
+
+// namespaceConstraintsChecked returns true if injector with given configuration fetches LimitRanges and
+// ResourceQuotas of Pod's Namespace, which happens when sidecar is sized or quota policy is configured.
+func namespaceConstraintsChecked(config agent.InjectorConfig) bool {
+	if config.ResourceQuotaPolicy != "" {
+		return true
+	}
+
+	for _, selector := range config.AgentConfig.ConfigSelectors {
+		if selector.Sizing != nil {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,236 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package operator
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"text/template"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/yaml"
+
+	"github.com/newrelic/newrelic-infra-operator/internal/mutator/pod/agent"
+	"github.com/newrelic/newrelic-infra-operator/internal/testutil"
+)
+
+const (
+	clusterRoleTemplate = "../../charts/newrelic-infra-operator/templates/clusterrole.yaml"
+	testReleaseName     = "newrelic-infra-operator"
+)
+
+// Test_Preflight_permissions verifies that permissions checked by preflight are granted by the ClusterRole
+// shipped with the chart, so operator deployed using the chart becomes ready.
+func Test_Preflight_permissions(t *testing.T) {
+	t.Parallel()
+
+	ctx := testutil.ContextWithDeadline(t)
+
+	for name, config := range map[string]agent.InjectorConfig{
+		"without_sharding": {},
+		"with_sharding": {
+			ClusterRoleBindingSharding: agent.ClusterRoleBindingSharding{Shards: 4, MaxSubjects: 100},
+		},
+		"with_resource_quota_policy": {ResourceQuotaPolicy: agent.ResourceQuotaPolicyShrink},
+		"with_pod_security_mode":     {PodSecurityMode: agent.PodSecurityModeAdapt},
+		"with_sizing": {
+			AgentConfig: agent.InfraAgentConfig{
+				ConfigSelectors: []agent.ConfigSelector{{Sizing: &agent.ResourceSizing{}}},
+			},
+		},
+	} {
+		config := config
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			rules := chartClusterRoleRules(t, config.ClusterRoleBindingSharding)
+
+			c := fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+				Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+					if review, ok := obj.(*authorizationv1.SelfSubjectAccessReview); ok {
+						review.Status.Allowed = allowedBy(rules, *review.Spec.ResourceAttributes)
+
+						return nil
+					}
+
+					return c.Create(ctx, obj, opts...)
+				},
+			}).Build()
+
+			options := Options{InfraAgentInjection: config}
+			options.InfraAgentInjection.ResourcePrefix = testReleaseName
+
+			for _, check := range preflightChecks(options, c) {
+				if !strings.HasPrefix(check.Name, "Permission") {
+					continue
+				}
+
+				if err := check.Run(ctx); err != nil {
+					t.Errorf("check %q failed: %v", check.Name, err)
+				}
+			}
+		})
+	}
+}
+
+// Test_Preflight_conditional_permissions verifies that permissions for reading Namespaces, LimitRanges and
+// ResourceQuotas are only required when injector fetches them.
+func Test_Preflight_conditional_permissions(t *testing.T) {
+	t.Parallel()
+
+	conditionalPermissions := []authorizationv1.ResourceAttributes{
+		{Verb: "get", Resource: "namespaces"},
+		{Verb: "list", Resource: "namespaces"},
+		{Verb: "watch", Resource: "namespaces"},
+		{Verb: "list", Resource: "limitranges"},
+		{Verb: "watch", Resource: "limitranges"},
+		{Verb: "list", Resource: "resourcequotas"},
+		{Verb: "watch", Resource: "resourcequotas"},
+	}
+
+	for name, testData := range map[string]struct {
+		config            agent.InjectorConfig
+		requiredResources []string
+	}{
+		"are_not_required_by_default": {},
+		"are_not_required_for_policies_without_namespace_selector": {
+			config: agent.InjectorConfig{Policies: []agent.InjectionPolicy{{NamespaceName: "default"}}},
+		},
+		"for_namespaces_are_required_when_policy_has_namespace_selector": {
+			config: agent.InjectorConfig{
+				Policies: []agent.InjectionPolicy{{}, {NamespaceSelector: &metav1.LabelSelector{}}},
+			},
+			requiredResources: []string{"namespaces"},
+		},
+		"for_namespaces_are_required_when_pod_security_mode_is_configured": {
+			config:            agent.InjectorConfig{PodSecurityMode: agent.PodSecurityModeAdapt},
+			requiredResources: []string{"namespaces"},
+		},
+		"for_namespace_constraints_are_required_when_resource_quota_policy_is_configured": {
+			config:            agent.InjectorConfig{ResourceQuotaPolicy: agent.ResourceQuotaPolicySkip},
+			requiredResources: []string{"limitranges", "resourcequotas"},
+		},
+		"for_namespace_constraints_are_required_when_sizing_is_configured": {
+			config: agent.InjectorConfig{
+				AgentConfig: agent.InfraAgentConfig{
+					ConfigSelectors: []agent.ConfigSelector{{}, {Sizing: &agent.ResourceSizing{}}},
+				},
+			},
+			requiredResources: []string{"limitranges", "resourcequotas"},
+		},
+	} {
+		testData := testData
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			permissions := requiredPermissions(Options{InfraAgentInjection: testData.config}, "", "")
+
+			for _, attributes := range conditionalPermissions {
+				expected := slices.Contains(testData.requiredResources, attributes.Resource)
+
+				if required := slices.Contains(permissions, attributes); required != expected {
+					t.Errorf("expected %s %s permission to be required: %v, got %v", attributes.Verb,
+						attributes.Resource, expected, required)
+				}
+			}
+		})
+	}
+}
+
+// chartClusterRoleRules renders operator ClusterRole from the chart template with given sharding configuration
+// and returns its rules.
+func chartClusterRoleRules(t *testing.T, sharding agent.ClusterRoleBindingSharding) []rbacv1.PolicyRule {
+	t.Helper()
+
+	content, err := os.ReadFile(clusterRoleTemplate)
+	if err != nil {
+		t.Fatalf("reading ClusterRole template: %v", err)
+	}
+
+	names := map[string]string{
+		"newrelic.common.naming.fullname":                      testReleaseName,
+		"newrelic-infra-operator.fullname.config":              testReleaseName + agent.LicenseSecretSuffix,
+		"newrelic-infra-operator.fullname.infra-agent":         testReleaseName + agent.ClusterRoleBindingSuffix,
+		"newrelic.common.labels":                               "",
+		"newrelic-infra-operator.infra-agent-monitoring-rules": "",
+	}
+
+	tmpl, err := template.New("clusterrole").Funcs(template.FuncMap{
+		"include": func(name string, _ any) string { return names[name] },
+		"quote":   strconv.Quote,
+		"nindent": func(indent int, s string) string {
+			return "\n" + strings.Repeat(" ", indent) + s
+		},
+		"int": func(i int) int { return i },
+		"until": func(n int) []int {
+			numbers := make([]int, 0, n)
+			for i := range n {
+				numbers = append(numbers, i)
+			}
+
+			return numbers
+		},
+	}).Parse(string(content))
+	if err != nil {
+		t.Fatalf("parsing ClusterRole template: %v", err)
+	}
+
+	infraAgentInjection := map[string]any{}
+	if sharding.Shards > 0 {
+		infraAgentInjection["clusterRoleBindingSharding"] = map[string]any{
+			"shards":      sharding.Shards,
+			"maxSubjects": sharding.MaxSubjects,
+		}
+	}
+
+	values := map[string]any{
+		"Values": map[string]any{
+			"config": map[string]any{"infraAgentInjection": infraAgentInjection},
+		},
+	}
+
+	rendered := &bytes.Buffer{}
+	if err := tmpl.Execute(rendered, values); err != nil {
+		t.Fatalf("rendering ClusterRole template: %v", err)
+	}
+
+	clusterRole := &rbacv1.ClusterRole{}
+
+	operatorClusterRole := strings.Split(rendered.String(), "\n---\n")[0]
+	if err := yaml.UnmarshalStrict([]byte(operatorClusterRole), clusterRole); err != nil {
+		t.Fatalf("decoding ClusterRole: %v\n%s", err, operatorClusterRole)
+	}
+
+	return clusterRole.Rules
+}
+
+// allowedBy returns true if given action is allowed by any of given rules.
+func allowedBy(rules []rbacv1.PolicyRule, attributes authorizationv1.ResourceAttributes) bool {
+	matches := func(values []string, value string) bool {
+		return slices.Contains(values, value) || slices.Contains(values, rbacv1.VerbAll)
+	}
+
+	for _, rule := range rules {
+		if matches(rule.Verbs, attributes.Verb) &&
+			matches(rule.APIGroups, attributes.Group) &&
+			matches(rule.Resources, attributes.Resource) &&
+			(len(rule.ResourceNames) == 0 || slices.Contains(rule.ResourceNames, attributes.Name)) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,122 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ClusterRoleBindingCheck verifies that ClusterRoleBinding with given name exists and references given ClusterRole.
+func ClusterRoleBindingCheck(c client.Reader, name, clusterRoleName string) Check {
+	return Check{
+		Name: fmt.Sprintf("ClusterRoleBinding %s", name),
+		Run: func(ctx context.Context) error {
+			crb := &rbacv1.ClusterRoleBinding{}
+
+			if err := c.Get(ctx, client.ObjectKey{Name: name}, crb); err != nil {
+				return fmt.Errorf("getting ClusterRoleBinding: %w", err)
+			}
+
+			if crb.RoleRef.Kind != "ClusterRole" || crb.RoleRef.Name != clusterRoleName {
+				//nolint:err113
+				return fmt.Errorf("ClusterRoleBinding references %s %q, expected ClusterRole %q",
+					crb.RoleRef.Kind, crb.RoleRef.Name, clusterRoleName)
+			}
+
+			return nil
+		},
+	}
+}
+
+// AccessCheck verifies using SelfSubjectAccessReview that the operator is allowed to perform given action.
+func AccessCheck(c client.Client, attributes authorizationv1.ResourceAttributes) Check {
+	return Check{
+		Name: accessCheckName(attributes),
+		Run: func(ctx context.Context) error {
+			review := &authorizationv1.SelfSubjectAccessReview{
+				Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+					ResourceAttributes: &attributes,
+				},
+			}
+
+			if err := c.Create(ctx, review); err != nil {
+				return fmt.Errorf("creating SelfSubjectAccessReview: %w", err)
+			}
+
+			if !review.Status.Allowed {
+				//nolint:err113
+				return fmt.Errorf("action is not allowed: %s", review.Status.Reason)
+			}
+
+			return nil
+		},
+	}
+}
+
+func accessCheckName(attributes authorizationv1.ResourceAttributes) string {
+	resource := attributes.Resource
+	if attributes.Group != "" {
+		resource = fmt.Sprintf("%s.%s", resource, attributes.Group)
+	}
+
+	parts := []string{"Permission", attributes.Verb, resource}
+
+	if attributes.Name != "" {
+		parts = append(parts, attributes.Name)
+	}
+
+	if attributes.Namespace != "" {
+		parts = append(parts, "in", attributes.Namespace)
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// MutatingWebhookCheck verifies that there is MutatingWebhookConfiguration with webhook sending requests
+// to given path.
+func MutatingWebhookCheck(c client.Reader, path string) Check {
+	return Check{
+		Name: fmt.Sprintf("MutatingWebhookConfiguration for %s", path),
+		Run: func(ctx context.Context) error {
+			configurations := &admissionregistrationv1.MutatingWebhookConfigurationList{}
+
+			if err := c.List(ctx, configurations); err != nil {
+				return fmt.Errorf("listing MutatingWebhookConfigurations: %w", err)
+			}
+
+			for _, configuration := range configurations.Items {
+				for _, webhook := range configuration.Webhooks {
+					if webhookPath(webhook.ClientConfig) == path {
+						return nil
+					}
+				}
+			}
+
+			//nolint:err113
+			return fmt.Errorf("no webhook is configured with path %q", path)
+		},
+	}
+}
+
+func webhookPath(config admissionregistrationv1.WebhookClientConfig) string {
+	if config.Service != nil && config.Service.Path != nil {
+		return *config.Service.Path
+	}
+
+	if config.URL != nil {
+		if u, err := url.Parse(*config.URL); err == nil {
+			return u.Path
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,191 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package preflight implements checks verifying that cluster resources and permissions required by the operator
+// are in place.
+package preflight
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+const (
+	// DefaultRetryInterval is a default maximum interval in which checks are re-run until all of them pass.
+	DefaultRetryInterval = 30 * time.Second
+
+	initialRetryInterval = time.Second
+
+	tableMinWidth = 0
+	tableTabWidth = 8
+	tablePadding  = 2
+)
+
+// Check is a single named preflight check.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Result holds outcome of single preflight check.
+type Result struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message,omitempty"`
+}
+
+// Preflight runs configured checks when started and keeps their results, so they can be exposed via readiness
+// check and status endpoint. When some of the checks fail, all checks are re-run with exponentially growing
+// interval until all of them pass.
+type Preflight struct {
+	checks        []Check
+	logger        logr.Logger
+	retryInterval time.Duration
+
+	lock    sync.RWMutex
+	results []Result
+}
+
+// New creates preflight runner for given checks.
+func New(logger logr.Logger, checks ...Check) *Preflight {
+	return &Preflight{
+		checks:        checks,
+		logger:        logger,
+		retryInterval: DefaultRetryInterval,
+	}
+}
+
+// Add adds given checks. It must be called before preflight is started.
+func (p *Preflight) Add(checks ...Check) {
+	p.checks = append(p.checks, checks...)
+}
+
+// Start runs the checks until all of them pass or given context is cancelled.
+func (p *Preflight) Start(ctx context.Context) error {
+	interval := min(initialRetryInterval, p.retryInterval)
+
+	for !p.run(ctx, interval) {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+
+		interval = min(2*interval, p.retryInterval)
+	}
+
+	return nil
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, as every operator instance must verify its setup.
+func (p *Preflight) NeedLeaderElection() bool {
+	return false
+}
+
+// run runs all checks, logs and stores their results. It returns true, if all checks passed.
+func (p *Preflight) run(ctx context.Context, retryInterval time.Duration) bool {
+	results := make([]Result, 0, len(p.checks))
+	passed := true
+
+	for _, check := range p.checks {
+		result := Result{
+			Name:   check.Name,
+			Passed: true,
+		}
+
+		if err := check.Run(ctx); err != nil {
+			result.Passed = false
+			result.Message = err.Error()
+			passed = false
+		}
+
+		results = append(results, result)
+	}
+
+	p.lock.Lock()
+	p.results = results
+	p.lock.Unlock()
+
+	if passed {
+		p.logger.Info("Preflight checks passed\n" + table(results))
+	} else {
+		p.logger.Info("Preflight checks failed\n"+table(results), "retryInterval", retryInterval.String())
+	}
+
+	return passed
+}
+
+// Results returns results of the last run of the checks.
+func (p *Preflight) Results() []Result {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return append([]Result{}, p.results...)
+}
+
+// Checker implements healthz.Checker, failing until all checks passed.
+func (p *Preflight) Checker(_ *http.Request) error {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	if p.results == nil {
+		//nolint:err113
+		return fmt.Errorf("preflight checks have not run yet")
+	}
+
+	failed := []string{}
+
+	for _, result := range p.results {
+		if !result.Passed {
+			failed = append(failed, fmt.Sprintf("%s: %s", result.Name, result.Message))
+		}
+	}
+
+	if len(failed) > 0 {
+		//nolint:err113
+		return fmt.Errorf("preflight checks failed: %s", strings.Join(failed, "; "))
+	}
+
+	return nil
+}
+
+// ServeHTTP responds with results of the last run of the checks encoded as JSON.
+func (p *Preflight) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := p.Checker(nil); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	if err := json.NewEncoder(w).Encode(p.Results()); err != nil {
+		p.logger.Error(err, "Encoding preflight check results")
+	}
+}
+
+func table(results []Result) string {
+	b := &strings.Builder{}
+	w := tabwriter.NewWriter(b, tableMinWidth, tableTabWidth, tablePadding, ' ', 0)
+
+	_, _ = fmt.Fprintln(w, "CHECK\tSTATUS\tMESSAGE")
+
+	for _, result := range results {
+		status := "PASS"
+		if !result.Passed {
+			status = "FAIL"
+		}
+
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", result.Name, status, result.Message)
+	}
+
+	_ = w.Flush()
+
+	return b.String()
+}
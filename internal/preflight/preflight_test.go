@@ -0,0 +1,214 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package preflight_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr/testr"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	"github.com/newrelic/newrelic-infra-operator/internal/preflight"
+	"github.com/newrelic/newrelic-infra-operator/internal/testutil"
+)
+
+const (
+	testCRBName = "test-infra-agent"
+	testPath    = "/mutate-v1-pod"
+)
+
+//nolint:funlen
+func Test_Preflight(t *testing.T) {
+	t.Parallel()
+
+	ctx := testutil.ContextWithDeadline(t)
+
+	passing := preflight.Check{Name: "passing", Run: func(context.Context) error { return nil }}
+	failing := preflight.Check{Name: "failing", Run: func(context.Context) error { return errors.New("broken") }}
+
+	t.Run("reports_not_ready_before_checks_run", func(t *testing.T) {
+		t.Parallel()
+
+		p := preflight.New(testr.New(t), passing)
+
+		if err := p.Checker(nil); err == nil {
+			t.Fatalf("expected readiness check to fail")
+		}
+	})
+
+	t.Run("reports_ready_when_all_checks_pass", func(t *testing.T) {
+		t.Parallel()
+
+		p := preflight.New(testr.New(t), passing)
+
+		if err := p.Start(ctx); err != nil {
+			t.Fatalf("running checks: %v", err)
+		}
+
+		if err := p.Checker(nil); err != nil {
+			t.Fatalf("unexpected readiness check error: %v", err)
+		}
+	})
+
+	t.Run("reports_failed_checks_via_readiness_and_status_endpoint", func(t *testing.T) {
+		t.Parallel()
+
+		p := preflight.New(testr.New(t))
+		p.Add(passing, failing)
+
+		startCtx, cancel := context.WithCancel(ctx)
+
+		done := make(chan struct{})
+
+		go func() {
+			_ = p.Start(startCtx)
+
+			close(done)
+		}()
+
+		t.Cleanup(func() {
+			cancel()
+			<-done
+		})
+
+		for len(p.Results()) == 0 {
+			select {
+			case <-ctx.Done():
+				t.Fatalf("timed out waiting for checks to run")
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+
+		if err := p.Checker(nil); err == nil {
+			t.Fatalf("expected readiness check to fail")
+		}
+
+		recorder := httptest.NewRecorder()
+		p.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/preflight", nil))
+
+		if recorder.Code != http.StatusServiceUnavailable {
+			t.Fatalf("expected status code %d, got %d", http.StatusServiceUnavailable, recorder.Code)
+		}
+
+		results := []preflight.Result{}
+		if err := json.NewDecoder(recorder.Body).Decode(&results); err != nil {
+			t.Fatalf("decoding results: %v", err)
+		}
+
+		expected := []preflight.Result{
+			{Name: "passing", Passed: true},
+			{Name: "failing", Passed: false, Message: "broken"},
+		}
+
+		if len(results) != len(expected) || results[0] != expected[0] || results[1] != expected[1] {
+			t.Fatalf("expected results %v, got %v", expected, results)
+		}
+	})
+}
+
+//nolint:funlen
+func Test_Checks(t *testing.T) {
+	t.Parallel()
+
+	ctx := testutil.ContextWithDeadline(t)
+
+	crb := func(roleName string) *rbacv1.ClusterRoleBinding {
+		return &rbacv1.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: testCRBName},
+			RoleRef: rbacv1.RoleRef{
+				APIGroup: rbacv1.GroupName,
+				Kind:     "ClusterRole",
+				Name:     roleName,
+			},
+		}
+	}
+
+	mwc := func(path string) *admissionregistrationv1.MutatingWebhookConfiguration {
+		return &admissionregistrationv1.MutatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "test"},
+			Webhooks: []admissionregistrationv1.MutatingWebhook{
+				{
+					Name: "test",
+					ClientConfig: admissionregistrationv1.WebhookClientConfig{
+						Service: &admissionregistrationv1.ServiceReference{Path: &path},
+					},
+				},
+			},
+		}
+	}
+
+	accessClient := func(allowed bool) client.Client {
+		return fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+			Create: func(_ context.Context, _ client.WithWatch, obj client.Object, _ ...client.CreateOption) error {
+				review, ok := obj.(*authorizationv1.SelfSubjectAccessReview)
+				if !ok {
+					return errors.New("unexpected object")
+				}
+
+				review.Status.Allowed = allowed
+
+				return nil
+			},
+		}).Build()
+	}
+
+	access := authorizationv1.ResourceAttributes{Verb: "create", Resource: "secrets"}
+
+	cases := map[string]struct {
+		check       preflight.Check
+		expectError bool
+	}{
+		"ClusterRoleBinding_referencing_expected_ClusterRole_passes": {
+			check: preflight.ClusterRoleBindingCheck(
+				fake.NewClientBuilder().WithObjects(crb(testCRBName)).Build(), testCRBName, testCRBName),
+		},
+		"ClusterRoleBinding_referencing_other_ClusterRole_fails": {
+			check: preflight.ClusterRoleBindingCheck(
+				fake.NewClientBuilder().WithObjects(crb("view")).Build(), testCRBName, testCRBName),
+			expectError: true,
+		},
+		"missing_ClusterRoleBinding_fails": {
+			check:       preflight.ClusterRoleBindingCheck(fake.NewClientBuilder().Build(), testCRBName, testCRBName),
+			expectError: true,
+		},
+		"allowed_access_passes": {
+			check: preflight.AccessCheck(accessClient(true), access),
+		},
+		"denied_access_fails": {
+			check:       preflight.AccessCheck(accessClient(false), access),
+			expectError: true,
+		},
+		"webhook_with_expected_path_passes": {
+			check: preflight.MutatingWebhookCheck(fake.NewClientBuilder().WithObjects(mwc(testPath)).Build(), testPath),
+		},
+		"webhook_with_other_path_fails": {
+			check:       preflight.MutatingWebhookCheck(fake.NewClientBuilder().WithObjects(mwc("/other")).Build(), testPath),
+			expectError: true,
+		},
+	}
+
+	for testCaseName, testData := range cases {
+		testData := testData
+
+		t.Run(testCaseName, func(t *testing.T) {
+			t.Parallel()
+
+			if err := testData.check.Run(ctx); (err != nil) != testData.expectError {
+				t.Fatalf("expected error: %v, got: %v", testData.expectError, err)
+			}
+		})
+	}
+}
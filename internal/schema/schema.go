@@ -0,0 +1,192 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package schema generates JSON Schema documents describing Go types from their JSON encoding.
+package schema
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// Draft is a JSON Schema dialect of generated schemas.
+const Draft = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is a subset of JSON Schema used to describe Go types.
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	ID                   string             `json:"$id,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties any                `json:"additionalProperties,omitempty"`
+	Defs                 map[string]*Schema `json:"$defs,omitempty"`
+}
+
+// known maps types with custom JSON encoding used in the configuration to their schemas.
+//
+//nolint:gochecknoglobals
+var known = map[reflect.Type]*Schema{
+	reflect.TypeFor[metav1.Duration](): {Type: "string", Format: "duration"},
+	reflect.TypeFor[metav1.Time]():     {Type: "string", Format: "date-time"},
+	reflect.TypeFor[resource.Quantity](): {
+		AnyOf: []*Schema{{Type: "string"}, {Type: "number"}},
+	},
+	reflect.TypeFor[intstr.IntOrString](): {
+		AnyOf: []*Schema{{Type: "string"}, {Type: "integer"}},
+	},
+}
+
+// For generates schema for a type of a given value. Named struct types are placed in "$defs" section of
+// returned schema and referenced from places where they are used.
+//
+// As the configuration is parsed strictly, generated schemas do not allow properties which are not fields of
+// the structs.
+func For(v any, id, title string) *Schema {
+	g := &generator{
+		defs: map[string]*Schema{},
+	}
+
+	root := g.schema(reflect.TypeOf(v))
+
+	// Inline definition of the root type, so schema describes the document directly.
+	if root.Ref != "" {
+		name := strings.TrimPrefix(root.Ref, defsPrefix)
+		root = g.defs[name]
+		delete(g.defs, name)
+	}
+
+	root.Schema = Draft
+	root.ID = id
+	root.Title = title
+
+	if len(g.defs) > 0 {
+		root.Defs = g.defs
+	}
+
+	return root
+}
+
+const defsPrefix = "#/$defs/"
+
+type generator struct {
+	defs map[string]*Schema
+}
+
+//nolint:cyclop
+func (g *generator) schema(t reflect.Type) *Schema {
+	if s, ok := known[t]; ok {
+		c := *s
+
+		return &c
+	}
+
+	if t.Kind() == reflect.Pointer {
+		return g.schema(t.Elem())
+	}
+
+	// Types with custom decoding which are not known can't be described, so accept any value.
+	if pt := reflect.PointerTo(t); pt.Implements(reflect.TypeFor[json.Unmarshaler]()) ||
+		pt.Implements(reflect.TypeFor[encoding.TextUnmarshaler]()) {
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+
+		return &Schema{Type: "array", Items: g.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schema(t.Elem())}
+	case reflect.Struct:
+		return g.structSchema(t)
+	default:
+		return &Schema{}
+	}
+}
+
+func (g *generator) structSchema(t reflect.Type) *Schema {
+	if t.Name() == "" {
+		return g.object(t)
+	}
+
+	name := strings.ReplaceAll(t.PkgPath(), "/", ".") + "." + t.Name()
+
+	if _, ok := g.defs[name]; !ok {
+		// Register definition before generating it to support recursive types.
+		g.defs[name] = &Schema{}
+		*g.defs[name] = *g.object(t)
+	}
+
+	return &Schema{Ref: defsPrefix + name}
+}
+
+func (g *generator) object(t reflect.Type) *Schema {
+	s := &Schema{
+		Type:                 "object",
+		Properties:           map[string]*Schema{},
+		AdditionalProperties: false,
+	}
+
+	g.addProperties(s, t)
+
+	return s
+}
+
+func (g *generator) addProperties(s *Schema, t reflect.Type) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+
+		// Embedded structs without explicit name have their fields promoted, same as by encoding/json.
+		if f.Anonymous && (name == "" || strings.Contains(opts, "inline")) {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+
+			if ft.Kind() == reflect.Struct {
+				g.addProperties(s, ft)
+
+				continue
+			}
+		}
+
+		if !f.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		s.Properties[name] = g.schema(f.Type)
+	}
+}
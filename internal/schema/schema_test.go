@@ -0,0 +1,67 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package schema_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/newrelic/newrelic-infra-operator/internal/schema"
+)
+
+type Embedded struct {
+	Inlined string `json:"inlined"`
+}
+
+type Node struct {
+	Embedded `json:",inline"`
+
+	Name     string            `json:"name"`
+	Wait     metav1.Duration   `json:"wait"`
+	Labels   map[string]string `json:"labels"`
+	Children []*Node           `json:"children"`
+	Ignored  string            `json:"-"`
+}
+
+func Test_For(t *testing.T) {
+	t.Parallel()
+
+	nodeRef := &schema.Schema{Ref: "#/$defs/github.com.newrelic.newrelic-infra-operator.internal.schema_test.Node"}
+
+	nodeSchema := &schema.Schema{
+		Type: "object",
+		Properties: map[string]*schema.Schema{
+			"inlined":  {Type: "string"},
+			"name":     {Type: "string"},
+			"wait":     {Type: "string", Format: "duration"},
+			"labels":   {Type: "object", AdditionalProperties: &schema.Schema{Type: "string"}},
+			"children": {Type: "array", Items: nodeRef},
+		},
+		AdditionalProperties: false,
+	}
+
+	expected := &schema.Schema{
+		Schema: schema.Draft,
+		ID:     "test-id",
+		Title:  "test",
+		Type:   "object",
+		Properties: map[string]*schema.Schema{
+			"root": nodeRef,
+		},
+		AdditionalProperties: false,
+		Defs: map[string]*schema.Schema{
+			"github.com.newrelic.newrelic-infra-operator.internal.schema_test.Node": nodeSchema,
+		},
+	}
+
+	type document struct {
+		Root Node `json:"root"`
+	}
+
+	if diff := cmp.Diff(expected, schema.For(document{}, "test-id", "test")); diff != "" {
+		t.Fatalf("unexpected schema: %s", diff)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package tracing configures OpenTelemetry tracing of the operator.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	// InstrumentationName is a name of the tracer used by operator components.
+	InstrumentationName = "github.com/newrelic/newrelic-infra-operator"
+
+	// DefaultServiceName is a default value of "service.name" resource attribute of exported spans.
+	DefaultServiceName = "newrelic-infra-operator"
+
+	// DefaultSamplingRatio is a default ratio of admission requests which get traced.
+	DefaultSamplingRatio = 1.0
+)
+
+// Config holds configuration of tracing. Spans are exported using OTLP over HTTP.
+type Config struct {
+	Enabled bool `json:"enabled"`
+	// Endpoint is a URL of OTLP/HTTP collector, e.g. "http://otel-collector:4318". If empty, standard
+	// OTEL_EXPORTER_OTLP_ENDPOINT environment variable or exporter default is used.
+	Endpoint string `json:"endpoint"`
+	// SamplingRatio is a ratio of traces started by the operator, which are sampled. Sampling decision of
+	// the parent span is respected. Defaults to DefaultSamplingRatio, 0 samples only traces with sampled parent.
+	SamplingRatio *float64 `json:"samplingRatio"`
+	ServiceName   string   `json:"serviceName"`
+}
+
+// Provider provides tracers and must be shut down to flush pending spans.
+type Provider interface {
+	trace.TracerProvider
+	Shutdown(ctx context.Context) error
+}
+
+type noopProvider struct {
+	noop.TracerProvider
+}
+
+func (noopProvider) Shutdown(context.Context) error { return nil }
+
+// New creates tracer provider from the configuration. If tracing is disabled, provider creating no-op tracers
+// is returned.
+//
+//nolint:ireturn
+func (config Config) New(ctx context.Context) (Provider, error) {
+	if err := config.Validate(field.NewPath("tracing")).ToAggregate(); err != nil {
+		return nil, err
+	}
+
+	if !config.Enabled {
+		return noopProvider{}, nil
+	}
+
+	samplingRatio := ptr.Deref(config.SamplingRatio, DefaultSamplingRatio)
+
+	serviceName := config.ServiceName
+	if serviceName == "" {
+		serviceName = DefaultServiceName
+	}
+
+	exporterOptions := []otlptracehttp.Option{}
+	if config.Endpoint != "" {
+		exporterOptions = append(exporterOptions, otlptracehttp.WithEndpointURL(config.Endpoint))
+	}
+
+	exporter, err := otlptracehttp.New(ctx, exporterOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("creating OTLP exporter: %w", err)
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(samplingRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+	), nil
+}
+
+// Validate validates the configuration, returning all found errors with paths of invalid fields relative to
+// given path.
+func (config Config) Validate(fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if ratio := config.SamplingRatio; ratio != nil && (*ratio < 0 || *ratio > 1) {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("samplingRatio"), *ratio, "must be between 0 and 1"))
+	}
+
+	return allErrs
+}
+
+// Tracer returns operator tracer from given provider or no-op tracer, if provider is nil.
+//
+//nolint:ireturn
+func Tracer(provider trace.TracerProvider) trace.Tracer {
+	if provider == nil {
+		provider = noop.NewTracerProvider()
+	}
+
+	return provider.Tracer(InstrumentationName)
+}
+
+// End records given error, if any, on the span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
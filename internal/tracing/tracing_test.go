@@ -0,0 +1,165 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package tracing_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	collectortracev1 "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+	"k8s.io/utils/ptr"
+
+	"github.com/newrelic/newrelic-infra-operator/internal/testutil"
+	"github.com/newrelic/newrelic-infra-operator/internal/tracing"
+)
+
+//nolint:funlen
+func Test_Tracing(t *testing.T) {
+	t.Parallel()
+
+	ctx := testutil.ContextWithDeadline(t)
+
+	t.Run("exports_spans_to_configured_collector", func(t *testing.T) {
+		t.Parallel()
+
+		collector := &testCollector{}
+		server := httptest.NewServer(collector)
+		t.Cleanup(server.Close)
+
+		provider, err := tracing.Config{
+			Enabled:     true,
+			Endpoint:    server.URL,
+			ServiceName: "test-service",
+		}.New(ctx)
+		if err != nil {
+			t.Fatalf("creating tracer provider: %v", err)
+		}
+
+		_, span := tracing.Tracer(provider).Start(ctx, "test-span")
+		span.End()
+
+		if err := provider.Shutdown(ctx); err != nil {
+			t.Fatalf("shutting down tracer provider: %v", err)
+		}
+
+		names := collector.spanNames(t)
+		if len(names) != 1 || names[0] != "test-span" {
+			t.Fatalf("expected exactly one span named %q, got %v", "test-span", names)
+		}
+	})
+
+	t.Run("does_not_sample_spans_without_parent_when_sampling_ratio_is_zero", func(t *testing.T) {
+		t.Parallel()
+
+		collector := &testCollector{}
+		server := httptest.NewServer(collector)
+		t.Cleanup(server.Close)
+
+		provider, err := tracing.Config{
+			Enabled:       true,
+			Endpoint:      server.URL,
+			SamplingRatio: ptr.To(0.0),
+		}.New(ctx)
+		if err != nil {
+			t.Fatalf("creating tracer provider: %v", err)
+		}
+
+		_, span := tracing.Tracer(provider).Start(ctx, "test-span")
+		span.End()
+
+		if err := provider.Shutdown(ctx); err != nil {
+			t.Fatalf("shutting down tracer provider: %v", err)
+		}
+
+		if names := collector.spanNames(t); len(names) != 0 {
+			t.Fatalf("expected no spans to be exported, got %v", names)
+		}
+	})
+
+	t.Run("creates_no-op_provider_when_disabled", func(t *testing.T) {
+		t.Parallel()
+
+		provider, err := tracing.Config{}.New(ctx)
+		if err != nil {
+			t.Fatalf("creating tracer provider: %v", err)
+		}
+
+		_, span := tracing.Tracer(provider).Start(ctx, "test-span")
+		if span.SpanContext().IsValid() {
+			t.Fatalf("expected span to be no-op")
+		}
+	})
+
+	t.Run("fails_when_sampling_ratio_is_out_of_range", func(t *testing.T) {
+		t.Parallel()
+
+		if _, err := (tracing.Config{Enabled: true, SamplingRatio: ptr.To(2.0)}).New(ctx); err == nil {
+			t.Fatalf("expected error when creating tracer provider")
+		}
+	})
+}
+
+// testCollector is an in-process OTLP/HTTP collector storing received requests.
+type testCollector struct {
+	lock     sync.Mutex
+	requests []*collectortracev1.ExportTraceServiceRequest
+	errors   []error
+}
+
+func (c *testCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		c.errors = append(c.errors, err)
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	request := &collectortracev1.ExportTraceServiceRequest{}
+	if err := proto.Unmarshal(body, request); err != nil {
+		c.errors = append(c.errors, err)
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	c.requests = append(c.requests, request)
+
+	response, _ := proto.Marshal(&collectortracev1.ExportTraceServiceResponse{})
+
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	_, _ = w.Write(response)
+}
+
+func (c *testCollector) spanNames(t *testing.T) []string {
+	t.Helper()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if len(c.errors) > 0 {
+		t.Fatalf("collector received invalid requests: %v", c.errors)
+	}
+
+	names := []string{}
+
+	for _, request := range c.requests {
+		for _, resourceSpans := range request.GetResourceSpans() {
+			for _, scopeSpans := range resourceSpans.GetScopeSpans() {
+				for _, span := range scopeSpans.GetSpans() {
+					names = append(names, span.GetName())
+				}
+			}
+		}
+	}
+
+	return names
+}
@@ -0,0 +1,146 @@
+// Copyright 2022 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package webhook
+
+import (
+	"strconv"
+	"strings"
+
+	"gomodules.xyz/jsonpatch/v2"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	operationAdd     = "add"
+	operationReplace = "replace"
+)
+
+// Patch collects JSON Patch operations describing changes made to the Pod by the mutators. Operations are
+// applied in order to the Pod from admission request, so each mutator must produce operations relative to the
+// Pod as modified by the previous mutators.
+type Patch struct {
+	Operations []jsonpatch.JsonPatchOperation
+}
+
+// AddPatch appends given operations to the request patch, if request has patch collection enabled.
+func (o RequestOptions) AddPatch(operations ...jsonpatch.JsonPatchOperation) {
+	if o.Patch != nil {
+		o.Patch.Operations = append(o.Patch.Operations, operations...)
+	}
+}
+
+// AddContainer returns operation adding given container to the Pod.
+func AddContainer(container corev1.Container) jsonpatch.JsonPatchOperation {
+	return jsonpatch.NewOperation(operationAdd, "/spec/containers/-", container)
+}
+
+// ReplaceContainer returns operation replacing container with given index in the Pod.
+func ReplaceContainer(index int, container corev1.Container) jsonpatch.JsonPatchOperation {
+	return jsonpatch.NewOperation(operationReplace, "/spec/containers/"+strconv.Itoa(index), container)
+}
+
+// AddInitContainer returns operation adding given init container to the Pod with given existing init containers.
+// Empty list of init containers is replaced as a whole, as it may be omitted from the Pod.
+func AddInitContainer(existing []corev1.Container, container corev1.Container) jsonpatch.JsonPatchOperation {
+	if len(existing) == 0 {
+		return jsonpatch.NewOperation(operationAdd, "/spec/initContainers", []corev1.Container{container})
+	}
+
+	return jsonpatch.NewOperation(operationAdd, "/spec/initContainers/-", container)
+}
+
+// ReplaceInitContainer returns operation replacing init container with given index in the Pod.
+func ReplaceInitContainer(index int, container corev1.Container) jsonpatch.JsonPatchOperation {
+	return jsonpatch.NewOperation(operationReplace, "/spec/initContainers/"+strconv.Itoa(index), container)
+}
+
+// ReplaceVolume returns operation replacing volume with given index in the Pod.
+func ReplaceVolume(index int, volume corev1.Volume) jsonpatch.JsonPatchOperation {
+	return jsonpatch.NewOperation(operationReplace, "/spec/volumes/"+strconv.Itoa(index), volume)
+}
+
+// AddVolumes returns operations adding given volumes to the Pod with given existing volumes. Empty list of
+// existing volumes is replaced as a whole, as it may be omitted from the Pod.
+func AddVolumes(existing []corev1.Volume, volumes []corev1.Volume) []jsonpatch.JsonPatchOperation {
+	if len(volumes) == 0 {
+		return nil
+	}
+
+	if len(existing) == 0 {
+		return []jsonpatch.JsonPatchOperation{jsonpatch.NewOperation(operationAdd, "/spec/volumes", volumes)}
+	}
+
+	operations := make([]jsonpatch.JsonPatchOperation, 0, len(volumes))
+
+	for _, volume := range volumes {
+		operations = append(operations, jsonpatch.NewOperation(operationAdd, "/spec/volumes/-", volume))
+	}
+
+	return operations
+}
+
+// SetTerminationGracePeriod returns operation setting termination grace period of the Pod. "add" operation
+// replaces the value if it is already set.
+func SetTerminationGracePeriod(seconds int64) jsonpatch.JsonPatchOperation {
+	return jsonpatch.NewOperation(operationAdd, "/spec/terminationGracePeriodSeconds", seconds)
+}
+
+// AddEnv returns operations adding given environment variables to the container with given index and existing
+// environment variables.
+func AddEnv(containerIndex int, existing []corev1.EnvVar, env []corev1.EnvVar) []jsonpatch.JsonPatchOperation {
+	return addEnv("/spec/containers/"+strconv.Itoa(containerIndex)+"/env", existing, env)
+}
+
+// AddInitContainerEnv returns operations adding given environment variables to the init container with given index
+// and existing environment variables.
+func AddInitContainerEnv(
+	containerIndex int,
+	existing []corev1.EnvVar,
+	env []corev1.EnvVar,
+) []jsonpatch.JsonPatchOperation {
+	return addEnv("/spec/initContainers/"+strconv.Itoa(containerIndex)+"/env", existing, env)
+}
+
+func addEnv(path string, existing []corev1.EnvVar, env []corev1.EnvVar) []jsonpatch.JsonPatchOperation {
+	if len(env) == 0 {
+		return nil
+	}
+
+	if len(existing) == 0 {
+		return []jsonpatch.JsonPatchOperation{jsonpatch.NewOperation(operationAdd, path, env)}
+	}
+
+	operations := make([]jsonpatch.JsonPatchOperation, 0, len(env))
+
+	for _, e := range env {
+		operations = append(operations, jsonpatch.NewOperation(operationAdd, path+"/-", e))
+	}
+
+	return operations
+}
+
+// AddLabel returns operation setting label on the Pod with given existing labels.
+func AddLabel(existing map[string]string, key, value string) jsonpatch.JsonPatchOperation {
+	return addToMap("/metadata/labels", existing, key, value)
+}
+
+// AddAnnotation returns operation setting annotation on the Pod with given existing annotations.
+func AddAnnotation(existing map[string]string, key, value string) jsonpatch.JsonPatchOperation {
+	return addToMap("/metadata/annotations", existing, key, value)
+}
+
+// addToMap returns operation setting key in map under given path. "add" operation replaces the value if key
+// already exists. Empty maps are replaced as a whole, as they may be omitted from the Pod.
+func addToMap(path string, existing map[string]string, key, value string) jsonpatch.JsonPatchOperation {
+	if len(existing) == 0 {
+		return jsonpatch.NewOperation(operationAdd, path, map[string]string{key: value})
+	}
+
+	return jsonpatch.NewOperation(operationAdd, path+"/"+escapePointer(key), value)
+}
+
+// escapePointer escapes given JSON Pointer reference token as described in RFC 6901.
+func escapePointer(token string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
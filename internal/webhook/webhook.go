@@ -8,4 +8,28 @@ package webhook
 type RequestOptions struct {
 	Namespace string
 	DryRun    bool
+
+	// Decision, if set, will be filled by the mutator with the details of decision it made for the request.
+	Decision *Decision
+
+	// Patch, if set, will be filled by the mutator with JSON Patch operations describing changes it made to the Pod.
+	Patch *Patch
+}
+
+// Decision describes what mutator decided to do with the Pod and why.
+type Decision struct {
+	Inject bool
+	Reason string
+	// PolicyIndex is an index of injection policy which matched the Pod or -1 if none of them matched.
+	PolicyIndex int
+	// Rule identifies the configuration rule which decided about the injection, if any.
+	Rule       string
+	ConfigHash string
+}
+
+// Record stores given decision if request has decision recording enabled.
+func (o RequestOptions) Record(decision Decision) {
+	if o.Decision != nil {
+		*o.Decision = decision
+	}
 }
@@ -4,6 +4,9 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
 	"os"
 
 	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
@@ -12,22 +15,52 @@ import (
 	"github.com/newrelic/newrelic-infra-operator/internal/operator"
 
 	ctrl "sigs.k8s.io/controller-runtime"
-	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+//go:generate sh -c "go run . schema > operator.schema.json"
+
+const (
+	validateCommand = "validate"
+	schemaCommand   = "schema"
 )
 
 func main() {
-	ctrl.SetLogger(zap.New())
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case validateCommand:
+			os.Exit(validate(os.Args[2:]))
+		case schemaCommand:
+			if err := cli.WriteSchema(os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "Generating schema: %v\n", err)
+				os.Exit(1)
+			}
 
-	entryLog := ctrl.Log.WithName("entrypoint")
+			os.Exit(0)
+		}
+	}
 
-	entryLog.Info("Starting NewRelic infra operator")
+	options, err := cli.Load(os.Args[1:], os.Getenv)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
 
-	options, err := cli.Options(cli.DefaultConfigFilePath)
+		fmt.Fprintf(os.Stderr, "Generating operator configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	logger, err := cli.NewLogger(*options)
 	if err != nil {
-		entryLog.Error(err, "Generation operator configuration")
+		fmt.Fprintf(os.Stderr, "Creating logger: %v\n", err)
 		os.Exit(1)
 	}
 
+	ctrl.SetLogger(logger)
+
+	entryLog := ctrl.Log.WithName("entrypoint")
+
+	entryLog.Info("Starting NewRelic infra operator")
+
 	options.Logger = entryLog.WithName("PodMutatorLogger")
 
 	if err := operator.Run(signals.SetupSignalHandler(), *options); err != nil {
@@ -35,3 +68,34 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// validate runs validate subcommand with given arguments and returns exit code.
+func validate(args []string) int {
+	flags := flag.NewFlagSet(validateCommand, flag.ContinueOnError)
+	configPath := flags.String("config", cli.DefaultConfigFilePath, "Path to the configuration file to validate.")
+	output := flags.String("output", cli.OutputText,
+		fmt.Sprintf("Output format, one of %q, %q.", cli.OutputText, cli.OutputJSON))
+
+	if err := flags.Parse(args); err != nil {
+		return 2 //nolint:mnd
+	}
+
+	diagnostics, err := cli.Validate(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Validating configuration: %v\n", err)
+
+		return 1
+	}
+
+	if err := cli.WriteDiagnostics(os.Stdout, diagnostics, *output); err != nil {
+		fmt.Fprintf(os.Stderr, "Printing diagnostics: %v\n", err)
+
+		return 1
+	}
+
+	if cli.HasErrors(diagnostics) {
+		return 1
+	}
+
+	return 0
+}